@@ -0,0 +1,171 @@
+package inmemorydb
+
+import (
+	"fmt"
+	"sync"
+)
+
+const adminRole = "admin"
+
+// AccessControl keeps the users and roles of a shared database. Roles grant a
+// permission per table, or on AllTables, and users get the highest permission
+// of any of their roles. Changes apply to open sessions immediately.
+type AccessControl struct {
+	db    Database
+	users map[string]map[string]bool       // User -> Set of roles
+	roles map[string]map[string]Permission // Role -> Table -> Permission
+	mu    sync.RWMutex
+}
+
+// NewAccessControl guards db and creates adminUser with admin rights on all
+// tables, so that it can create the other users and roles.
+func NewAccessControl(db Database, adminUser string) *AccessControl {
+	return &AccessControl{
+		db:    db,
+		users: map[string]map[string]bool{adminUser: {adminRole: true}},
+		roles: map[string]map[string]Permission{adminRole: {AllTables: PermissionAdmin}},
+	}
+}
+
+// Session is a Database bound to a user. Every call is checked against the
+// user's grants and denied with a *PermissionDeniedError.
+type Session struct {
+	*guardedDB
+	control *AccessControl
+	user    string
+}
+
+func (ac *AccessControl) OpenSession(user string) (*Session, error) {
+	ac.mu.RLock()
+	_, exists := ac.users[user]
+	ac.mu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownUser, user)
+	}
+
+	session := &Session{control: ac, user: user}
+	session.guardedDB = &guardedDB{db: ac.db, check: session.require}
+	return session, nil
+}
+
+func (s *Session) User() string {
+	return s.user
+}
+
+func (s *Session) require(tableName string, required Permission) error {
+	if s.control.permission(s.user, tableName) < required {
+		return &PermissionDeniedError{User: s.user, Table: tableName, Required: required}
+	}
+	return nil
+}
+
+// permission returns the highest permission any role of user has on table.
+func (ac *AccessControl) permission(user, tableName string) Permission {
+	ac.mu.RLock()
+	defer ac.mu.RUnlock()
+	granted := PermissionNone
+	for role := range ac.users[user] {
+		grants := ac.roles[role]
+		granted = max(granted, grants[AllTables])
+		if tableName != AllTables {
+			granted = max(granted, grants[tableName])
+		}
+	}
+	return granted
+}
+
+// CreateUser adds a user without any roles. Requires admin on all tables.
+func (s *Session) CreateUser(user string) error {
+	if err := s.require(AllTables, PermissionAdmin); err != nil {
+		return err
+	}
+	s.control.mu.Lock()
+	defer s.control.mu.Unlock()
+	if _, exists := s.control.users[user]; exists {
+		return fmt.Errorf("%w: %s", ErrUserExists, user)
+	}
+	s.control.users[user] = make(map[string]bool)
+	return nil
+}
+
+// DropUser removes a user. Its open sessions lose all access.
+func (s *Session) DropUser(user string) error {
+	if err := s.require(AllTables, PermissionAdmin); err != nil {
+		return err
+	}
+	s.control.mu.Lock()
+	defer s.control.mu.Unlock()
+	if _, exists := s.control.users[user]; !exists {
+		return fmt.Errorf("%w: %s", ErrUnknownUser, user)
+	}
+	delete(s.control.users, user)
+	return nil
+}
+
+// CreateRole adds a role without any grants. Requires admin on all tables.
+func (s *Session) CreateRole(role string) error {
+	if err := s.require(AllTables, PermissionAdmin); err != nil {
+		return err
+	}
+	s.control.mu.Lock()
+	defer s.control.mu.Unlock()
+	if _, exists := s.control.roles[role]; exists {
+		return fmt.Errorf("%w: %s", ErrRoleExists, role)
+	}
+	s.control.roles[role] = make(map[string]Permission)
+	return nil
+}
+
+// AssignRole gives user the grants of role. Requires admin on all tables.
+func (s *Session) AssignRole(user, role string) error {
+	return s.updateMembership(user, role, true)
+}
+
+func (s *Session) RemoveRole(user, role string) error {
+	return s.updateMembership(user, role, false)
+}
+
+func (s *Session) updateMembership(user, role string, member bool) error {
+	if err := s.require(AllTables, PermissionAdmin); err != nil {
+		return err
+	}
+	s.control.mu.Lock()
+	defer s.control.mu.Unlock()
+	roles, exists := s.control.users[user]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrUnknownUser, user)
+	}
+	if _, exists := s.control.roles[role]; !exists {
+		return fmt.Errorf("%w: %s", ErrUnknownRole, role)
+	}
+	if member {
+		roles[role] = true
+	} else {
+		delete(roles, role)
+	}
+	return nil
+}
+
+// Grant sets the permission of role on a table, or on AllTables. Admins of a
+// table may grant on that table.
+func (s *Session) Grant(role, tableName string, permission Permission) error {
+	if err := s.require(tableName, PermissionAdmin); err != nil {
+		return err
+	}
+	s.control.mu.Lock()
+	defer s.control.mu.Unlock()
+	grants, exists := s.control.roles[role]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrUnknownRole, role)
+	}
+	if permission == PermissionNone {
+		delete(grants, tableName)
+	} else {
+		grants[tableName] = permission
+	}
+	return nil
+}
+
+func (s *Session) Revoke(role, tableName string) error {
+	return s.Grant(role, tableName, PermissionNone)
+}
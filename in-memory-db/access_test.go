@@ -0,0 +1,105 @@
+package inmemorydb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSessionPermissions(t *testing.T) {
+	read := func(s *Session) error { _, _, err := s.Get("orders", "1"); return err }
+	write := func(s *Session) error { return s.Insert("orders", "2", Record{"item": "pen"}) }
+	index := func(s *Session) error { return s.CreateIndex("orders", "item") }
+	createUser := func(s *Session) error { return s.CreateUser("dave") }
+
+	tests := []struct {
+		name   string
+		grants map[string]Permission // Table -> Permission of the clerk role
+		op     func(s *Session) error
+		denied bool
+	}{
+		{name: "read without grant", op: read, denied: true},
+		{name: "read with read grant", grants: map[string]Permission{"orders": PermissionRead}, op: read},
+		{name: "read with a grant on another table", grants: map[string]Permission{"audit": PermissionAdmin}, op: read, denied: true},
+		{name: "read with a grant on all tables", grants: map[string]Permission{AllTables: PermissionRead}, op: read},
+		{name: "write with read grant", grants: map[string]Permission{"orders": PermissionRead}, op: write, denied: true},
+		{name: "write with write grant", grants: map[string]Permission{"orders": PermissionWrite}, op: write},
+		{name: "index with write grant", grants: map[string]Permission{"orders": PermissionWrite}, op: index, denied: true},
+		{name: "index with admin grant", grants: map[string]Permission{"orders": PermissionAdmin}, op: index},
+		{name: "create user as table admin", grants: map[string]Permission{"orders": PermissionAdmin}, op: createUser, denied: true},
+		{name: "create user as admin of all tables", grants: map[string]Permission{AllTables: PermissionAdmin}, op: createUser},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			control, root := newAccessFixture(t)
+			for table, permission := range tt.grants {
+				mustSucceed(t, root.Grant("clerk", table, permission))
+			}
+			carol, err := control.OpenSession("carol")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = tt.op(carol)
+			var denied *PermissionDeniedError
+			if got := errors.As(err, &denied); got != tt.denied {
+				t.Fatalf("error = %v, want permission denied: %t", err, tt.denied)
+			}
+			if !tt.denied && err != nil {
+				t.Fatal(err)
+			}
+			if tt.denied && (denied.User != "carol" || !errors.Is(err, ErrPermissionDenied)) {
+				t.Errorf("error = %v, want carol to be denied", err)
+			}
+		})
+	}
+}
+
+func TestSessionSeesGrantChanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(root *Session) error
+	}{
+		{name: "revoke", change: func(root *Session) error { return root.Revoke("clerk", "orders") }},
+		{name: "remove role", change: func(root *Session) error { return root.RemoveRole("carol", "clerk") }},
+		{name: "drop user", change: func(root *Session) error { return root.DropUser("carol") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			control, root := newAccessFixture(t)
+			mustSucceed(t, root.Grant("clerk", "orders", PermissionRead))
+			carol, err := control.OpenSession("carol")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := carol.Get("orders", "1"); err != nil {
+				t.Fatal(err)
+			}
+
+			mustSucceed(t, tt.change(root))
+			if _, _, err := carol.Get("orders", "1"); !errors.Is(err, ErrPermissionDenied) {
+				t.Errorf("Get() error = %v, want %v", err, ErrPermissionDenied)
+			}
+		})
+	}
+}
+
+// newAccessFixture guards a database holding an orders table and returns the
+// session of its admin, root. carol is a user with the role clerk, which has
+// no grants yet.
+func newAccessFixture(t *testing.T) (*AccessControl, *Session) {
+	t.Helper()
+	control := NewAccessControl(NewInMemoryDB(), "root")
+	root, err := control.OpenSession("root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSucceed(t, root.CreateTable("orders", map[string]string{"item": "string"}))
+	mustSucceed(t, root.CreateTable("audit", map[string]string{"action": "string"}))
+	mustSucceed(t, root.Insert("orders", "1", Record{"item": "book"}))
+	mustSucceed(t, root.CreateRole("clerk"))
+	mustSucceed(t, root.CreateUser("carol"))
+	mustSucceed(t, root.AssignRole("carol", "clerk"))
+	return control, root
+}
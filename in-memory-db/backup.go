@@ -0,0 +1,317 @@
+package inmemorydb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"time"
+)
+
+// Backup layout:
+//
+//	magic "IMDB" | format version (uint16) | payload length (uint64) | payload | CRC-32C of payload (uint32)
+//
+// The payload is a gob encoded backupImage. All integers are big endian.
+const (
+	backupMagic          = "IMDB"
+	backupFormatVersion  = 2
+	maxBackupPayloadSize = 1 << 32
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type backupImage struct {
+	Tables []backupTable
+}
+
+type backupTable struct {
+	Name        string
+	Schema      map[string]string
+	Indexes     []backupIndex
+	LastVersion uint64
+	Rows        []backupRow
+	View        *ViewQuery // Set for materialized views, which are recomputed instead of stored
+}
+
+type backupIndex struct {
+	Columns []string
+	Unique  bool
+}
+
+type backupRow struct {
+	Key     string
+	Version uint64
+	Record  Record
+}
+
+// Backup writes a consistent snapshot of all tables, their schemas and index
+// definitions to w. Writers are only blocked while the rows are copied, not
+// while the snapshot is encoded and written.
+func (db *InMemoryDB) Backup(w io.Writer) error {
+	return db.BackupContext(context.Background(), w)
+}
+
+func (db *InMemoryDB) BackupContext(ctx context.Context, w io.Writer) error {
+	defer db.observe("backup", time.Now())
+	image, err := db.snapshotImage(ctx)
+	if err != nil {
+		return err
+	}
+	return writeBackup(w, image)
+}
+
+func writeBackup(w io.Writer, image backupImage) error {
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(image); err != nil {
+		return fmt.Errorf("encoding backup: %w", err)
+	}
+
+	header := make([]byte, 0, len(backupMagic)+2+8)
+	header = append(header, backupMagic...)
+	header = binary.BigEndian.AppendUint16(header, backupFormatVersion)
+	header = binary.BigEndian.AppendUint64(header, uint64(payload.Len()))
+	checksum := binary.BigEndian.AppendUint32(nil, crc32.Checksum(payload.Bytes(), crcTable))
+
+	for _, chunk := range [][]byte{header, payload.Bytes(), checksum} {
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotImage copies every table while holding all table locks at once, so
+// the image reflects a single point in time.
+func (db *InMemoryDB) snapshotImage(ctx context.Context) (backupImage, error) {
+	// Locking several tables at once is only safe while holding scopeLock.
+	db.scopeLock.Lock()
+	defer db.scopeLock.Unlock()
+	db.dbLock.RLock()
+	defer db.dbLock.RUnlock()
+
+	names := make([]string, 0, len(db.tables))
+	for name := range db.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	image := backupImage{Tables: make([]backupTable, 0, len(names))}
+	for _, name := range names {
+		table := db.tables[name]
+		table.rlockData()
+		defer table.dataLock.RUnlock()
+		table.indexLock.RLock()
+		defer table.indexLock.RUnlock()
+
+		snapshot := backupTable{
+			Name:        name,
+			Schema:      cloneSchema(table.schema),
+			LastVersion: table.lastVersion,
+			Rows:        make([]backupRow, 0, len(table.data)),
+		}
+		for _, index := range table.indexes {
+			snapshot.Indexes = append(snapshot.Indexes, backupIndex{Columns: index.columns, Unique: index.unique})
+		}
+		if table.view != nil {
+			query := table.view.query
+			snapshot.View = &query
+			image.Tables = append(image.Tables, snapshot)
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return image, err
+		}
+		for key, record := range table.data {
+			if len(snapshot.Rows)%scanCheckInterval == 0 {
+				if err := ctx.Err(); err != nil {
+					return image, err
+				}
+			}
+			snapshot.Rows = append(snapshot.Rows, backupRow{Key: key, Version: table.versions[key], Record: record})
+		}
+		image.Tables = append(image.Tables, snapshot)
+	}
+	return image, nil
+}
+
+// Restore loads a backup written by Backup. The checksum, every row and every
+// view are validated before anything is changed, and indexes and views are
+// rebuilt from the restored rows. None of the restored tables may exist yet.
+func (db *InMemoryDB) Restore(r io.Reader) error {
+	return db.RestoreContext(context.Background(), r)
+}
+
+func (db *InMemoryDB) RestoreContext(ctx context.Context, r io.Reader) error {
+	defer db.observe("restore", time.Now())
+	image, err := readBackup(r)
+	if err != nil {
+		return err
+	}
+
+	tables := make(map[string]*Table, len(image.Tables))
+	seen := make(map[string]bool, len(image.Tables))
+	var views []backupTable
+	for _, snapshot := range image.Tables {
+		if seen[snapshot.Name] {
+			return fmt.Errorf("%w: table %s appears twice", ErrCorruptBackup, snapshot.Name)
+		}
+		seen[snapshot.Name] = true
+		if snapshot.View != nil {
+			views = append(views, snapshot)
+			continue
+		}
+		table := &Table{
+			name:        snapshot.Name,
+			schema:      snapshot.Schema,
+			data:        make(map[string]Record, len(snapshot.Rows)),
+			versions:    make(map[string]uint64, len(snapshot.Rows)),
+			indexes:     make(map[string]*index),
+			lastVersion: snapshot.LastVersion,
+		}
+		for _, def := range snapshot.Indexes {
+			if err := checkIndexColumns(table, def.Columns); err != nil {
+				return fmt.Errorf("%w: table %s: %v", ErrCorruptBackup, snapshot.Name, err)
+			}
+			table.indexes[indexName(def.Columns)] = newIndex(def.Columns, def.Unique)
+		}
+		for i, row := range snapshot.Rows {
+			if i%scanCheckInterval == 0 {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+			}
+			if err := table.validate(row.Record); err != nil {
+				return fmt.Errorf("%w: table %s, row %s: %v", ErrCorruptBackup, snapshot.Name, row.Key, err)
+			}
+			if err := table.checkUnique(row.Key, row.Record); err != nil {
+				return fmt.Errorf("%w: %v", ErrCorruptBackup, err)
+			}
+			table.put(row.Key, row.Record, row.Version)
+		}
+		tables[snapshot.Name] = table
+	}
+
+	// Views are recomputed from their restored sources, which are not
+	// published yet, so an invalid view leaves the database unchanged.
+	for _, snapshot := range views {
+		view, err := restoreView(snapshot, tables)
+		if err != nil {
+			return fmt.Errorf("%w: view %s: %v", ErrCorruptBackup, snapshot.Name, err)
+		}
+		tables[snapshot.Name] = view.table
+	}
+
+	return db.installTables(ctx, image, tables)
+}
+
+// restoreView computes the view of snapshot over its restored source in
+// tables and attaches it to the source.
+func restoreView(snapshot backupTable, tables map[string]*Table) (*materializedView, error) {
+	source, restored := tables[snapshot.View.Table]
+	if !restored {
+		return nil, fmt.Errorf("%w: %s", ErrTableNotFound, snapshot.View.Table)
+	}
+	view, err := newMaterializedView(snapshot.Name, source, *snapshot.View)
+	if err != nil {
+		return nil, err
+	}
+	for _, def := range snapshot.Indexes {
+		if err := checkIndexColumns(view.table, def.Columns); err != nil {
+			return nil, err
+		}
+		if def.Unique {
+			return nil, fmt.Errorf("%w: unique index on a view", ErrReadOnlyTable)
+		}
+		index := newIndex(def.Columns, false)
+		for key, record := range view.table.data {
+			index.add(record, key)
+		}
+		view.table.indexes[indexName(def.Columns)] = index
+	}
+	source.views = append(source.views, view)
+	return view, nil
+}
+
+// installTables publishes the restored tables and views together. Base tables
+// are emitted before views, so followers can recompute the views.
+func (db *InMemoryDB) installTables(ctx context.Context, image backupImage, tables map[string]*Table) error {
+	db.dbLock.Lock()
+	defer db.dbLock.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, snapshot := range image.Tables {
+		if _, exists := db.tables[snapshot.Name]; exists {
+			return tableExists(snapshot.Name)
+		}
+	}
+	for _, snapshot := range image.Tables {
+		db.tables[snapshot.Name] = tables[snapshot.Name]
+		if snapshot.View != nil {
+			continue
+		}
+		db.emit(Mutation{Type: MutationCreateTable, Table: snapshot.Name, Schema: cloneSchema(snapshot.Schema)})
+		for _, row := range snapshot.Rows {
+			db.emit(Mutation{Type: MutationInsert, Table: snapshot.Name, Key: row.Key, Record: cloneRecord(row.Record), Version: row.Version})
+		}
+		for _, def := range snapshot.Indexes {
+			db.emit(Mutation{Type: MutationCreateIndex, Table: snapshot.Name, Columns: def.Columns, Unique: def.Unique})
+		}
+	}
+	for _, snapshot := range image.Tables {
+		if snapshot.View == nil {
+			continue
+		}
+		query := *snapshot.View
+		db.emit(Mutation{Type: MutationCreateView, Table: snapshot.Name, View: &query})
+		for _, def := range snapshot.Indexes {
+			db.emit(Mutation{Type: MutationCreateIndex, Table: snapshot.Name, Columns: def.Columns})
+		}
+	}
+	return nil
+}
+
+func readBackup(r io.Reader) (backupImage, error) {
+	var image backupImage
+
+	header := make([]byte, len(backupMagic)+2+8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return image, fmt.Errorf("%w: reading header: %v", ErrCorruptBackup, err)
+	}
+	if string(header[:len(backupMagic)]) != backupMagic {
+		return image, fmt.Errorf("%w: bad magic", ErrCorruptBackup)
+	}
+	if version := binary.BigEndian.Uint16(header[4:6]); version != backupFormatVersion {
+		return image, fmt.Errorf("%w: unsupported format version %d", ErrCorruptBackup, version)
+	}
+	size := binary.BigEndian.Uint64(header[6:])
+	if size > maxBackupPayloadSize {
+		return image, fmt.Errorf("%w: payload of %d bytes is too large", ErrCorruptBackup, size)
+	}
+
+	// Read progressively so a corrupt length cannot force a huge allocation.
+	payload, err := io.ReadAll(io.LimitReader(r, int64(size)))
+	if err != nil {
+		return image, fmt.Errorf("%w: reading payload: %v", ErrCorruptBackup, err)
+	}
+	if uint64(len(payload)) != size {
+		return image, fmt.Errorf("%w: payload truncated", ErrCorruptBackup)
+	}
+	checksum := make([]byte, 4)
+	if _, err := io.ReadFull(r, checksum); err != nil {
+		return image, fmt.Errorf("%w: reading checksum: %v", ErrCorruptBackup, err)
+	}
+	if binary.BigEndian.Uint32(checksum) != crc32.Checksum(payload, crcTable) {
+		return image, fmt.Errorf("%w: checksum mismatch", ErrCorruptBackup)
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&image); err != nil {
+		return image, fmt.Errorf("%w: decoding payload: %v", ErrCorruptBackup, err)
+	}
+	return image, nil
+}
@@ -0,0 +1,163 @@
+package inmemorydb
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestBackupRoundTrip(t *testing.T) {
+	db := NewInMemoryDB()
+	mustSucceed(t, db.CreateTable("users", map[string]string{"name": "string", "age": "int"}))
+	mustSucceed(t, db.Insert("users", "1", Record{"name": "Alice", "age": 30}))
+	mustSucceed(t, db.Insert("users", "2", Record{"name": "Bob", "age": 25}))
+	if _, err := db.UpdateIfVersion("users", "2", Record{"age": 26}, 2); err != nil {
+		t.Fatal(err)
+	}
+	mustSucceed(t, db.CreateUniqueIndex("users", "name"))
+
+	var backup bytes.Buffer
+	mustSucceed(t, db.Backup(&backup))
+	restored := NewInMemoryDB()
+	mustSucceed(t, restored.Restore(&backup))
+
+	tests := []struct {
+		key     string
+		name    string
+		age     int
+		version uint64
+	}{
+		{key: "1", name: "Alice", age: 30, version: 1},
+		{key: "2", name: "Bob", age: 26, version: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			record, version, err := restored.Get("users", tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if record["name"] != tt.name || record["age"] != tt.age || version != tt.version {
+				t.Errorf("Get() = %v at version %d, want %s, %d at version %d", record, version, tt.name, tt.age, tt.version)
+			}
+		})
+	}
+
+	if err := restored.Insert("users", "3", Record{"name": "Alice", "age": 40}); !errors.Is(err, ErrUniqueViolation) {
+		t.Errorf("Insert() error = %v, want the restored unique index to reject it", err)
+	}
+	if err := restored.Restore(bytes.NewReader(backupOf(t, db))); !errors.Is(err, ErrTableExists) {
+		t.Errorf("Restore() over existing tables error = %v, want %v", err, ErrTableExists)
+	}
+}
+
+func TestRestoreCorruptBackup(t *testing.T) {
+	db := NewInMemoryDB()
+	mustSucceed(t, db.CreateTable("users", map[string]string{"name": "string"}))
+	mustSucceed(t, db.Insert("users", "1", Record{"name": "Alice"}))
+	backup := backupOf(t, db)
+
+	tests := []struct {
+		name    string
+		corrupt func(b []byte) []byte
+	}{
+		{name: "empty", corrupt: func(b []byte) []byte { return nil }},
+		{name: "bad magic", corrupt: func(b []byte) []byte { b[0] = 'X'; return b }},
+		{name: "unknown format version", corrupt: func(b []byte) []byte { b[5] = 99; return b }},
+		{name: "older format version", corrupt: func(b []byte) []byte { b[5] = 1; return b }},
+		{name: "flipped payload byte", corrupt: func(b []byte) []byte { b[20] ^= 0xff; return b }},
+		{name: "truncated payload", corrupt: func(b []byte) []byte { return b[:len(b)/2] }},
+		{name: "missing checksum", corrupt: func(b []byte) []byte { return b[:len(b)-4] }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			corrupted := tt.corrupt(append([]byte(nil), backup...))
+			restored := NewInMemoryDB()
+			if err := restored.Restore(bytes.NewReader(corrupted)); !errors.Is(err, ErrCorruptBackup) {
+				t.Fatalf("Restore() error = %v, want %v", err, ErrCorruptBackup)
+			}
+			if tables := restored.(*InMemoryDB).tables; len(tables) != 0 {
+				t.Errorf("a corrupt backup restored %d tables", len(tables))
+			}
+		})
+	}
+}
+
+func TestRestoreViews(t *testing.T) {
+	orders := backupTable{
+		Name:   "orders",
+		Schema: map[string]string{"city": "string", "amount": "int"},
+		Rows: []backupRow{
+			{Key: "1", Version: 1, Record: Record{"city": "Pune", "amount": 10}},
+			{Key: "2", Version: 1, Record: Record{"city": "Mumbai", "amount": 20}},
+		},
+		LastVersion: 1,
+	}
+	view := func(query ViewQuery, indexes ...backupIndex) backupTable {
+		return backupTable{Name: "totals", View: &query, Indexes: indexes}
+	}
+	byCity := ViewQuery{
+		Table:      "orders",
+		GroupBy:    []string{"city"},
+		Aggregates: []Aggregate{{Function: "SUM", Attribute: "amount", Alias: "total"}},
+	}
+
+	tests := []struct {
+		name    string
+		tables  []backupTable
+		wantErr bool
+	}{
+		{name: "view with an index", tables: []backupTable{orders, view(byCity, backupIndex{Columns: []string{"total"}})}},
+		{name: "view sorted before its source", tables: []backupTable{view(byCity), orders}},
+		{name: "sum over a string column", tables: []backupTable{orders, view(ViewQuery{
+			Table:      "orders",
+			Aggregates: []Aggregate{{Function: "SUM", Attribute: "city", Alias: "total"}},
+		})}, wantErr: true},
+		{name: "unknown source", tables: []backupTable{orders, view(ViewQuery{
+			Table:      "payments",
+			Aggregates: []Aggregate{{Function: "COUNT", Alias: "payments"}},
+		})}, wantErr: true},
+		{name: "index on an unknown column", tables: []backupTable{orders, view(byCity, backupIndex{Columns: []string{"amount"}})}, wantErr: true},
+		{name: "unique index", tables: []backupTable{orders, view(byCity, backupIndex{Columns: []string{"total"}, Unique: true})}, wantErr: true},
+		{name: "view over a view", tables: []backupTable{orders, view(byCity), {Name: "totals2", View: &ViewQuery{
+			Table:      "totals",
+			Aggregates: []Aggregate{{Function: "COUNT", Alias: "cities"}},
+		}}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var backup bytes.Buffer
+			mustSucceed(t, writeBackup(&backup, backupImage{Tables: tt.tables}))
+			db := NewInMemoryDB()
+			err := db.Restore(&backup)
+			if tt.wantErr {
+				if !errors.Is(err, ErrCorruptBackup) {
+					t.Fatalf("Restore() error = %v, want %v", err, ErrCorruptBackup)
+				}
+				if tables := db.(*InMemoryDB).tables; len(tables) != 0 {
+					t.Errorf("an invalid view left %d tables restored", len(tables))
+				}
+				return
+			}
+			mustSucceed(t, err)
+
+			mustSucceed(t, db.Insert("orders", "3", Record{"city": "Pune", "amount": 30}))
+			rows, err := db.SelectWithConditions("totals", []string{"city", "total"},
+				[]Condition{{Attribute: "total", Operator: ">", Value: 25}}, "AND")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != 1 || rows[0]["city"] != "Pune" || rows[0]["total"] != 40 {
+				t.Errorf("rows = %v, want the restored view to keep up with new orders", rows)
+			}
+		})
+	}
+}
+
+func backupOf(t *testing.T, db Database) []byte {
+	t.Helper()
+	var backup bytes.Buffer
+	mustSucceed(t, db.Backup(&backup))
+	return backup.Bytes()
+}
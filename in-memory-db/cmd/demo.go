@@ -1,7 +1,12 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	inmemorydb "github.com/vnkdj5/low-level-design/in-memory-db"
 )
@@ -33,6 +38,9 @@ func main() {
 	results, _ = db.Select("users", "name", "age", 25)
 	fmt.Println(results)
 
+	// A composite index on (city, age) answers the query below from its prefix
+	db.CreateIndex("users", "city", "age")
+
 	// Query: Select name and age where city = 'Pune' AND age > 28
 	conditions := []inmemorydb.Condition{
 		{Attribute: "city", Operator: "=", Value: "Pune"},
@@ -46,4 +54,197 @@ func main() {
 	fmt.Println("Query: Select name and age where city = 'Pune' AND age > 28")
 	fmt.Println(results1)
 
+	// Query: Select UPPER(name), age + 5 AS age_in_5_years where LENGTH(name) > 3
+	projections := []inmemorydb.Projection{
+		{Expr: inmemorydb.Upper(inmemorydb.Col("name"))},
+		inmemorydb.As(inmemorydb.Add(inmemorydb.Col("age"), inmemorydb.Lit(5)), "age_in_5_years"),
+	}
+	lengthCondition := []inmemorydb.Condition{
+		{Expr: inmemorydb.Length(inmemorydb.Col("name")), Operator: ">", Value: 3},
+	}
+	results2, err := db.SelectExpressions("users", projections, lengthCondition, "AND")
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Println(results2)
+
+	// Triggers validate writes and keep an audit trail in the same lock scope
+	db.CreateTable("audit", map[string]string{"action": "string", "user": "string"})
+	db.RegisterTrigger("users", inmemorydb.TriggerInsert, inmemorydb.TriggerBefore, func(event *inmemorydb.TriggerEvent) error {
+		if event.New["age"].(int) < 0 {
+			return fmt.Errorf("age must not be negative")
+		}
+		return nil
+	})
+	db.RegisterTrigger("users", inmemorydb.TriggerDelete, inmemorydb.TriggerAfter, func(event *inmemorydb.TriggerEvent) error {
+		return event.Tx.Insert("audit", "delete-"+event.Key, inmemorydb.Record{"action": "delete", "user": event.Old["name"].(string)})
+	})
+	fmt.Println("Insert with negative age:", db.Insert("users", "5", inmemorydb.Record{"name": "Mallory", "age": -1, "city": "Pune"}))
+	db.Insert("users", "5", inmemorydb.Record{"name": "Grace", "age": 28, "city": "Delhi"})
+	db.Delete("users", "5")
+	audit, _ := db.SelectWithConditions("audit", []string{"action", "user"}, nil, "AND")
+	fmt.Println("Audit:", audit)
+
+	// Materialized views are updated on every write to their source
+	err = db.CreateMaterializedView("users_per_city", inmemorydb.ViewQuery{
+		Table:      "users",
+		Conditions: []inmemorydb.Condition{{Attribute: "age", Operator: ">=", Value: 18}},
+		GroupBy:    []string{"city"},
+		Aggregates: []inmemorydb.Aggregate{
+			{Function: "COUNT", Alias: "users"},
+			{Function: "AVG", Attribute: "age", Alias: "average_age"},
+		},
+	})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	db.Insert("users", "6", inmemorydb.Record{"name": "Heidi", "age": 33, "city": "Mumbai"})
+	perCity, _ := db.SelectWithConditions("users_per_city", []string{"users", "average_age"},
+		[]inmemorydb.Condition{{Attribute: "city", Operator: "=", Value: "Mumbai"}}, "AND")
+	fmt.Println("Users in Mumbai:", perCity)
+
+	// Queries can be cancelled or given a deadline
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+	_, err = db.SelectWithConditionsContext(ctx, "users", []string{"name"}, conditions, "AND")
+	fmt.Println("Query after deadline:", err)
+
+	// Dates, durations and money are native column types
+	db.CreateTable("payments", map[string]string{
+		"amount":  inmemorydb.TypeDecimal,
+		"paid_at": inmemorydb.TypeTime,
+		"window":  inmemorydb.TypeDuration,
+	})
+	db.CreateIndex("payments", "paid_at")
+	day := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	for i, amount := range []string{"19.99", "5", "120.50"} {
+		value, _ := inmemorydb.ParseDecimal(amount)
+		db.Insert("payments", fmt.Sprint(i), inmemorydb.Record{
+			"amount":  value,
+			"paid_at": day.AddDate(0, 0, i*10),
+			"window":  time.Duration(i) * time.Hour,
+		})
+	}
+	ten, _ := inmemorydb.NewDecimal(10, 0)
+	paymentConditions := []inmemorydb.Condition{
+		{Attribute: "paid_at", Operator: "BETWEEN", Value: day, SecondValue: day.AddDate(0, 0, 15)},
+		{Attribute: "amount", Operator: ">", Value: ten},
+	}
+	payments, _ := db.SelectWithConditions("payments", []string{"amount"}, paymentConditions, "AND")
+	fmt.Println("Payments over 10 in the first half of March:", payments)
+
+	// Read-modify-write with optimistic concurrency
+	record, version, _ := db.Get("users", "2")
+	newVersion, err := db.UpdateIfVersion("users", "2", inmemorydb.Record{"age": record["age"].(int) + 1}, version)
+	fmt.Println("Update at version", version, "->", newVersion, err)
+	_, err = db.UpdateIfVersion("users", "2", inmemorydb.Record{"age": 99}, version)
+	fmt.Println("Stale update:", err, errors.Is(err, inmemorydb.ErrVersionConflict))
+
+	// Failures can be told apart without matching on the message
+	_, _, err = db.Get("users", "42")
+	fmt.Println("Missing user:", errors.Is(err, inmemorydb.ErrRecordNotFound))
+	err = db.Insert("payments", "42", inmemorydb.Record{"amount": 12.5, "paid_at": day, "window": time.Hour})
+	var schemaErr *inmemorydb.SchemaError
+	if errors.As(err, &schemaErr) {
+		fmt.Println("Rejected column:", schemaErr.Column, "expected", schemaErr.Expected)
+	}
+
+	// Typed tables map structs to records
+	type Employee struct {
+		ID   string `db:"id,key"`
+		Name string `db:"name"`
+		Age  int    `db:"age"`
+	}
+	employees, err := inmemorydb.NewTable[Employee](db, "employees")
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	employees.Insert(Employee{ID: "e1", Name: "Eve", Age: 35})
+	employees.Insert(Employee{ID: "e2", Name: "Frank", Age: 22})
+	employees.CreateUniqueIndex("name")
+	err = employees.Insert(Employee{ID: "e3", Name: "Eve", Age: 41})
+	fmt.Println("Duplicate name:", errors.Is(err, inmemorydb.ErrUniqueViolation), err)
+	seniors, _ := employees.Where("AND", inmemorydb.Field[int]("age").Ge(30))
+	fmt.Printf("Employees aged 30 or more: %+v\n", seniors)
+
+	// Sessions check every call against the grants of the user's roles
+	access := inmemorydb.NewAccessControl(db, "root")
+	root, _ := access.OpenSession("root")
+	root.CreateUser("reporting")
+	root.CreateRole("analyst")
+	root.Grant("analyst", "users", inmemorydb.PermissionRead)
+	root.AssignRole("reporting", "analyst")
+	reporting, _ := access.OpenSession("reporting")
+	results, _ = reporting.Select("users", "name", "city", "Mumbai")
+	fmt.Println("Analyst read:", results)
+	fmt.Println("Analyst delete:", reporting.Delete("users", "2"))
+
+	// Repeated queries are answered from the cache until the table changes
+	inmemorydb.EnableQueryCache(db, 1<<20)
+	for i := 0; i < 3; i++ {
+		db.SelectWithConditions("users", []string{"name"}, conditions, "AND")
+	}
+	db.Insert("users", "5", inmemorydb.Record{"name": "Heidi", "age": 33, "city": "Pune"})
+	results1, _ = db.SelectWithConditions("users", []string{"name"}, conditions, "AND")
+	fmt.Println("After insert:", results1, "cache hit rate:", db.Stats().QueryCache.HitRate())
+
+	// Stats and Prometheus metrics
+	for _, table := range db.Stats().Tables {
+		fmt.Printf("Table %s: %d rows, %d index hits, %d full scans\n", table.Name, table.Rows, table.IndexHits, table.FullScans)
+	}
+	var metrics bytes.Buffer
+	inmemorydb.WritePrometheus(&metrics, db.Stats())
+	for _, line := range strings.Split(metrics.String(), "\n") {
+		if strings.HasPrefix(line, "imdb_operation_duration_seconds_count") {
+			fmt.Println(line)
+		}
+	}
+
+	// Back up and restore into a fresh database
+	var backup bytes.Buffer
+	if err := db.Backup(&backup); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	restored := inmemorydb.NewInMemoryDB()
+	if err := restored.Restore(&backup); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	results, _ = restored.Select("users", "name", "age", 30)
+	fmt.Println("Restored:", results)
+	results, _ = restored.Select("users_per_city", "users", "city", "Pune")
+	fmt.Println("Restored view:", results)
+
+	// Replicate to a follower on loopback
+	leader, err := inmemorydb.NewLeader(db, "127.0.0.1:0")
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	defer leader.Close()
+	follower, err := inmemorydb.NewFollower(leader.Addr())
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	db.Insert("users", "4", inmemorydb.Record{"name": "Dave", "age": 41, "city": "Pune"})
+	if err := follower.WaitForLSN(leader.LSN(), time.Second); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	replica := follower.DB()
+	results, _ = replica.Select("users", "name", "city", "Pune")
+	fmt.Println("Follower:", results, "lag:", follower.Lag().Entries)
+	results, _ = replica.Select("users_per_city", "users", "city", "Pune")
+	fmt.Println("Follower view:", results)
+	fmt.Println("Write on follower:", replica.Delete("users", "4"))
+
+	promoted, _ := follower.Promote()
+	fmt.Println("Write after promotion:", promoted.Delete("users", "4"))
 }
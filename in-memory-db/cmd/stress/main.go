@@ -0,0 +1,102 @@
+// Command stress runs the dbtest harness against the database and its
+// wrappers. Run it under the race detector:
+//
+//	go run -race ./cmd/stress -runs 20
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	inmemorydb "github.com/vnkdj5/low-level-design/in-memory-db"
+	"github.com/vnkdj5/low-level-design/in-memory-db/dbtest"
+)
+
+// target builds the database under test and the setup for its table.
+type target struct {
+	name  string
+	open  func() (inmemorydb.Database, error)
+	setup func(db inmemorydb.Database, table string) error
+}
+
+var targets = []target{
+	{name: "plain", open: openPlain},
+	{name: "session", open: openSession},
+	{name: "triggers", open: openPlain, setup: addTriggerAndView},
+	{name: "cached", open: openCached},
+}
+
+func openPlain() (inmemorydb.Database, error) {
+	return inmemorydb.NewInMemoryDB(), nil
+}
+
+// openCached enables a query cache small enough to keep evicting entries.
+func openCached() (inmemorydb.Database, error) {
+	db := inmemorydb.NewInMemoryDB()
+	return db, inmemorydb.EnableQueryCache(db, 4<<10)
+}
+
+func openSession() (inmemorydb.Database, error) {
+	access := inmemorydb.NewAccessControl(inmemorydb.NewInMemoryDB(), "admin")
+	return access.OpenSession("admin")
+}
+
+// addTriggerAndView makes every write to the table a multi-table scope: an
+// audit trigger and a materialized view are maintained alongside it.
+func addTriggerAndView(db inmemorydb.Database, table string) error {
+	if err := db.CreateTable("audit", map[string]string{"key": "string", "v": "int"}); err != nil {
+		return err
+	}
+	err := db.RegisterTrigger(table, inmemorydb.TriggerInsert, inmemorydb.TriggerAfter, func(event *inmemorydb.TriggerEvent) error {
+		return event.Tx.Insert("audit", event.Key, inmemorydb.Record{"key": event.Key, "v": event.New["v"]})
+	})
+	if err != nil {
+		return err
+	}
+	return db.CreateMaterializedView(table+"_per_key", inmemorydb.ViewQuery{
+		Table:      table,
+		GroupBy:    []string{"k"},
+		Aggregates: []inmemorydb.Aggregate{{Function: "COUNT", Alias: "rows"}},
+	})
+}
+
+func main() {
+	var config dbtest.Config
+	flag.IntVar(&config.Clients, "clients", 8, "concurrent clients")
+	flag.IntVar(&config.OpsPerClient, "ops", 200, "operations per client")
+	flag.IntVar(&config.Keys, "keys", 4, "distinct keys")
+	seed := flag.Int64("seed", 1, "seed of the first run")
+	runs := flag.Int("runs", 5, "runs per target, each with the next seed")
+	flag.Parse()
+
+	failed := false
+	for _, target := range targets {
+		for run := 0; run < *runs; run++ {
+			db, err := target.open()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, target.name, err)
+				os.Exit(1)
+			}
+			config.Seed = *seed + int64(run)
+			config.Setup = target.setup
+			history, err := dbtest.Run(db, config)
+
+			var violation *dbtest.LinearizabilityError
+			switch {
+			case errors.As(err, &violation):
+				fmt.Printf("%s seed %d: %v\n", target.name, config.Seed, err)
+				failed = true
+			case err != nil:
+				fmt.Printf("%s seed %d: %v\n", target.name, config.Seed, err)
+				failed = true
+			default:
+				fmt.Printf("%s seed %d: %d operations, linearizable\n", target.name, config.Seed, len(history.Operations))
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
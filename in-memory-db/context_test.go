@@ -0,0 +1,129 @@
+package inmemorydb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDoneContext(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	tests := []struct {
+		name string
+		op   func(ctx context.Context, db Database) error
+	}{
+		{name: "create table", op: func(ctx context.Context, db Database) error {
+			return db.CreateTableContext(ctx, "orders", map[string]string{"item": "string"})
+		}},
+		{name: "insert", op: func(ctx context.Context, db Database) error {
+			return db.InsertContext(ctx, "users", "2", Record{"name": "Bob"})
+		}},
+		{name: "get", op: func(ctx context.Context, db Database) error {
+			_, _, err := db.GetContext(ctx, "users", "1")
+			return err
+		}},
+		{name: "select", op: func(ctx context.Context, db Database) error {
+			_, err := db.SelectWithConditionsContext(ctx, "users", []string{"name"}, nil, "AND")
+			return err
+		}},
+		{name: "update if version", op: func(ctx context.Context, db Database) error {
+			_, err := db.UpdateIfVersionContext(ctx, "users", "1", Record{"name": "Carol"}, 1)
+			return err
+		}},
+		{name: "delete", op: func(ctx context.Context, db Database) error {
+			return db.DeleteContext(ctx, "users", "1")
+		}},
+		{name: "create index", op: func(ctx context.Context, db Database) error {
+			return db.CreateIndexContext(ctx, "users", "name")
+		}},
+		{name: "backup", op: func(ctx context.Context, db Database) error {
+			return db.BackupContext(ctx, &bytes.Buffer{})
+		}},
+	}
+
+	for _, tt := range tests {
+		for _, done := range []struct {
+			ctx  context.Context
+			want error
+		}{{cancelled, context.Canceled}, {expired, context.DeadlineExceeded}} {
+			t.Run(fmt.Sprintf("%s/%v", tt.name, done.want), func(t *testing.T) {
+				db := NewInMemoryDB()
+				mustSucceed(t, db.CreateTable("users", map[string]string{"name": "string"}))
+				mustSucceed(t, db.Insert("users", "1", Record{"name": "Alice"}))
+
+				if err := tt.op(done.ctx, db); !errors.Is(err, done.want) {
+					t.Fatalf("error = %v, want %v", err, done.want)
+				}
+				record, version, err := db.Get("users", "1")
+				if err != nil || record["name"] != "Alice" || version != 1 {
+					t.Errorf("Get() = %v, %d, %v, want the row unchanged", record, version, err)
+				}
+				if _, _, err := db.Get("users", "2"); !errors.Is(err, ErrRecordNotFound) {
+					t.Errorf("Get() error = %v, want no new row", err)
+				}
+			})
+		}
+	}
+}
+
+// cancelAfter is a condition expression that cancels its context once it has
+// been evaluated n times.
+type cancelAfter struct {
+	n      int
+	cancel context.CancelFunc
+}
+
+func (c *cancelAfter) Eval(record Record) (interface{}, error) {
+	if c.n--; c.n == 0 {
+		c.cancel()
+	}
+	return record["n"], nil
+}
+
+func (c *cancelAfter) String() string { return "cancelAfter" }
+
+func TestScanStopsWhenCancelled(t *testing.T) {
+	db := NewInMemoryDB()
+	mustSucceed(t, db.CreateTable("numbers", map[string]string{"n": "int"}))
+	for i := 0; i < 10*scanCheckInterval; i++ {
+		mustSucceed(t, db.Insert("numbers", fmt.Sprint(i), Record{"n": i}))
+	}
+
+	tests := []struct {
+		name string
+		scan func(ctx context.Context, condition Condition) error
+	}{
+		{name: "select with conditions", scan: func(ctx context.Context, condition Condition) error {
+			_, err := db.SelectWithConditionsContext(ctx, "numbers", []string{"n"}, []Condition{condition}, "AND")
+			return err
+		}},
+		{name: "select expressions", scan: func(ctx context.Context, condition Condition) error {
+			_, err := db.SelectExpressionsContext(ctx, "numbers", []Projection{{Expr: Col("n")}}, []Condition{condition}, "AND")
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			condition := &cancelAfter{n: 10, cancel: cancel}
+			err := tt.scan(ctx, Condition{Expr: condition, Operator: ">=", Value: 0})
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("error = %v, want %v", err, context.Canceled)
+			}
+			if condition.n < -scanCheckInterval {
+				t.Errorf("scan went on for %d rows after the cancellation", -condition.n)
+			}
+			// The scan released the table lock.
+			mustSucceed(t, db.Insert("numbers", tt.name, Record{"n": -1}))
+		})
+	}
+}
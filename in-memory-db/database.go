@@ -1,5 +1,10 @@
 package inmemorydb
 
+import (
+	"context"
+	"io"
+)
+
 type Database interface {
 	CreateTable(name string, schema map[string]string) error
 	Insert(tableName string, key string, record Record) error
@@ -10,8 +15,66 @@ type Database interface {
 		conditions []Condition,
 		logicalOperator string,
 	) ([]map[string]interface{}, error)
-	Get(tableName string, key string) (Record, error)
+	SelectExpressions(
+		tableName string,
+		projections []Projection,
+		conditions []Condition,
+		logicalOperator string,
+	) ([]map[string]interface{}, error)
+	Get(tableName string, key string) (Record, uint64, error)
 	//Update(tableName string, key string, updates Record) error
-	CreateIndex(tableName, column string) error
+	CreateIndex(tableName string, columns ...string) error
+	// CreateUniqueIndex fails writes that duplicate the values of its
+	// columns with a *UniqueViolationError.
+	CreateUniqueIndex(tableName string, columns ...string) error
 	Delete(tableName string, key string) error
+
+	// Compare-and-set variants fail with a *VersionConflictError when the
+	// row is not at the expected version.
+	InsertIfAbsent(tableName string, key string, record Record) (uint64, error)
+	UpdateIfVersion(tableName string, key string, updates Record, version uint64) (uint64, error)
+	DeleteIfVersion(tableName string, key string, version uint64) error
+
+	Backup(w io.Writer) error
+	Restore(r io.Reader) error
+
+	// RegisterTrigger runs fn before or after every insert or delete on a
+	// table, inside the lock scope of the write.
+	RegisterTrigger(tableName string, kind TriggerKind, timing TriggerTiming, fn TriggerFunc) error
+	// CreateMaterializedView stores the result of query as a read-only table
+	// that is kept up to date on every write to the source table.
+	CreateMaterializedView(name string, query ViewQuery) error
+
+	// Stats reports table sizes, index usage and operation latencies. See
+	// WritePrometheus to export them.
+	Stats() Stats
+
+	// Context variants give up with the context's error once ctx is done.
+	// Scans check ctx while they run and release their locks on cancellation.
+	CreateTableContext(ctx context.Context, name string, schema map[string]string) error
+	InsertContext(ctx context.Context, tableName string, key string, record Record) error
+	SelectContext(ctx context.Context, tableName, attribute, whereKey string, whereValue interface{}) ([]interface{}, error)
+	SelectWithConditionsContext(
+		ctx context.Context,
+		tableName string,
+		selectAttributes []string,
+		conditions []Condition,
+		logicalOperator string,
+	) ([]map[string]interface{}, error)
+	SelectExpressionsContext(
+		ctx context.Context,
+		tableName string,
+		projections []Projection,
+		conditions []Condition,
+		logicalOperator string,
+	) ([]map[string]interface{}, error)
+	GetContext(ctx context.Context, tableName string, key string) (Record, uint64, error)
+	CreateIndexContext(ctx context.Context, tableName string, columns ...string) error
+	CreateUniqueIndexContext(ctx context.Context, tableName string, columns ...string) error
+	DeleteContext(ctx context.Context, tableName string, key string) error
+	InsertIfAbsentContext(ctx context.Context, tableName string, key string, record Record) (uint64, error)
+	UpdateIfVersionContext(ctx context.Context, tableName string, key string, updates Record, version uint64) (uint64, error)
+	DeleteIfVersionContext(ctx context.Context, tableName string, key string, version uint64) error
+	BackupContext(ctx context.Context, w io.Writer) error
+	RestoreContext(ctx context.Context, r io.Reader) error
 }
@@ -0,0 +1,222 @@
+// Package dbtest stress tests implementations of inmemorydb.Database.
+//
+// Run lets several clients issue a random mix of reads and writes against a
+// handful of keys at the same time and records when every call started and
+// returned. The recorded history is then checked for linearizability against
+// a sequential model of a key-value table: every call must appear to take
+// effect at a single instant between its start and its return.
+//
+// Reads go through the key lookup, an index built before the run, a full
+// scan and an index built while the run is in progress, so lock bugs between
+// the data and the index of a table show up as wrong answers. Run the
+// harness under the race detector to catch unsynchronized access as well,
+// through TestLinearizable or with more runs from the command line:
+//
+//	go test -race ./dbtest
+//	go run -race ./cmd/stress
+package dbtest
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	inmemorydb "github.com/vnkdj5/low-level-design/in-memory-db"
+)
+
+// Config controls the shape of a run. Zero fields take the defaults below.
+type Config struct {
+	Clients      int   // Concurrent clients, 8 by default
+	OpsPerClient int   // Calls issued by every client, 200 by default
+	Keys         int   // Distinct keys, 4 by default; fewer keys mean more contention
+	Seed         int64 // Seed of the operation mix, 1 by default
+	Table        string
+
+	// Setup runs after the table is created and before the clients start,
+	// for example to register triggers or views on it.
+	Setup func(db inmemorydb.Database, table string) error
+}
+
+func (c Config) withDefaults() Config {
+	if c.Clients <= 0 {
+		c.Clients = 8
+	}
+	if c.OpsPerClient <= 0 {
+		c.OpsPerClient = 200
+	}
+	if c.Keys <= 0 {
+		c.Keys = 4
+	}
+	if c.Seed == 0 {
+		c.Seed = 1
+	}
+	if c.Table == "" {
+		c.Table = "stress"
+	}
+	return c
+}
+
+// Columns of the table used by Run. Every row stores its key three times so
+// reads can find it through different access paths.
+const (
+	columnKey     = "k"  // Indexed before the run starts
+	columnScanKey = "k2" // Never indexed
+	columnLateKey = "k3" // Indexed while the run is in progress
+	columnValue   = "v"
+)
+
+// Run creates the table in db, runs the clients and checks their history. It
+// returns a *LinearizabilityError if the history cannot be explained by any
+// sequential order of the calls.
+func Run(db inmemorydb.Database, config Config) (*History, error) {
+	config = config.withDefaults()
+	err := db.CreateTable(config.Table, map[string]string{
+		columnKey:     "string",
+		columnScanKey: "string",
+		columnLateKey: "string",
+		columnValue:   "int",
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.CreateIndex(config.Table, columnKey); err != nil {
+		return nil, err
+	}
+	if config.Setup != nil {
+		if err := config.Setup(db, config.Table); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &runner{db: db, table: config.Table}
+	var wg sync.WaitGroup
+	for client := 0; client < config.Clients; client++ {
+		wg.Add(1)
+		go func(client int) {
+			defer wg.Done()
+			r.runClient(client, config, rand.New(rand.NewSource(config.Seed+int64(client))))
+		}(client)
+	}
+	wg.Wait()
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	history := &History{Operations: r.operations}
+	return history, history.Check()
+}
+
+type runner struct {
+	db    inmemorydb.Database
+	table string
+	clock atomic.Int64 // Logical time of calls and returns
+
+	operations []Operation
+	err        error // First unexpected error
+	lock       sync.Mutex
+}
+
+func (r *runner) record(op Operation) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.operations = append(r.operations, op)
+}
+
+func (r *runner) fail(err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *runner) runClient(client int, config Config, rng *rand.Rand) {
+	versions := make(map[string]uint64) // Last version this client saw per key
+	for i := 0; i < config.OpsPerClient; i++ {
+		// Halfway through, the first client builds the late index while the
+		// others keep going.
+		if client == 0 && i == config.OpsPerClient/2 {
+			if err := r.db.CreateIndex(r.table, columnLateKey); err != nil {
+				r.fail(err)
+				return
+			}
+		}
+
+		op := Operation{
+			Client: client,
+			Kind:   OpKind(rng.Intn(int(opKinds))),
+			Key:    fmt.Sprintf("key-%d", rng.Intn(config.Keys)),
+			Value:  rng.Intn(1000),
+		}
+		op.Expected = versions[op.Key]
+
+		op.Call = r.clock.Add(1)
+		err := r.invoke(&op)
+		op.Return = r.clock.Add(1)
+		if err != nil {
+			r.fail(fmt.Errorf("client %d: %s: %w", client, op, err))
+			return
+		}
+		if op.Found && op.Version != 0 {
+			versions[op.Key] = op.Version
+		}
+		r.record(op)
+	}
+}
+
+// invoke performs op and stores its outcome in op. Expected failures, like a
+// version conflict or a missing row, are outcomes and not errors.
+func (r *runner) invoke(op *Operation) error {
+	db, table, key := r.db, r.table, op.Key
+	record := inmemorydb.Record{columnKey: key, columnScanKey: key, columnLateKey: key, columnValue: op.Value}
+
+	var err error
+	switch op.Kind {
+	case OpGet:
+		var row inmemorydb.Record
+		row, op.Version, err = db.Get(table, key)
+		if errors.Is(err, inmemorydb.ErrRecordNotFound) {
+			return nil
+		}
+		if err == nil {
+			op.Found = true
+			op.Values = []int{row[columnValue].(int)}
+		}
+	case OpInsert:
+		err = db.Insert(table, key, record)
+	case OpInsertIfAbsent:
+		op.Version, err = db.InsertIfAbsent(table, key, record)
+		op.Found = err == nil
+	case OpUpdateIfVersion:
+		op.Version, err = db.UpdateIfVersion(table, key, inmemorydb.Record{columnValue: op.Value}, op.Expected)
+		op.Found = err == nil
+	case OpDelete:
+		err = db.Delete(table, key)
+	case OpDeleteIfVersion:
+		err = db.DeleteIfVersion(table, key, op.Expected)
+		op.Found = err == nil
+	case OpSelectIndexed, OpSelectLateIndex:
+		column := columnKey
+		if op.Kind == OpSelectLateIndex {
+			column = columnLateKey
+		}
+		var values []interface{}
+		values, err = db.Select(table, columnValue, column, key)
+		for _, value := range values {
+			op.Values = append(op.Values, value.(int))
+		}
+	case OpSelectScan:
+		var rows []map[string]interface{}
+		conditions := []inmemorydb.Condition{{Attribute: columnScanKey, Operator: "=", Value: key}}
+		rows, err = db.SelectWithConditions(table, []string{columnValue}, conditions, "AND")
+		for _, row := range rows {
+			op.Values = append(op.Values, row[columnValue].(int))
+		}
+	}
+	if errors.Is(err, inmemorydb.ErrVersionConflict) {
+		return nil
+	}
+	return err
+}
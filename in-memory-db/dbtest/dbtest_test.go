@@ -0,0 +1,80 @@
+package dbtest_test
+
+import (
+	"testing"
+
+	inmemorydb "github.com/vnkdj5/low-level-design/in-memory-db"
+	"github.com/vnkdj5/low-level-design/in-memory-db/dbtest"
+)
+
+func TestLinearizable(t *testing.T) {
+	if testing.Short() {
+		t.Skip("stress test")
+	}
+
+	tests := []struct {
+		name  string
+		open  func() (inmemorydb.Database, error)
+		setup func(db inmemorydb.Database, table string) error
+	}{
+		{
+			name: "plain",
+			open: func() (inmemorydb.Database, error) { return inmemorydb.NewInMemoryDB(), nil },
+		},
+		{
+			name: "session",
+			open: func() (inmemorydb.Database, error) {
+				return inmemorydb.NewAccessControl(inmemorydb.NewInMemoryDB(), "admin").OpenSession("admin")
+			},
+		},
+		{
+			name:  "triggers",
+			open:  func() (inmemorydb.Database, error) { return inmemorydb.NewInMemoryDB(), nil },
+			setup: addTriggerAndView,
+		},
+		{
+			name: "cached",
+			open: func() (inmemorydb.Database, error) {
+				db := inmemorydb.NewInMemoryDB()
+				return db, inmemorydb.EnableQueryCache(db, 4<<10)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for seed := int64(1); seed <= 3; seed++ {
+				db, err := tt.open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				history, err := dbtest.Run(db, dbtest.Config{Seed: seed, Setup: tt.setup})
+				if err != nil {
+					t.Fatalf("seed %d: %v", seed, err)
+				}
+				if len(history.Operations) == 0 {
+					t.Fatalf("seed %d: no operations recorded", seed)
+				}
+			}
+		})
+	}
+}
+
+// addTriggerAndView makes every write to the table a multi-table scope: an
+// audit trigger and a materialized view are maintained alongside it.
+func addTriggerAndView(db inmemorydb.Database, table string) error {
+	if err := db.CreateTable("audit", map[string]string{"key": "string", "v": "int"}); err != nil {
+		return err
+	}
+	err := db.RegisterTrigger(table, inmemorydb.TriggerInsert, inmemorydb.TriggerAfter, func(event *inmemorydb.TriggerEvent) error {
+		return event.Tx.Insert("audit", event.Key, inmemorydb.Record{"key": event.Key, "v": event.New["v"]})
+	})
+	if err != nil {
+		return err
+	}
+	return db.CreateMaterializedView(table+"_per_key", inmemorydb.ViewQuery{
+		Table:      table,
+		GroupBy:    []string{"k"},
+		Aggregates: []inmemorydb.Aggregate{{Function: "COUNT", Alias: "rows"}},
+	})
+}
@@ -0,0 +1,129 @@
+package dbtest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type OpKind int
+
+const (
+	OpGet OpKind = iota
+	OpInsert
+	OpInsertIfAbsent
+	OpUpdateIfVersion
+	OpDelete
+	OpDeleteIfVersion
+	OpSelectIndexed   // Select through the index built before the run
+	OpSelectScan      // SelectWithConditions on a column without an index
+	OpSelectLateIndex // Select on a column indexed during the run
+	opKinds
+)
+
+func (k OpKind) String() string {
+	return [...]string{
+		"Get", "Insert", "InsertIfAbsent", "UpdateIfVersion", "Delete", "DeleteIfVersion",
+		"SelectIndexed", "SelectScan", "SelectLateIndex",
+	}[k]
+}
+
+// Operation is one call made by a client. Call and Return are logical times:
+// an operation that returned before another was called must also take effect
+// before it.
+type Operation struct {
+	Client   int
+	Kind     OpKind
+	Key      string
+	Value    int    // Value written
+	Expected uint64 // Version passed to UpdateIfVersion and DeleteIfVersion
+
+	// Outcome. Found is set when a Get found the row or a conditional write
+	// succeeded. Version is the version read or written, Values the values
+	// read.
+	Found   bool
+	Version uint64
+	Values  []int
+
+	Call, Return int64
+}
+
+func (op Operation) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "[%d,%d] client %d %s(%s", op.Call, op.Return, op.Client, op.Kind, op.Key)
+	switch op.Kind {
+	case OpInsert, OpInsertIfAbsent:
+		fmt.Fprintf(&sb, ", %d", op.Value)
+	case OpUpdateIfVersion:
+		fmt.Fprintf(&sb, ", %d, v%d", op.Value, op.Expected)
+	case OpDeleteIfVersion:
+		fmt.Fprintf(&sb, ", v%d", op.Expected)
+	}
+	sb.WriteString(")")
+	switch op.Kind {
+	case OpGet:
+		if op.Found {
+			fmt.Fprintf(&sb, " -> %v v%d", op.Values, op.Version)
+		} else {
+			sb.WriteString(" -> not found")
+		}
+	case OpInsertIfAbsent, OpUpdateIfVersion:
+		if op.Found {
+			fmt.Fprintf(&sb, " -> v%d", op.Version)
+		} else {
+			sb.WriteString(" -> conflict")
+		}
+	case OpDeleteIfVersion:
+		if !op.Found {
+			sb.WriteString(" -> conflict")
+		}
+	case OpSelectIndexed, OpSelectScan, OpSelectLateIndex:
+		fmt.Fprintf(&sb, " -> %v", op.Values)
+	}
+	return sb.String()
+}
+
+// History is the record of a run.
+type History struct {
+	Operations []Operation
+}
+
+// LinearizabilityError reports the operations on a key that no sequential
+// order explains.
+type LinearizabilityError struct {
+	Key        string
+	Operations []Operation // Ordered by call time
+}
+
+func (e *LinearizabilityError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "history of %s is not linearizable:", e.Key)
+	for _, op := range e.Operations {
+		sb.WriteString("\n  ")
+		sb.WriteString(op.String())
+	}
+	return sb.String()
+}
+
+// Check verifies that the history is linearizable. Keys are independent in
+// the model, so every key is checked on its own.
+func (h *History) Check() error {
+	byKey := make(map[string][]Operation)
+	for _, op := range h.Operations {
+		byKey[op.Key] = append(byKey[op.Key], op)
+	}
+	keys := make([]string, 0, len(byKey))
+	for key := range byKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		ops := byKey[key]
+		if !linearizable(ops) {
+			sort.Slice(ops, func(i, j int) bool { return ops[i].Call < ops[j].Call })
+			return &LinearizabilityError{Key: key, Operations: ops}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package dbtest
+
+import "sort"
+
+// row is the state of one key in the sequential model. A row written by a
+// plain Insert has a version the client never saw; the next read that
+// returns it fills it in.
+type row struct {
+	exists  bool
+	value   int
+	version uint64 // 0 when unknown
+}
+
+// step applies op to the model. It reports whether the outcome recorded in
+// op is possible in state, and the state afterwards.
+func step(state row, op Operation) (bool, row) {
+	switch op.Kind {
+	case OpGet:
+		if !op.Found {
+			return !state.exists, state
+		}
+		if !state.versionMayBe(op.Version) || state.value != op.Values[0] {
+			return false, state
+		}
+		state.version = op.Version
+		return true, state
+	case OpInsert:
+		return true, row{exists: true, value: op.Value}
+	case OpInsertIfAbsent:
+		if !op.Found {
+			return state.exists, state
+		}
+		return !state.exists, row{exists: true, value: op.Value, version: op.Version}
+	case OpUpdateIfVersion:
+		if !op.Found {
+			return !state.versionIs(op.Expected), state
+		}
+		if !state.versionMayBe(op.Expected) || op.Version <= op.Expected {
+			return false, state
+		}
+		return true, row{exists: true, value: op.Value, version: op.Version}
+	case OpDelete:
+		return true, row{}
+	case OpDeleteIfVersion:
+		if !op.Found {
+			return !state.versionIs(op.Expected), state
+		}
+		return state.versionMayBe(op.Expected), row{}
+	default: // Selects
+		if !state.exists {
+			return len(op.Values) == 0, state
+		}
+		return len(op.Values) == 1 && op.Values[0] == state.value, state
+	}
+}
+
+// versionMayBe reports whether the row exists and may be at version, and
+// versionIs whether it certainly is. Rows never have version 0.
+func (r row) versionMayBe(version uint64) bool {
+	return r.exists && version != 0 && (r.version == 0 || r.version == version)
+}
+
+func (r row) versionIs(version uint64) bool {
+	return r.exists && version != 0 && r.version == version
+}
+
+// event is the call or the return of an operation, linked into the list of
+// events that have not been linearized yet.
+type event struct {
+	op         int
+	isCall     bool
+	match      *event // The return of a call
+	prev, next *event
+}
+
+// lift removes a call and its return from the list.
+func (e *event) lift() {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	e.match.prev.next = e.match.next
+	if e.match.next != nil {
+		e.match.next.prev = e.match.prev
+	}
+}
+
+// unlift puts a lifted call and its return back.
+func (e *event) unlift() {
+	e.match.prev.next = e.match
+	if e.match.next != nil {
+		e.match.next.prev = e.match
+	}
+	e.prev.next = e
+	e.next.prev = e
+}
+
+type cacheEntry struct {
+	linearized string // Bitset of linearized operations
+	state      row
+}
+
+// linearizable searches for a sequential order of ops that respects their
+// real-time order and is legal in the model. It is the algorithm of Wing and
+// Gong with the state cache suggested by Lowe, which prunes orders that reach
+// an already explored combination of linearized operations and state.
+func linearizable(ops []Operation) bool {
+	events := make([]*event, 0, 2*len(ops))
+	for i := range ops {
+		call := &event{op: i, isCall: true}
+		ret := &event{op: i}
+		call.match = ret
+		events = append(events, call, ret)
+	}
+	time := func(e *event) int64 {
+		if e.isCall {
+			return ops[e.op].Call
+		}
+		return ops[e.op].Return
+	}
+	sort.Slice(events, func(i, j int) bool { return time(events[i]) < time(events[j]) })
+
+	head := &event{}
+	prev := head
+	for _, e := range events {
+		prev.next, e.prev = e, prev
+		prev = e
+	}
+
+	type frame struct {
+		call  *event
+		state row
+	}
+	var (
+		stack      []frame
+		state      row
+		linearized = make([]byte, (len(ops)+7)/8)
+		cache      = make(map[cacheEntry]bool)
+	)
+	entry := head.next
+	for head.next != nil {
+		if entry.isCall {
+			ok, next := step(state, ops[entry.op])
+			if ok {
+				linearized[entry.op/8] |= 1 << (entry.op % 8)
+				key := cacheEntry{linearized: string(linearized), state: next}
+				if !cache[key] {
+					cache[key] = true
+					stack = append(stack, frame{call: entry, state: state})
+					state = next
+					entry.lift()
+					entry = head.next
+					continue
+				}
+				linearized[entry.op/8] &^= 1 << (entry.op % 8)
+			}
+			entry = entry.next
+			continue
+		}
+
+		// A return was reached before its call could be linearized, so the
+		// last choice was wrong.
+		if len(stack) == 0 {
+			return false
+		}
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		state = top.state
+		linearized[top.call.op/8] &^= 1 << (top.call.op % 8)
+		top.call.unlift()
+		entry = top.call.next
+	}
+	return true
+}
@@ -0,0 +1,162 @@
+package inmemorydb
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// DecimalScale is the number of fractional digits a Decimal keeps.
+const DecimalScale = 4
+
+const decimalFactor = 10000
+
+// ErrDecimalOverflow is returned when the result of a Decimal operation does
+// not fit a Decimal.
+var ErrDecimalOverflow = errors.New("decimal overflow")
+
+// Decimal is a fixed-point number with DecimalScale fractional digits, meant
+// for money. It is stored as an integer number of 1/10000 units, so equal
+// amounts are always equal map keys and compare exactly.
+type Decimal int64
+
+// NewDecimal returns unscaled * 10^-scale, e.g. NewDecimal(1999, 2) is 19.99.
+// Digits beyond DecimalScale are rounded half away from zero. It fails with
+// ErrDecimalOverflow if the value does not fit a Decimal.
+func NewDecimal(unscaled int64, scale int) (Decimal, error) {
+	units := unscaled
+	for s := scale; s < DecimalScale; s++ {
+		if units > math.MaxInt64/10 || units < math.MinInt64/10 {
+			return 0, fmt.Errorf("%w: %d * 10^%d", ErrDecimalOverflow, unscaled, -scale)
+		}
+		units *= 10
+	}
+	for s := scale; s > DecimalScale; s-- {
+		remainder := units % 10
+		units /= 10
+		if remainder >= 5 {
+			units++
+		} else if remainder <= -5 {
+			units--
+		}
+	}
+	return Decimal(units), nil
+}
+
+// ParseDecimal parses strings such as "12", "-0.5" or "19.99".
+func ParseDecimal(s string) (Decimal, error) {
+	sign, digits := "", s
+	if strings.HasPrefix(s, "-") {
+		sign, digits = "-", s[1:]
+	}
+	whole, fraction, _ := strings.Cut(digits, ".")
+	if whole == "" && fraction == "" || len(fraction) > DecimalScale || strings.ContainsAny(whole+fraction, "+-") {
+		return 0, fmt.Errorf("invalid decimal %q", s)
+	}
+	unscaled, err := strconv.ParseInt(sign+whole+fraction, 10, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return 0, fmt.Errorf("invalid decimal %q: %w", s, ErrDecimalOverflow)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid decimal %q: %w", s, err)
+	}
+	d, err := NewDecimal(unscaled, len(fraction))
+	if err != nil {
+		return 0, fmt.Errorf("invalid decimal %q: %w", s, err)
+	}
+	return d, nil
+}
+
+// Add returns d + other, failing with ErrDecimalOverflow if the sum does not
+// fit a Decimal.
+func (d Decimal) Add(other Decimal) (Decimal, error) {
+	sum := d + other
+	if (sum > d) != (other > 0) {
+		return 0, fmt.Errorf("%w: %s + %s", ErrDecimalOverflow, d, other)
+	}
+	return sum, nil
+}
+
+// Sub returns d - other, failing with ErrDecimalOverflow if the difference
+// does not fit a Decimal.
+func (d Decimal) Sub(other Decimal) (Decimal, error) {
+	difference := d - other
+	if (difference < d) != (other > 0) {
+		return 0, fmt.Errorf("%w: %s - %s", ErrDecimalOverflow, d, other)
+	}
+	return difference, nil
+}
+
+// Mul multiplies two decimals, rounding the result half away from zero.
+func (d Decimal) Mul(other Decimal) (Decimal, error) {
+	product := new(big.Int).Mul(big.NewInt(int64(d)), big.NewInt(int64(other)))
+	result, err := quotient(product, big.NewInt(decimalFactor))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s * %s", err, d, other)
+	}
+	return result, nil
+}
+
+// Div divides two decimals, rounding the result half away from zero.
+func (d Decimal) Div(other Decimal) (Decimal, error) {
+	if other == 0 {
+		return 0, fmt.Errorf("division by zero: %s / 0", d)
+	}
+	scaled := new(big.Int).Mul(big.NewInt(int64(d)), big.NewInt(decimalFactor))
+	result, err := quotient(scaled, big.NewInt(int64(other)))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s / %s", err, d, other)
+	}
+	return result, nil
+}
+
+// quotient divides n by m, rounding half away from zero, as a number of
+// 1/10000 units.
+func quotient(n, m *big.Int) (Decimal, error) {
+	q, r := new(big.Int).QuoRem(n, m, new(big.Int))
+	if twice := new(big.Int).Lsh(r.Abs(r), 1); twice.Cmp(new(big.Int).Abs(m)) >= 0 {
+		if n.Sign() == m.Sign() {
+			q.Add(q, big.NewInt(1))
+		} else {
+			q.Sub(q, big.NewInt(1))
+		}
+	}
+	if !q.IsInt64() {
+		return 0, ErrDecimalOverflow
+	}
+	return Decimal(q.Int64()), nil
+}
+
+func (d Decimal) Cmp(other Decimal) int {
+	switch {
+	case d < other:
+		return -1
+	case d > other:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (d Decimal) Float64() float64 {
+	return float64(d) / decimalFactor
+}
+
+func (d Decimal) String() string {
+	// The magnitude is taken as a uint64, which also holds that of the
+	// smallest Decimal.
+	sign := ""
+	units := uint64(d)
+	if d < 0 {
+		sign = "-"
+		units = -units
+	}
+	whole, fraction := units/decimalFactor, units%decimalFactor
+	if fraction == 0 {
+		return fmt.Sprintf("%s%d", sign, whole)
+	}
+	return strings.TrimRight(fmt.Sprintf("%s%d.%04d", sign, whole, fraction), "0")
+}
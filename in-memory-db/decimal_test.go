@@ -0,0 +1,212 @@
+package inmemorydb
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestDecimalMulDiv(t *testing.T) {
+	tests := []struct {
+		name    string
+		left    string
+		op      string
+		right   string
+		want    string
+		wantErr error
+	}{
+		{name: "mul", left: "19.99", op: "*", right: "3", want: "59.97"},
+		{name: "mul rounds half up", left: "0.0005", op: "*", right: "0.5", want: "0.0003"},
+		{name: "mul rounds half away from zero", left: "-0.0005", op: "*", right: "0.5", want: "-0.0003"},
+		{name: "mul large", left: "900000000000", op: "*", right: "10", want: "9000000000000"},
+		{name: "mul overflow", left: "900000000000", op: "*", right: "100000", wantErr: ErrDecimalOverflow},
+		{name: "div", left: "10", op: "/", right: "4", want: "2.5"},
+		{name: "div rounds", left: "2", op: "/", right: "3", want: "0.6667"},
+		{name: "div negative", left: "-2", op: "/", right: "3", want: "-0.6667"},
+		{name: "div overflow", left: "900000000000", op: "/", right: "0.0001", wantErr: ErrDecimalOverflow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			left, right := mustDecimal(t, tt.left), mustDecimal(t, tt.right)
+			var got Decimal
+			var err error
+			if tt.op == "*" {
+				got, err = left.Mul(right)
+			} else {
+				got, err = left.Div(right)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("%s %s %s: error = %v, want %v", tt.left, tt.op, tt.right, err, tt.wantErr)
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("%s %s %s = %s, want %s", tt.left, tt.op, tt.right, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecimalArithmeticExpr(t *testing.T) {
+	price := mustDecimal(t, "0.1")
+	tests := []struct {
+		name string
+		expr Expr
+		want interface{}
+	}{
+		{name: "decimal + decimal", expr: Add(Col("price"), Lit(mustDecimal(t, "0.2"))), want: mustDecimal(t, "0.3")},
+		{name: "decimal * int", expr: Mul(Col("price"), Lit(3)), want: mustDecimal(t, "0.3")},
+		{name: "int - decimal", expr: Sub(Lit(1), Col("price")), want: mustDecimal(t, "0.9")},
+		{name: "decimal / int", expr: Div(Col("price"), Lit(4)), want: mustDecimal(t, "0.025")},
+		{name: "decimal + float", expr: Add(Col("price"), Lit(0.5)), want: 0.6},
+		{name: "int + int", expr: Add(Lit(1), Lit(2)), want: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.expr.Eval(Record{"price": price})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("%s = %v (%T), want %v (%T)", tt.expr, got, got, tt.want, tt.want)
+			}
+		})
+	}
+
+	if _, err := Mul(Lit(Decimal(math.MaxInt64)), Lit(2)).Eval(nil); !errors.Is(err, ErrDecimalOverflow) {
+		t.Errorf("overflowing product: error = %v, want %v", err, ErrDecimalOverflow)
+	}
+	if _, err := Div(Col("price"), Lit(0)).Eval(Record{"price": price}); err == nil {
+		t.Error("division by zero: want an error")
+	}
+}
+
+func TestDecimalBounds(t *testing.T) {
+	largest, smallest := Decimal(math.MaxInt64), Decimal(math.MinInt64)
+	unit := Decimal(1)
+	tests := []struct {
+		name    string
+		value   func() (Decimal, error)
+		want    string
+		wantErr error
+	}{
+		{name: "new largest whole", value: func() (Decimal, error) { return NewDecimal(922337203685477, 0) }, want: "922337203685477"},
+		{name: "new too large", value: func() (Decimal, error) { return NewDecimal(922337203685478, 0) }, wantErr: ErrDecimalOverflow},
+		{name: "new too small", value: func() (Decimal, error) { return NewDecimal(-922337203685478, 0) }, wantErr: ErrDecimalOverflow},
+		{name: "new rounds extra digits", value: func() (Decimal, error) { return NewDecimal(math.MaxInt64, 6) }, want: "9223372036854.7758"},
+		{name: "parse largest", value: func() (Decimal, error) { return ParseDecimal("922337203685477.5807") }, want: "922337203685477.5807"},
+		{name: "parse smallest", value: func() (Decimal, error) { return ParseDecimal("-922337203685477.5808") }, want: "-922337203685477.5808"},
+		{name: "parse too large", value: func() (Decimal, error) { return ParseDecimal("922337203685477.5808") }, wantErr: ErrDecimalOverflow},
+		{name: "parse too large to scale", value: func() (Decimal, error) { return ParseDecimal("1000000000000000") }, wantErr: ErrDecimalOverflow},
+		{name: "parse too many digits", value: func() (Decimal, error) { return ParseDecimal("99999999999999999999") }, wantErr: ErrDecimalOverflow},
+		{name: "add up to the largest", value: func() (Decimal, error) { return (largest - unit).Add(unit) }, want: "922337203685477.5807"},
+		{name: "add past the largest", value: func() (Decimal, error) { return largest.Add(unit) }, wantErr: ErrDecimalOverflow},
+		{name: "add past the smallest", value: func() (Decimal, error) { return smallest.Add(-unit) }, wantErr: ErrDecimalOverflow},
+		{name: "sub down to the smallest", value: func() (Decimal, error) { return (smallest + unit).Sub(unit) }, want: "-922337203685477.5808"},
+		{name: "sub past the smallest", value: func() (Decimal, error) { return smallest.Sub(unit) }, wantErr: ErrDecimalOverflow},
+		{name: "sub past the largest", value: func() (Decimal, error) { return largest.Sub(-unit) }, wantErr: ErrDecimalOverflow},
+		{name: "sub the smallest", value: func() (Decimal, error) { return Decimal(0).Sub(smallest) }, wantErr: ErrDecimalOverflow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.value()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecimalOverflowInQueries(t *testing.T) {
+	largest := Decimal(math.MaxInt64)
+	tests := []struct {
+		name string
+		run  func(t *testing.T, db Database) error
+	}{
+		{name: "sum expression", run: func(t *testing.T, db Database) error {
+			_, err := db.SelectExpressions("items", []Projection{{Expr: Add(Col("price"), Lit(Decimal(1)))}}, nil, "AND")
+			return err
+		}},
+		{name: "difference expression", run: func(t *testing.T, db Database) error {
+			_, err := db.SelectExpressions("items", []Projection{{Expr: Sub(Lit(-largest), Col("price"))}}, nil, "AND")
+			return err
+		}},
+		{name: "view sum", run: func(t *testing.T, db Database) error {
+			mustSucceed(t, db.CreateMaterializedView("total", ViewQuery{
+				Table:      "items",
+				Aggregates: []Aggregate{{Function: "SUM", Attribute: "price", Alias: "total"}},
+			}))
+			return db.Insert("items", "2", Record{"price": Decimal(1)})
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewInMemoryDB()
+			mustSucceed(t, db.CreateTable("items", map[string]string{"price": TypeDecimal}))
+			mustSucceed(t, db.Insert("items", "1", Record{"price": largest}))
+			if err := tt.run(t, db); !errors.Is(err, ErrDecimalOverflow) {
+				t.Errorf("error = %v, want %v", err, ErrDecimalOverflow)
+			}
+		})
+	}
+}
+
+func TestDecimalEquality(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{}
+		operator string
+		matches  []string
+	}{
+		{name: "decimal = decimal", value: mustDecimal(t, "10"), operator: "=", matches: []string{"ten"}},
+		{name: "decimal = int", value: 10, operator: "=", matches: []string{"ten"}},
+		{name: "decimal = float", value: 10.5, operator: "=", matches: []string{"ten and a half"}},
+		{name: "decimal != int", value: 10, operator: "!=", matches: []string{"ten and a half"}},
+		{name: "decimal = string", value: "10", operator: "=", matches: nil},
+	}
+
+	for _, indexed := range []bool{false, true} {
+		for _, tt := range tests {
+			name := tt.name
+			if indexed {
+				name += ", indexed"
+			}
+			t.Run(name, func(t *testing.T) {
+				db := NewInMemoryDB()
+				mustSucceed(t, db.CreateTable("items", map[string]string{"name": "string", "price": TypeDecimal}))
+				mustSucceed(t, db.Insert("items", "1", Record{"name": "ten", "price": mustDecimal(t, "10")}))
+				mustSucceed(t, db.Insert("items", "2", Record{"name": "ten and a half", "price": mustDecimal(t, "10.5")}))
+				if indexed {
+					mustSucceed(t, db.CreateIndex("items", "price"))
+				}
+
+				conditions := []Condition{{Attribute: "price", Operator: tt.operator, Value: tt.value}}
+				results, err := db.SelectWithConditions("items", []string{"name"}, conditions, "AND")
+				if err != nil {
+					t.Fatal(err)
+				}
+				var names []string
+				for _, result := range results {
+					names = append(names, result["name"].(string))
+				}
+				if len(names) != len(tt.matches) || len(names) > 0 && names[0] != tt.matches[0] {
+					t.Errorf("price %s %v matched %v, want %v", tt.operator, tt.value, names, tt.matches)
+				}
+			})
+		}
+	}
+}
+
+func mustDecimal(t *testing.T, s string) Decimal {
+	t.Helper()
+	d, err := ParseDecimal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
@@ -0,0 +1,116 @@
+package inmemorydb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors returned by the database. Failures wrap one of these, so callers
+// can test for them with errors.Is instead of matching on text.
+var (
+	ErrTableNotFound   = errors.New("table not found")
+	ErrTableExists     = errors.New("table already exists")
+	ErrRecordNotFound  = errors.New("record not found")
+	ErrIndexExists     = errors.New("index already exists")
+	ErrUniqueViolation = errors.New("unique index violation")
+	ErrSchemaViolation = errors.New("schema violation")
+	ErrVersionConflict = errors.New("version conflict")
+	ErrReadOnly        = errors.New("database is a read-only follower")
+	ErrReadOnlyTable   = errors.New("table is a read-only materialized view")
+
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrUnknownUser      = errors.New("unknown user")
+	ErrUnknownRole      = errors.New("unknown role")
+	ErrUserExists       = errors.New("user already exists")
+	ErrRoleExists       = errors.New("role already exists")
+
+	ErrCorruptBackup = errors.New("corrupt backup")
+)
+
+func tableNotFound(tableName string) error {
+	return fmt.Errorf("%w: %s", ErrTableNotFound, tableName)
+}
+
+func tableExists(tableName string) error {
+	return fmt.Errorf("%w: %s", ErrTableExists, tableName)
+}
+
+func recordNotFound(tableName, key string) error {
+	return fmt.Errorf("%w: %s/%s", ErrRecordNotFound, tableName, key)
+}
+
+// SchemaError reports a value that does not fit the schema of a table. An
+// empty Actual means the value is missing, an empty Expected means the
+// column is not part of the schema.
+type SchemaError struct {
+	Table    string
+	Column   string
+	Expected string
+	Actual   string
+}
+
+func (e *SchemaError) Error() string {
+	switch {
+	case e.Expected == "":
+		return fmt.Sprintf("table %s has no column %s", e.Table, e.Column)
+	case e.Actual == "":
+		return fmt.Sprintf("missing value for column %s", e.Column)
+	default:
+		return fmt.Sprintf("invalid data type for column %s, expected %s, got %s", e.Column, e.Expected, e.Actual)
+	}
+}
+
+func (e *SchemaError) Is(target error) bool {
+	return target == ErrSchemaViolation
+}
+
+// UniqueViolationError reports a row whose values in the columns of a unique
+// index are already held by another row.
+type UniqueViolationError struct {
+	Table       string
+	Columns     []string
+	Key         string // Row that was rejected
+	ExistingKey string // Row already holding the values
+}
+
+func (e *UniqueViolationError) Error() string {
+	return fmt.Sprintf("unique index violation on %s(%s): row %s has the same values as row %s",
+		e.Table, strings.Join(e.Columns, ", "), e.Key, e.ExistingKey)
+}
+
+func (e *UniqueViolationError) Is(target error) bool {
+	return target == ErrUniqueViolation
+}
+
+// VersionConflictError is returned by the compare-and-set operations when the
+// row is not at the expected version. A version of 0 means the row is absent.
+type VersionConflictError struct {
+	Table    string
+	Key      string
+	Expected uint64
+	Actual   uint64
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("version conflict on %s/%s: expected version %d, found %d", e.Table, e.Key, e.Expected, e.Actual)
+}
+
+func (e *VersionConflictError) Is(target error) bool {
+	return target == ErrVersionConflict
+}
+
+// PermissionDeniedError reports the access a user was missing.
+type PermissionDeniedError struct {
+	User     string
+	Table    string
+	Required Permission
+}
+
+func (e *PermissionDeniedError) Error() string {
+	return fmt.Sprintf("permission denied: user %s needs %s access to table %s", e.User, e.Required, e.Table)
+}
+
+func (e *PermissionDeniedError) Is(target error) bool {
+	return target == ErrPermissionDenied
+}
@@ -0,0 +1,85 @@
+package inmemorydb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestErrorTaxonomy(t *testing.T) {
+	tests := []struct {
+		name   string
+		op     func(db Database) error
+		is     error
+		schema *SchemaError // Details expected in a *SchemaError, if any
+	}{
+		{
+			name: "unknown table",
+			op:   func(db Database) error { return db.Insert("orders", "1", Record{"item": "book"}) },
+			is:   ErrTableNotFound,
+		},
+		{
+			name: "existing table",
+			op:   func(db Database) error { return db.CreateTable("users", map[string]string{"name": "string"}) },
+			is:   ErrTableExists,
+		},
+		{
+			name: "unknown row",
+			op:   func(db Database) error { _, _, err := db.Get("users", "9"); return err },
+			is:   ErrRecordNotFound,
+		},
+		{
+			name:   "wrong type",
+			op:     func(db Database) error { return db.Insert("users", "2", Record{"name": 42, "age": 20}) },
+			is:     ErrSchemaViolation,
+			schema: &SchemaError{Table: "users", Column: "name", Expected: "string", Actual: "int"},
+		},
+		{
+			name:   "missing value",
+			op:     func(db Database) error { return db.Insert("users", "2", Record{"name": "Bob"}) },
+			is:     ErrSchemaViolation,
+			schema: &SchemaError{Table: "users", Column: "age", Expected: "int"},
+		},
+		{
+			name:   "index on an unknown column",
+			op:     func(db Database) error { return db.CreateIndex("users", "email") },
+			is:     ErrSchemaViolation,
+			schema: &SchemaError{Table: "users", Column: "email"},
+		},
+		{
+			name: "existing index",
+			op:   func(db Database) error { return db.CreateIndex("users", "name") },
+			is:   ErrIndexExists,
+		},
+		{
+			name: "write to a view",
+			op:   func(db Database) error { return db.Insert("users_by_age", "1", Record{"age": 1, "users": 1}) },
+			is:   ErrReadOnlyTable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewInMemoryDB()
+			mustSucceed(t, db.CreateTable("users", map[string]string{"name": "string", "age": "int"}))
+			mustSucceed(t, db.Insert("users", "1", Record{"name": "Alice", "age": 30}))
+			mustSucceed(t, db.CreateIndex("users", "name"))
+			mustSucceed(t, db.CreateMaterializedView("users_by_age", ViewQuery{
+				Table:      "users",
+				GroupBy:    []string{"age"},
+				Aggregates: []Aggregate{{Function: "COUNT", Alias: "users"}},
+			}))
+
+			err := tt.op(db)
+			if !errors.Is(err, tt.is) {
+				t.Fatalf("error = %v, want %v", err, tt.is)
+			}
+			var schemaErr *SchemaError
+			if got := errors.As(err, &schemaErr); got != (tt.schema != nil) {
+				t.Fatalf("error = %v, want a *SchemaError: %t", err, tt.schema != nil)
+			}
+			if tt.schema != nil && *schemaErr != *tt.schema {
+				t.Errorf("SchemaError = %+v, want %+v", *schemaErr, *tt.schema)
+			}
+		})
+	}
+}
@@ -0,0 +1,360 @@
+package inmemorydb
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Expr computes a value from a record. A nil value plays the role of SQL
+// NULL: missing columns evaluate to nil and most functions propagate it.
+type Expr interface {
+	Eval(record Record) (interface{}, error)
+	String() string
+}
+
+// Projection is an expression in the SELECT list. The result is stored under
+// Alias, or under the expression's text when no alias is given.
+type Projection struct {
+	Expr  Expr
+	Alias string
+}
+
+func As(expr Expr, alias string) Projection {
+	return Projection{Expr: expr, Alias: alias}
+}
+
+func (p Projection) name() string {
+	if p.Alias != "" {
+		return p.Alias
+	}
+	return p.Expr.String()
+}
+
+// dateLayouts are the string formats accepted by the date functions.
+var dateLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+type columnExpr string
+
+// Col refers to a stored attribute.
+func Col(name string) Expr { return columnExpr(name) }
+
+func (c columnExpr) Eval(record Record) (interface{}, error) { return record[string(c)], nil }
+func (c columnExpr) String() string                          { return string(c) }
+
+type literalExpr struct{ value interface{} }
+
+// Lit is a constant value.
+func Lit(value interface{}) Expr { return literalExpr{value: value} }
+
+func (l literalExpr) Eval(Record) (interface{}, error) { return l.value, nil }
+
+func (l literalExpr) String() string {
+	if s, ok := l.value.(string); ok {
+		return fmt.Sprintf("'%s'", s)
+	}
+	return fmt.Sprint(l.value)
+}
+
+type arithmeticExpr struct {
+	operator    string
+	left, right Expr
+}
+
+func Add(left, right Expr) Expr { return arithmeticExpr{"+", left, right} }
+func Sub(left, right Expr) Expr { return arithmeticExpr{"-", left, right} }
+func Mul(left, right Expr) Expr { return arithmeticExpr{"*", left, right} }
+func Div(left, right Expr) Expr { return arithmeticExpr{"/", left, right} }
+
+func (a arithmeticExpr) String() string {
+	return fmt.Sprintf("(%s %s %s)", a.left, a.operator, a.right)
+}
+
+// Eval keeps integer arithmetic in integers: two operands of the same integer
+// type give that type, and integers of different types give int64. It
+// switches to float64 as soon as one operand is a float. A Decimal with a
+// Decimal or an integer stays exact. Durations can be added to and subtracted
+// from each other, and multiplied or divided by an integer.
+func (a arithmeticExpr) Eval(record Record) (interface{}, error) {
+	left, right, err := evalPair(record, a.left, a.right)
+	if err != nil || left == nil || right == nil {
+		return nil, err
+	}
+	if zero, ok := convertToFloat(right); ok && zero == 0 && a.operator == "/" {
+		return nil, fmt.Errorf("division by zero in %s", a)
+	}
+
+	_, leftIsDuration := left.(time.Duration)
+	_, rightIsDuration := right.(time.Duration)
+	if leftIsDuration || rightIsDuration {
+		return a.evalDurations(left, right)
+	}
+
+	_, leftIsDecimal := left.(Decimal)
+	_, rightIsDecimal := right.(Decimal)
+	leftDecimal, ok1 := convertToDecimal(left)
+	rightDecimal, ok2 := convertToDecimal(right)
+	if (leftIsDecimal || rightIsDecimal) && ok1 && ok2 {
+		switch a.operator {
+		case "+":
+			return leftDecimal.Add(rightDecimal)
+		case "-":
+			return leftDecimal.Sub(rightDecimal)
+		case "*":
+			return leftDecimal.Mul(rightDecimal)
+		default:
+			return leftDecimal.Div(rightDecimal)
+		}
+	}
+
+	if result, ok := sameTypeArithmetic(a.operator, left, right); ok {
+		return result, nil
+	}
+	leftInt, leftIsInt := convertToInt64(left)
+	rightInt, rightIsInt := convertToInt64(right)
+	if leftIsInt && rightIsInt {
+		return integerArithmetic(a.operator, leftInt, rightInt), nil
+	}
+
+	leftFloat, ok1 := convertToFloat(left)
+	rightFloat, ok2 := convertToFloat(right)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("%s: operands must be numeric, got %T and %T", a, left, right)
+	}
+	switch a.operator {
+	case "+":
+		return leftFloat + rightFloat, nil
+	case "-":
+		return leftFloat - rightFloat, nil
+	case "*":
+		return leftFloat * rightFloat, nil
+	default:
+		return leftFloat / rightFloat, nil
+	}
+}
+
+func (a arithmeticExpr) evalDurations(left, right interface{}) (interface{}, error) {
+	leftDuration, leftIsDuration := left.(time.Duration)
+	rightDuration, rightIsDuration := right.(time.Duration)
+	leftInt, leftIsInt := convertToInt64(left)
+	rightInt, rightIsInt := convertToInt64(right)
+	switch {
+	case leftIsDuration && rightIsDuration && a.operator == "+":
+		return leftDuration + rightDuration, nil
+	case leftIsDuration && rightIsDuration && a.operator == "-":
+		return leftDuration - rightDuration, nil
+	case leftIsDuration && rightIsInt && a.operator == "*":
+		return leftDuration * time.Duration(rightInt), nil
+	case leftIsInt && rightIsDuration && a.operator == "*":
+		return time.Duration(leftInt) * rightDuration, nil
+	case leftIsDuration && rightIsInt && a.operator == "/":
+		return leftDuration / time.Duration(rightInt), nil
+	}
+	return nil, fmt.Errorf("%s: cannot apply %s to %T and %T", a, a.operator, left, right)
+}
+
+type integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 | ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64
+}
+
+// sameTypeArithmetic applies operator to two integers of the same type. ok is
+// false when the operands are not integers of the same type.
+func sameTypeArithmetic(operator string, left, right interface{}) (result interface{}, ok bool) {
+	switch l := left.(type) {
+	case int:
+		return sameType(operator, l, right)
+	case int8:
+		return sameType(operator, l, right)
+	case int16:
+		return sameType(operator, l, right)
+	case int32:
+		return sameType(operator, l, right)
+	case int64:
+		return sameType(operator, l, right)
+	case uint:
+		return sameType(operator, l, right)
+	case uint8:
+		return sameType(operator, l, right)
+	case uint16:
+		return sameType(operator, l, right)
+	case uint32:
+		return sameType(operator, l, right)
+	case uint64:
+		return sameType(operator, l, right)
+	default:
+		return nil, false
+	}
+}
+
+func sameType[T integer](operator string, left T, right interface{}) (interface{}, bool) {
+	r, ok := right.(T)
+	if !ok {
+		return nil, false
+	}
+	return integerArithmetic(operator, left, r), true
+}
+
+// integerArithmetic applies operator to two integers. The caller must have
+// rejected a division by zero.
+func integerArithmetic[T integer](operator string, left, right T) T {
+	switch operator {
+	case "+":
+		return left + right
+	case "-":
+		return left - right
+	case "*":
+		return left * right
+	default:
+		return left / right
+	}
+}
+
+type functionExpr struct {
+	name string
+	args []Expr
+	call func(args []interface{}) (interface{}, error)
+}
+
+func (f functionExpr) String() string {
+	args := make([]string, len(f.args))
+	for i, arg := range f.args {
+		args[i] = arg.String()
+	}
+	return fmt.Sprintf("%s(%s)", f.name, strings.Join(args, ", "))
+}
+
+func (f functionExpr) Eval(record Record) (interface{}, error) {
+	args := make([]interface{}, len(f.args))
+	for i, arg := range f.args {
+		value, err := arg.Eval(record)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+	value, err := f.call(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f, err)
+	}
+	return value, nil
+}
+
+// stringFunction wraps a function of one string argument that returns NULL
+// for NULL input.
+func stringFunction(name string, arg Expr, fn func(string) interface{}) Expr {
+	return functionExpr{name: name, args: []Expr{arg}, call: func(args []interface{}) (interface{}, error) {
+		if args[0] == nil {
+			return nil, nil
+		}
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("expected string, got %T", args[0])
+		}
+		return fn(s), nil
+	}}
+}
+
+func Upper(arg Expr) Expr {
+	return stringFunction("UPPER", arg, func(s string) interface{} { return strings.ToUpper(s) })
+}
+
+func Lower(arg Expr) Expr {
+	return stringFunction("LOWER", arg, func(s string) interface{} { return strings.ToLower(s) })
+}
+
+func Length(arg Expr) Expr {
+	return stringFunction("LENGTH", arg, func(s string) interface{} { return len([]rune(s)) })
+}
+
+// Concat joins the text of its arguments. Like most SQL dialects it returns
+// NULL when any argument is NULL.
+func Concat(args ...Expr) Expr {
+	return functionExpr{name: "CONCAT", args: args, call: func(values []interface{}) (interface{}, error) {
+		var sb strings.Builder
+		for _, value := range values {
+			if value == nil {
+				return nil, nil
+			}
+			fmt.Fprint(&sb, value)
+		}
+		return sb.String(), nil
+	}}
+}
+
+// Coalesce returns its first non-NULL argument.
+func Coalesce(args ...Expr) Expr {
+	return functionExpr{name: "COALESCE", args: args, call: func(values []interface{}) (interface{}, error) {
+		for _, value := range values {
+			if value != nil {
+				return value, nil
+			}
+		}
+		return nil, nil
+	}}
+}
+
+// dateFunction wraps a function of date arguments. Dates may be time.Time
+// values or strings in one of dateLayouts.
+func dateFunction(name string, fn func(dates []time.Time) interface{}, args ...Expr) Expr {
+	return functionExpr{name: name, args: args, call: func(values []interface{}) (interface{}, error) {
+		dates := make([]time.Time, len(values))
+		for i, value := range values {
+			if value == nil {
+				return nil, nil
+			}
+			date, err := toTime(value)
+			if err != nil {
+				return nil, err
+			}
+			dates[i] = date
+		}
+		return fn(dates), nil
+	}}
+}
+
+func Year(arg Expr) Expr {
+	return dateFunction("YEAR", func(d []time.Time) interface{} { return d[0].Year() }, arg)
+}
+
+func Month(arg Expr) Expr {
+	return dateFunction("MONTH", func(d []time.Time) interface{} { return int(d[0].Month()) }, arg)
+}
+
+func Day(arg Expr) Expr {
+	return dateFunction("DAY", func(d []time.Time) interface{} { return d[0].Day() }, arg)
+}
+
+// DateDiff returns the number of whole days from start to end.
+func DateDiff(end, start Expr) Expr {
+	return dateFunction("DATEDIFF", func(d []time.Time) interface{} {
+		return int(d[0].Sub(d[1]).Hours() / 24)
+	}, end, start)
+}
+
+func toTime(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("cannot parse %q as a date", v)
+	default:
+		return time.Time{}, fmt.Errorf("expected date, got %T", value)
+	}
+}
+
+func evalPair(record Record, left, right Expr) (interface{}, interface{}, error) {
+	leftValue, err := left.Eval(record)
+	if err != nil {
+		return nil, nil, err
+	}
+	rightValue, err := right.Eval(record)
+	if err != nil {
+		return nil, nil, err
+	}
+	return leftValue, rightValue, nil
+}
@@ -0,0 +1,132 @@
+package inmemorydb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExprEval(t *testing.T) {
+	record := Record{
+		"name":   "Alice",
+		"age":    30,
+		"height": 1.5,
+		"city":   nil,
+		"joined": "2024-03-15",
+		"left":   time.Date(2024, time.April, 14, 0, 0, 0, 0, time.UTC),
+		"rides":  int64(7),
+		"seats":  int32(3),
+		"trip":   90 * time.Minute,
+	}
+	tests := []struct {
+		expr    Expr
+		want    interface{}
+		wantErr bool
+	}{
+		{expr: Col("name"), want: "Alice"},
+		{expr: Col("missing"), want: nil},
+		{expr: Add(Col("age"), Lit(5)), want: 35},
+		{expr: Div(Col("age"), Lit(4)), want: 7},
+		{expr: Mul(Col("age"), Col("height")), want: 45.0},
+		{expr: Sub(Col("age"), Col("city")), want: nil},
+		{expr: Div(Col("age"), Lit(0)), wantErr: true},
+		{expr: Add(Col("name"), Lit(1)), wantErr: true},
+		{expr: Add(Col("rides"), Lit(int64(1))), want: int64(8)},
+		{expr: Div(Col("rides"), Lit(int64(2))), want: int64(3)},
+		{expr: Mul(Col("seats"), Lit(int32(2))), want: int32(6)},
+		{expr: Add(Col("rides"), Col("age")), want: int64(37)},
+		{expr: Sub(Col("seats"), Lit(uint8(1))), want: int64(2)},
+		{expr: Div(Col("rides"), Lit(int64(0))), wantErr: true},
+		{expr: Add(Col("trip"), Lit(30*time.Minute)), want: 2 * time.Hour},
+		{expr: Sub(Col("trip"), Lit(time.Hour)), want: 30 * time.Minute},
+		{expr: Mul(Col("trip"), Lit(2)), want: 3 * time.Hour},
+		{expr: Mul(Col("seats"), Col("trip")), want: 270 * time.Minute},
+		{expr: Div(Col("trip"), Lit(3)), want: 30 * time.Minute},
+		{expr: Div(Col("trip"), Lit(0)), wantErr: true},
+		{expr: Add(Col("trip"), Lit(5)), wantErr: true},
+		{expr: Mul(Col("trip"), Col("trip")), wantErr: true},
+		{expr: Mul(Col("trip"), Col("height")), wantErr: true},
+		{expr: Upper(Col("name")), want: "ALICE"},
+		{expr: Lower(Col("name")), want: "alice"},
+		{expr: Length(Lit("héllo")), want: 5},
+		{expr: Length(Col("city")), want: nil},
+		{expr: Upper(Col("age")), wantErr: true},
+		{expr: Concat(Col("name"), Lit(" is "), Col("age")), want: "Alice is 30"},
+		{expr: Concat(Col("name"), Col("city")), want: nil},
+		{expr: Coalesce(Col("city"), Lit("unknown")), want: "unknown"},
+		{expr: Year(Col("joined")), want: 2024},
+		{expr: Month(Col("joined")), want: 3},
+		{expr: Day(Col("left")), want: 14},
+		{expr: DateDiff(Col("left"), Col("joined")), want: 30},
+		{expr: Year(Lit("yesterday")), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr.String(), func(t *testing.T) {
+			got, err := tt.expr.Eval(record)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Eval() error = %v, want error: %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Eval() = %v (%T), want %v (%T)", got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectExpressions(t *testing.T) {
+	db := NewInMemoryDB()
+	mustSucceed(t, db.CreateTable("users", map[string]string{"name": "string", "age": "int"}))
+	mustSucceed(t, db.Insert("users", "1", Record{"name": "Alice", "age": 30}))
+	mustSucceed(t, db.Insert("users", "2", Record{"name": "Bob", "age": 25}))
+
+	tests := []struct {
+		name        string
+		projections []Projection
+		conditions  []Condition
+		want        []map[string]interface{}
+	}{
+		{
+			name:        "alias and default name",
+			projections: []Projection{{Expr: Upper(Col("name"))}, As(Add(Col("age"), Lit(5)), "age_in_5_years")},
+			conditions:  []Condition{{Attribute: "name", Operator: "=", Value: "Alice"}},
+			want:        []map[string]interface{}{{"UPPER(name)": "ALICE", "age_in_5_years": 35}},
+		},
+		{
+			name:        "condition on an expression",
+			projections: []Projection{{Expr: Col("name")}},
+			conditions:  []Condition{{Expr: Length(Col("name")), Operator: ">", Value: 3}},
+			want:        []map[string]interface{}{{"name": "Alice"}},
+		},
+		{
+			name:        "no match",
+			projections: []Projection{{Expr: Col("name")}},
+			conditions:  []Condition{{Expr: Mul(Col("age"), Lit(2)), Operator: ">", Value: 100}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.SelectExpressions("users", tt.projections, tt.conditions, "AND")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("SelectExpressions() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				for column, value := range tt.want[i] {
+					if got[i][column] != value {
+						t.Errorf("row %d: %s = %v, want %v", i, column, got[i][column], value)
+					}
+				}
+			}
+		})
+	}
+}
+
+func mustSucceed(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
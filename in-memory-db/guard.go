@@ -0,0 +1,256 @@
+package inmemorydb
+
+import (
+	"context"
+	"io"
+)
+
+// AllTables stands for every table in grants and access checks.
+const AllTables = "*"
+
+type Permission int
+
+const (
+	PermissionNone Permission = iota
+	PermissionRead
+	PermissionWrite // Implies PermissionRead
+	PermissionAdmin // Implies PermissionWrite
+)
+
+func (p Permission) String() string {
+	switch p {
+	case PermissionRead:
+		return "read"
+	case PermissionWrite:
+		return "write"
+	case PermissionAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+// guardedDB forwards every call to db once check has approved the access the
+// call needs. It is the base of read-only followers and user sessions.
+type guardedDB struct {
+	db    Database
+	check func(tableName string, required Permission) error
+}
+
+func (g *guardedDB) CreateTable(name string, schema map[string]string) error {
+	return g.CreateTableContext(context.Background(), name, schema)
+}
+
+func (g *guardedDB) CreateTableContext(ctx context.Context, name string, schema map[string]string) error {
+	if err := g.check(name, PermissionAdmin); err != nil {
+		return err
+	}
+	return g.db.CreateTableContext(ctx, name, schema)
+}
+
+func (g *guardedDB) Insert(tableName string, key string, record Record) error {
+	return g.InsertContext(context.Background(), tableName, key, record)
+}
+
+func (g *guardedDB) InsertContext(ctx context.Context, tableName string, key string, record Record) error {
+	if err := g.check(tableName, PermissionWrite); err != nil {
+		return err
+	}
+	return g.db.InsertContext(ctx, tableName, key, record)
+}
+
+func (g *guardedDB) Select(tableName, attribute, whereKey string, whereValue interface{}) ([]interface{}, error) {
+	return g.SelectContext(context.Background(), tableName, attribute, whereKey, whereValue)
+}
+
+func (g *guardedDB) SelectContext(ctx context.Context, tableName, attribute, whereKey string, whereValue interface{}) ([]interface{}, error) {
+	if err := g.check(tableName, PermissionRead); err != nil {
+		return nil, err
+	}
+	return g.db.SelectContext(ctx, tableName, attribute, whereKey, whereValue)
+}
+
+func (g *guardedDB) SelectWithConditions(
+	tableName string,
+	selectAttributes []string,
+	conditions []Condition,
+	logicalOperator string,
+) ([]map[string]interface{}, error) {
+	return g.SelectWithConditionsContext(context.Background(), tableName, selectAttributes, conditions, logicalOperator)
+}
+
+func (g *guardedDB) SelectWithConditionsContext(
+	ctx context.Context,
+	tableName string,
+	selectAttributes []string,
+	conditions []Condition,
+	logicalOperator string,
+) ([]map[string]interface{}, error) {
+	if err := g.check(tableName, PermissionRead); err != nil {
+		return nil, err
+	}
+	return g.db.SelectWithConditionsContext(ctx, tableName, selectAttributes, conditions, logicalOperator)
+}
+
+func (g *guardedDB) SelectExpressions(
+	tableName string,
+	projections []Projection,
+	conditions []Condition,
+	logicalOperator string,
+) ([]map[string]interface{}, error) {
+	return g.SelectExpressionsContext(context.Background(), tableName, projections, conditions, logicalOperator)
+}
+
+func (g *guardedDB) SelectExpressionsContext(
+	ctx context.Context,
+	tableName string,
+	projections []Projection,
+	conditions []Condition,
+	logicalOperator string,
+) ([]map[string]interface{}, error) {
+	if err := g.check(tableName, PermissionRead); err != nil {
+		return nil, err
+	}
+	return g.db.SelectExpressionsContext(ctx, tableName, projections, conditions, logicalOperator)
+}
+
+func (g *guardedDB) Get(tableName string, key string) (Record, uint64, error) {
+	return g.GetContext(context.Background(), tableName, key)
+}
+
+func (g *guardedDB) GetContext(ctx context.Context, tableName string, key string) (Record, uint64, error) {
+	if err := g.check(tableName, PermissionRead); err != nil {
+		return nil, 0, err
+	}
+	return g.db.GetContext(ctx, tableName, key)
+}
+
+func (g *guardedDB) CreateIndex(tableName string, columns ...string) error {
+	return g.CreateIndexContext(context.Background(), tableName, columns...)
+}
+
+func (g *guardedDB) CreateIndexContext(ctx context.Context, tableName string, columns ...string) error {
+	if err := g.check(tableName, PermissionAdmin); err != nil {
+		return err
+	}
+	return g.db.CreateIndexContext(ctx, tableName, columns...)
+}
+
+func (g *guardedDB) CreateUniqueIndex(tableName string, columns ...string) error {
+	return g.CreateUniqueIndexContext(context.Background(), tableName, columns...)
+}
+
+func (g *guardedDB) CreateUniqueIndexContext(ctx context.Context, tableName string, columns ...string) error {
+	if err := g.check(tableName, PermissionAdmin); err != nil {
+		return err
+	}
+	return g.db.CreateUniqueIndexContext(ctx, tableName, columns...)
+}
+
+func (g *guardedDB) Delete(tableName string, key string) error {
+	return g.DeleteContext(context.Background(), tableName, key)
+}
+
+func (g *guardedDB) DeleteContext(ctx context.Context, tableName string, key string) error {
+	if err := g.check(tableName, PermissionWrite); err != nil {
+		return err
+	}
+	return g.db.DeleteContext(ctx, tableName, key)
+}
+
+func (g *guardedDB) InsertIfAbsent(tableName string, key string, record Record) (uint64, error) {
+	return g.InsertIfAbsentContext(context.Background(), tableName, key, record)
+}
+
+func (g *guardedDB) InsertIfAbsentContext(ctx context.Context, tableName string, key string, record Record) (uint64, error) {
+	if err := g.check(tableName, PermissionWrite); err != nil {
+		return 0, err
+	}
+	return g.db.InsertIfAbsentContext(ctx, tableName, key, record)
+}
+
+func (g *guardedDB) UpdateIfVersion(tableName string, key string, updates Record, version uint64) (uint64, error) {
+	return g.UpdateIfVersionContext(context.Background(), tableName, key, updates, version)
+}
+
+func (g *guardedDB) UpdateIfVersionContext(ctx context.Context, tableName string, key string, updates Record, version uint64) (uint64, error) {
+	if err := g.check(tableName, PermissionWrite); err != nil {
+		return 0, err
+	}
+	return g.db.UpdateIfVersionContext(ctx, tableName, key, updates, version)
+}
+
+func (g *guardedDB) DeleteIfVersion(tableName string, key string, version uint64) error {
+	return g.DeleteIfVersionContext(context.Background(), tableName, key, version)
+}
+
+func (g *guardedDB) DeleteIfVersionContext(ctx context.Context, tableName string, key string, version uint64) error {
+	if err := g.check(tableName, PermissionWrite); err != nil {
+		return err
+	}
+	return g.db.DeleteIfVersionContext(ctx, tableName, key, version)
+}
+
+// Backup reads every table, so it needs read access to all of them.
+func (g *guardedDB) Backup(w io.Writer) error {
+	return g.BackupContext(context.Background(), w)
+}
+
+func (g *guardedDB) BackupContext(ctx context.Context, w io.Writer) error {
+	if err := g.check(AllTables, PermissionRead); err != nil {
+		return err
+	}
+	return g.db.BackupContext(ctx, w)
+}
+
+func (g *guardedDB) Restore(r io.Reader) error {
+	return g.RestoreContext(context.Background(), r)
+}
+
+func (g *guardedDB) RestoreContext(ctx context.Context, r io.Reader) error {
+	if err := g.check(AllTables, PermissionAdmin); err != nil {
+		return err
+	}
+	return g.db.RestoreContext(ctx, r)
+}
+
+// RegisterTrigger needs admin rights on the table. The trigger keeps the
+// caller's grants: whatever it reads or writes through its TriggerTx is
+// checked like a call of the caller's own.
+func (g *guardedDB) RegisterTrigger(tableName string, kind TriggerKind, timing TriggerTiming, fn TriggerFunc) error {
+	if err := g.check(tableName, PermissionAdmin); err != nil {
+		return err
+	}
+	guarded := func(event *TriggerEvent) error {
+		tx := event.Tx
+		event.Tx = tx.guarded(g.check)
+		defer func() { event.Tx = tx }()
+		return fn(event)
+	}
+	return g.db.RegisterTrigger(tableName, kind, timing, guarded)
+}
+
+// CreateMaterializedView needs admin rights on the view and read access to
+// its source.
+func (g *guardedDB) CreateMaterializedView(name string, query ViewQuery) error {
+	if err := g.check(name, PermissionAdmin); err != nil {
+		return err
+	}
+	if err := g.check(query.Table, PermissionRead); err != nil {
+		return err
+	}
+	return g.db.CreateMaterializedView(name, query)
+}
+
+// Stats only describes the tables the caller may read.
+func (g *guardedDB) Stats() Stats {
+	stats := g.db.Stats()
+	tables := stats.Tables[:0:0]
+	for _, table := range stats.Tables {
+		if g.check(table.Name, PermissionRead) == nil {
+			tables = append(tables, table)
+		}
+	}
+	stats.Tables = tables
+	return stats
+}
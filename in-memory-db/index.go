@@ -0,0 +1,221 @@
+package inmemorydb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// maxIndexColumns bounds the number of columns of a composite index, so the
+// values of an index entry fit a fixed-size, comparable tuple.
+const maxIndexColumns = 4
+
+// indexTuple holds the values of the indexed columns of a row. Slots past the
+// number of columns of the index stay nil.
+type indexTuple [maxIndexColumns]interface{}
+
+// index maps the values of one or more columns to the keys of the rows
+// holding them. The distinct tuples are also kept in ascending order, so
+// range conditions and prefixes of a composite index can be answered without
+// scanning the table.
+type index struct {
+	columns []string
+	unique  bool                    // No two rows may share a tuple without nil values
+	entries map[indexTuple][]string // Tuple -> List of Row IDs
+	sorted  []indexTuple            // Distinct tuples in ascending order
+	hits    atomic.Uint64           // Queries answered through the index
+}
+
+func newIndex(columns []string, unique bool) *index {
+	return &index{columns: columns, unique: unique, entries: make(map[indexTuple][]string)}
+}
+
+// indexName is the name an index over columns is stored under.
+func indexName(columns []string) string {
+	return strings.Join(columns, ",")
+}
+
+func checkIndexColumns(table *Table, columns []string) error {
+	if len(columns) == 0 || len(columns) > maxIndexColumns {
+		return fmt.Errorf("an index needs between 1 and %d columns, got %d", maxIndexColumns, len(columns))
+	}
+	seen := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		if _, exists := table.schema[column]; !exists {
+			return &SchemaError{Table: table.name, Column: column}
+		}
+		if seen[column] {
+			return fmt.Errorf("column %s appears twice in the index", column)
+		}
+		seen[column] = true
+	}
+	return nil
+}
+
+func (ix *index) tuple(record Record) indexTuple {
+	var tuple indexTuple
+	for i, column := range ix.columns {
+		tuple[i] = indexKey(record[column])
+	}
+	return tuple
+}
+
+// compareTuples orders tuples by their first n values.
+func compareTuples(a, b indexTuple, n int) int {
+	for i := 0; i < n; i++ {
+		if result := compareKeys(a[i], b[i]); result != 0 {
+			return result
+		}
+	}
+	return 0
+}
+
+func (ix *index) add(record Record, key string) {
+	tuple := ix.tuple(record)
+	keys, exists := ix.entries[tuple]
+	ix.entries[tuple] = append(keys, key)
+	if exists {
+		return
+	}
+	n := len(ix.columns)
+	i := sort.Search(len(ix.sorted), func(i int) bool { return compareTuples(ix.sorted[i], tuple, n) >= 0 })
+	ix.sorted = append(ix.sorted, indexTuple{})
+	copy(ix.sorted[i+1:], ix.sorted[i:])
+	ix.sorted[i] = tuple
+}
+
+func (ix *index) remove(record Record, key string) {
+	tuple := ix.tuple(record)
+	keys := ix.entries[tuple]
+	for i, indexedKey := range keys {
+		if indexedKey == key {
+			keys = append(keys[:i], keys[i+1:]...)
+			break
+		}
+	}
+	if len(keys) > 0 {
+		ix.entries[tuple] = keys
+		return
+	}
+	delete(ix.entries, tuple)
+	n := len(ix.columns)
+	i := sort.Search(len(ix.sorted), func(i int) bool { return compareTuples(ix.sorted[i], tuple, n) >= 0 })
+	if i < len(ix.sorted) && compareTuples(ix.sorted[i], tuple, n) == 0 {
+		ix.sorted = append(ix.sorted[:i], ix.sorted[i+1:]...)
+	}
+}
+
+// conflict returns the key of another row that already holds the tuple of
+// record in a unique index. Tuples with nil values never conflict.
+func (ix *index) conflict(record Record, key string) (string, bool) {
+	if !ix.unique {
+		return "", false
+	}
+	tuple := ix.tuple(record)
+	for i := range ix.columns {
+		if tuple[i] == nil {
+			return "", false
+		}
+	}
+	for _, other := range ix.entries[tuple] {
+		if other != key {
+			return other, true
+		}
+	}
+	return "", false
+}
+
+// indexMatch describes how far an index can answer a query: equal holds the
+// values the leading columns are compared with, and last is an optional
+// condition on the next column.
+type indexMatch struct {
+	equal []interface{}
+	last  *Condition
+}
+
+// match pairs the leading columns of the index with the conditions. ok is
+// false when the first column has no usable condition.
+func (ix *index) match(conditions []Condition) (indexMatch, bool) {
+	var m indexMatch
+	for _, column := range ix.columns {
+		var equal, other *Condition
+		for i := range conditions {
+			condition := &conditions[i]
+			if condition.Expr != nil || condition.Attribute != column {
+				continue
+			}
+			switch condition.Operator {
+			case "=":
+				equal = condition
+			case "<", "<=", ">", ">=", "BETWEEN":
+				other = condition
+			}
+		}
+		if equal == nil {
+			m.last = other
+			break
+		}
+		m.equal = append(m.equal, equal.Value)
+	}
+	return m, len(m.equal) > 0 || m.last != nil
+}
+
+// search returns the keys of the rows whose leading columns match m.
+func (ix *index) search(m indexMatch) []string {
+	// prefix builds a bound from the equal values followed by values.
+	prefix := func(values ...interface{}) (indexTuple, int) {
+		var tuple indexTuple
+		n := 0
+		for _, value := range append(m.equal[:len(m.equal):len(m.equal)], values...) {
+			tuple[n] = indexKey(value)
+			n++
+		}
+		return tuple, n
+	}
+	if m.last == nil && len(m.equal) == len(ix.columns) {
+		tuple, _ := prefix()
+		if keys, exists := ix.entries[tuple]; exists {
+			return keys
+		}
+		// Values of another type may still be equal, like a Decimal and an
+		// int, so look them up in order
+	}
+
+	var low, high int // Positions in sorted, high is exclusive
+	if m.last == nil {
+		low, high = ix.lowerBound(prefix()), ix.upperBound(prefix())
+	} else {
+		value, second := m.last.Value, m.last.SecondValue
+		switch m.last.Operator {
+		case "<":
+			low, high = ix.lowerBound(prefix()), ix.lowerBound(prefix(value))
+		case "<=":
+			low, high = ix.lowerBound(prefix()), ix.upperBound(prefix(value))
+		case ">":
+			low, high = ix.upperBound(prefix(value)), ix.upperBound(prefix())
+		case ">=":
+			low, high = ix.lowerBound(prefix(value)), ix.upperBound(prefix())
+		case "BETWEEN":
+			low, high = ix.lowerBound(prefix(value)), ix.upperBound(prefix(second))
+		}
+	}
+
+	var keys []string
+	for i := low; i < high; i++ {
+		keys = append(keys, ix.entries[ix.sorted[i]]...)
+	}
+	return keys
+}
+
+// lowerBound is the position of the first tuple whose first n values are >=
+// bound.
+func (ix *index) lowerBound(bound indexTuple, n int) int {
+	return sort.Search(len(ix.sorted), func(i int) bool { return compareTuples(ix.sorted[i], bound, n) >= 0 })
+}
+
+// upperBound is the position of the first tuple whose first n values are >
+// bound.
+func (ix *index) upperBound(bound indexTuple, n int) int {
+	return sort.Search(len(ix.sorted), func(i int) bool { return compareTuples(ix.sorted[i], bound, n) > 0 })
+}
@@ -0,0 +1,183 @@
+package inmemorydb
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestCompositeIndex(t *testing.T) {
+	db := NewInMemoryDB()
+	mustSucceed(t, db.CreateTable("users", map[string]string{"name": "string", "city": "string", "age": "int"}))
+	users := []struct {
+		name, city string
+		age        int
+	}{
+		{"Alice", "Paris", 30}, {"Bob", "Paris", 25}, {"Carol", "Paris", 40},
+		{"Dave", "Rome", 30}, {"Eve", "Rome", 35}, {"Frank", "Oslo", 30},
+	}
+	for i, user := range users {
+		mustSucceed(t, db.Insert("users", fmt.Sprint(i), Record{"name": user.name, "city": user.city, "age": user.age}))
+	}
+	mustSucceed(t, db.CreateIndex("users", "city"))
+	mustSucceed(t, db.CreateIndex("users", "city", "age"))
+
+	tests := []struct {
+		name       string
+		conditions []Condition
+		want       []string
+		index      string // Index expected to serve the query, "" for a full scan
+	}{
+		{
+			name:       "equality on both columns",
+			conditions: []Condition{{Attribute: "city", Operator: "=", Value: "Paris"}, {Attribute: "age", Operator: "=", Value: 30}},
+			want:       []string{"Alice"},
+			index:      "city,age",
+		},
+		{
+			name:       "equality then range",
+			conditions: []Condition{{Attribute: "city", Operator: "=", Value: "Paris"}, {Attribute: "age", Operator: ">=", Value: 30}},
+			want:       []string{"Alice", "Carol"},
+			index:      "city,age",
+		},
+		{
+			name:       "equality then between",
+			conditions: []Condition{{Attribute: "city", Operator: "=", Value: "Rome"}, {Attribute: "age", Operator: "BETWEEN", Value: 20, SecondValue: 32}},
+			want:       []string{"Dave"},
+			index:      "city,age",
+		},
+		{
+			name:       "prefix only",
+			conditions: []Condition{{Attribute: "city", Operator: "=", Value: "Rome"}},
+			want:       []string{"Dave", "Eve"},
+			index:      "city",
+		},
+		{
+			name:       "second column only",
+			conditions: []Condition{{Attribute: "age", Operator: "=", Value: 30}},
+			want:       []string{"Alice", "Dave", "Frank"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := indexHits(db)
+			rows, err := db.SelectWithConditions("users", []string{"name"}, tt.conditions, "AND")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, row := range rows {
+				got = append(got, row["name"].(string))
+			}
+			sort.Strings(got)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("names = %v, want %v", got, tt.want)
+			}
+
+			after := indexHits(db)
+			for name := range after {
+				used := after[name] > before[name]
+				if used != (name == tt.index) {
+					t.Errorf("index %s used: %t, want the query served by %q", name, used, tt.index)
+				}
+			}
+		})
+	}
+}
+
+// indexHits returns the hits of every index of the users table by name.
+func indexHits(db Database) map[string]uint64 {
+	hits := make(map[string]uint64)
+	for _, table := range db.(*InMemoryDB).Stats().Tables {
+		if table.Name == "users" {
+			for _, index := range table.Indexes {
+				hits[index.Name] = index.Hits
+			}
+		}
+	}
+	return hits
+}
+
+func TestUniqueIndex(t *testing.T) {
+	tests := []struct {
+		name     string
+		op       func(db Database) error
+		existing string // Row reported as holding the values, "" if the write succeeds
+	}{
+		{
+			name: "insert a duplicate",
+			op: func(db Database) error {
+				return db.Insert("users", "3", Record{"email": "a@example.com", "team": "red"})
+			},
+			existing: "1",
+		},
+		{
+			name: "insert the same email in another team",
+			op: func(db Database) error {
+				return db.Insert("users", "3", Record{"email": "a@example.com", "team": "blue"})
+			},
+		},
+		{
+			name: "update to a duplicate",
+			op: func(db Database) error {
+				_, err := db.UpdateIfVersion("users", "2", Record{"email": "a@example.com"}, 2)
+				return err
+			},
+			existing: "1",
+		},
+		{
+			name: "rewrite a row with its own values",
+			op: func(db Database) error {
+				return db.Insert("users", "1", Record{"email": "a@example.com", "team": "red"})
+			},
+		},
+		{
+			name: "reuse values of a deleted row",
+			op: func(db Database) error {
+				mustSucceed(t, db.Delete("users", "1"))
+				return db.Insert("users", "3", Record{"email": "a@example.com", "team": "red"})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewInMemoryDB()
+			mustSucceed(t, db.CreateTable("users", map[string]string{"email": "string", "team": "string"}))
+			mustSucceed(t, db.Insert("users", "1", Record{"email": "a@example.com", "team": "red"}))
+			mustSucceed(t, db.Insert("users", "2", Record{"email": "b@example.com", "team": "red"}))
+			mustSucceed(t, db.CreateUniqueIndex("users", "email", "team"))
+
+			err := tt.op(db)
+			if tt.existing == "" {
+				mustSucceed(t, err)
+				return
+			}
+			var violation *UniqueViolationError
+			if !errors.Is(err, ErrUniqueViolation) || !errors.As(err, &violation) {
+				t.Fatalf("error = %v, want a unique violation", err)
+			}
+			if violation.ExistingKey != tt.existing {
+				t.Errorf("ExistingKey = %s, want %s", violation.ExistingKey, tt.existing)
+			}
+			if record, _, err := db.Get("users", "2"); err != nil || record["email"] != "b@example.com" {
+				t.Errorf("Get() = %v, %v, want the rejected write rolled back", record, err)
+			}
+		})
+	}
+
+	t.Run("create over duplicates", func(t *testing.T) {
+		db := NewInMemoryDB()
+		mustSucceed(t, db.CreateTable("users", map[string]string{"email": "string", "team": "string"}))
+		mustSucceed(t, db.Insert("users", "1", Record{"email": "a@example.com", "team": "red"}))
+		mustSucceed(t, db.Insert("users", "2", Record{"email": "a@example.com", "team": "red"}))
+		if err := db.CreateUniqueIndex("users", "email", "team"); !errors.Is(err, ErrUniqueViolation) {
+			t.Fatalf("CreateUniqueIndex() error = %v, want %v", err, ErrUniqueViolation)
+		}
+		if indexes := db.(*InMemoryDB).Stats().Tables[0].Indexes; len(indexes) != 0 {
+			t.Errorf("indexes = %v, want none", indexes)
+		}
+	})
+}
@@ -1,98 +1,119 @@
 package inmemorydb
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type InMemoryDB struct {
 	tables map[string]*Table //Table Name --> table Instance
 	dbLock sync.RWMutex
+
+	scopeLock sync.Mutex // Held by writes that lock more than one table, see writeScope
+
+	listeners      map[int]mutationListener // Notified of every committed mutation
+	nextListenerID int
+	listenerLock   sync.RWMutex
+
+	operations operationStats             // Latency of every operation
+	cache      atomic.Pointer[queryCache] // Nil unless enabled with EnableQueryCache
 }
 
 func NewInMemoryDB() Database {
 	return &InMemoryDB{
-		tables: make(map[string]*Table),
+		tables:    make(map[string]*Table),
+		listeners: make(map[int]mutationListener),
 	}
 }
 
+func (db *InMemoryDB) getTable(tableName string) (*Table, error) {
+	db.dbLock.RLock()
+	table, exists := db.tables[tableName]
+	db.dbLock.RUnlock()
+	if !exists {
+		return nil, tableNotFound(tableName)
+	}
+	return table, nil
+}
+
 func (db *InMemoryDB) CreateTable(name string, schema map[string]string) error {
+	return db.CreateTableContext(context.Background(), name, schema)
+}
+
+func (db *InMemoryDB) CreateTableContext(ctx context.Context, name string, schema map[string]string) error {
+	defer db.observe("create_table", time.Now())
 	db.dbLock.Lock()
 	defer db.dbLock.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if _, exists := db.tables[name]; exists {
-		return fmt.Errorf("Table %s already exists", name)
+		return tableExists(name)
 	}
 	db.tables[name] = &Table{
-		name:    name,
-		schema:  schema,
-		data:    make(map[string]Record),
-		indexes: make(map[string]map[interface{}][]string),
+		name:     name,
+		schema:   schema,
+		data:     make(map[string]Record),
+		versions: make(map[string]uint64),
+		indexes:  make(map[string]*index),
 	}
+	db.emit(Mutation{Type: MutationCreateTable, Table: name, Schema: cloneSchema(schema)})
 	return nil
 }
 
 func (db *InMemoryDB) Insert(tableName string, key string, record Record) error {
-	db.dbLock.RLock()
-	table, exists := db.tables[tableName]
-	db.dbLock.RUnlock()
-	if !exists {
-		return fmt.Errorf("table %s does not exist", tableName)
-	}
-
-	table.dataLock.Lock()
-	defer table.dataLock.Unlock()
-
-	//Validate schema
-	for column, dataType := range table.schema {
-		if value, ok := record[column]; ok {
-			if fmt.Sprintf("%T", value) != dataType {
-				return fmt.Errorf("invalid data type for column %s, expected %s", column, dataType)
-			}
-		} else {
-			//Ignore this if we want optional fields
-			return fmt.Errorf("missing value for column %s", column)
-		}
-	}
-
-	//Insert Data
-	table.data[key] = record
-
-	//Update indexes
-
-	table.indexLock.Lock()
-
-	for column, value := range record {
-		if index, exists := table.indexes[column]; exists {
-			// If index exists for this column, update it
-			index[value] = append(index[value], key)
-		}
-	}
-	table.indexLock.Unlock()
-	return nil
+	return db.InsertContext(context.Background(), tableName, key, record)
+}
 
+func (db *InMemoryDB) InsertContext(ctx context.Context, tableName string, key string, record Record) error {
+	defer db.observe("insert", time.Now())
+	return db.write(ctx, tableName, func(s *writeScope, table *Table) error {
+		_, err := s.insert(table, key, record, 0)
+		return err
+	})
 }
 
 func (db *InMemoryDB) Select(tableName, attribute, whereKey string, whereValue interface{}) ([]interface{}, error) {
-	db.dbLock.RLock()
-	table, exists := db.tables[tableName]
-	db.dbLock.RUnlock()
-	if !exists {
-		return nil, fmt.Errorf("table %s does not exist", tableName)
+	return db.SelectContext(context.Background(), tableName, attribute, whereKey, whereValue)
+}
+
+func (db *InMemoryDB) SelectContext(ctx context.Context, tableName, attribute, whereKey string, whereValue interface{}) ([]interface{}, error) {
+	defer db.observe("select", time.Now())
+	table, err := db.getTable(tableName)
+	if err != nil {
+		return nil, err
 	}
 
-	table.dataLock.RLock()
+	table.rlockData()
 	defer table.dataLock.RUnlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	result := []interface{}{}
-	if index, ok := table.indexes[whereKey]; ok { // Use index if available
-		if recordIDs, exists := index[whereValue]; exists {
-			for _, id := range recordIDs {
-				result = append(result, table.data[id][attribute])
+	condition := []Condition{{Attribute: whereKey, Operator: "=", Value: whereValue}}
+	keys, indexed := table.indexedCandidates(condition, "AND")
+	if indexed { // Use index if available, possibly a composite one starting with whereKey
+		table.stats.indexHits.Add(1)
+		for _, id := range keys {
+			if record := table.data[id]; valuesEqual(record[whereKey], whereValue) {
+				result = append(result, record[attribute])
 			}
 		}
 	} else { // Fallback: scan all records
+		table.stats.indexMisses.Add(1)
+		table.stats.fullScans.Add(1)
+		scanned := 0
 		for _, record := range table.data {
-			if record[whereKey] == whereValue {
+			if scanned++; scanned%scanCheckInterval == 0 {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+			}
+			if valuesEqual(record[whereKey], whereValue) {
 				result = append(result, record[attribute])
 			}
 		}
@@ -101,60 +122,107 @@ func (db *InMemoryDB) Select(tableName, attribute, whereKey string, whereValue i
 	return result, nil
 }
 
-func (db *InMemoryDB) Get(tableName string, id string) (Record, error) {
-	db.dbLock.RLock()
+// Get returns the record stored under id together with its version.
+func (db *InMemoryDB) Get(tableName string, id string) (Record, uint64, error) {
+	return db.GetContext(context.Background(), tableName, id)
+}
 
-	table, exists := db.tables[tableName]
-	db.dbLock.RUnlock()
-	if !exists {
-		return nil, fmt.Errorf("table %s does not exist", tableName)
+func (db *InMemoryDB) GetContext(ctx context.Context, tableName string, id string) (Record, uint64, error) {
+	defer db.observe("get", time.Now())
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	table, err := db.getTable(tableName)
+	if err != nil {
+		return nil, 0, err
 	}
-	table.dataLock.RLock()
+	table.rlockData()
 	record, found := table.data[id]
+	version := table.versions[id]
 	table.dataLock.RUnlock()
 	if !found {
-		return nil, fmt.Errorf("record with ID %s not found", id)
+		return nil, 0, recordNotFound(tableName, id)
 	}
-	return record, nil
+	return record, version, nil
 }
 
 func (db *InMemoryDB) Delete(tableName string, id string) error {
-	db.dbLock.RLock()
+	return db.DeleteContext(context.Background(), tableName, id)
+}
 
-	table, exists := db.tables[tableName]
-	db.dbLock.RUnlock()
-	if !exists {
-		return fmt.Errorf("table %s does not exist", tableName)
-	}
-	table.dataLock.Lock()
-	delete(table.data, id)
-	table.dataLock.Unlock()
+func (db *InMemoryDB) DeleteContext(ctx context.Context, tableName string, id string) error {
+	defer db.observe("delete", time.Now())
+	return db.write(ctx, tableName, func(s *writeScope, table *Table) error {
+		return s.delete(table, id)
+	})
+}
 
-	return nil
+// CreateIndex indexes the rows of a table by one or more columns. Queries
+// with equality conditions on a prefix of the columns, optionally followed by
+// a range condition on the next column, are answered through the index.
+func (db *InMemoryDB) CreateIndex(tableName string, columns ...string) error {
+	return db.CreateIndexContext(context.Background(), tableName, columns...)
 }
 
-func (db *InMemoryDB) CreateIndex(tableName, column string) error {
-	db.dbLock.RLock()
-	table, exists := db.tables[tableName]
-	db.dbLock.RUnlock()
-	if !exists {
-		return fmt.Errorf("table %s does not exist", tableName)
-	}
+func (db *InMemoryDB) CreateIndexContext(ctx context.Context, tableName string, columns ...string) error {
+	defer db.observe("create_index", time.Now())
+	return db.createIndex(ctx, tableName, columns, false)
+}
+
+// CreateUniqueIndex creates an index that also rejects any write giving two
+// rows the same values in its columns with a *UniqueViolationError. Rows with
+// a nil value in one of the columns are exempt. It fails the same way if the
+// table already holds duplicates.
+func (db *InMemoryDB) CreateUniqueIndex(tableName string, columns ...string) error {
+	return db.CreateUniqueIndexContext(context.Background(), tableName, columns...)
+}
+
+func (db *InMemoryDB) CreateUniqueIndexContext(ctx context.Context, tableName string, columns ...string) error {
+	defer db.observe("create_unique_index", time.Now())
+	return db.createIndex(ctx, tableName, columns, true)
+}
 
-	table.indexLock.RLock()
-	_, exists = table.indexes[column]
-	table.indexLock.RUnlock()
-	if exists {
-		return fmt.Errorf("index on column %s already exists", column)
+func (db *InMemoryDB) createIndex(ctx context.Context, tableName string, columns []string, unique bool) error {
+	table, err := db.getTable(tableName)
+	if err != nil {
+		return err
 	}
+
+	// Hold the data lock so that no insert interleaves with the index build.
+	table.rlockData()
+	defer table.dataLock.RUnlock()
 	table.indexLock.Lock()
 	defer table.indexLock.Unlock()
-	table.indexes[column] = make(map[interface{}][]string)
+	if err := checkIndexColumns(table, columns); err != nil {
+		return err
+	}
+	if unique && table.view != nil {
+		return fmt.Errorf("%w: cannot create a unique index on %s", ErrReadOnlyTable, tableName)
+	}
+	name := indexName(columns)
+	if _, exists := table.indexes[name]; exists {
+		return fmt.Errorf("%w: %s(%s)", ErrIndexExists, tableName, name)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	columns = append([]string(nil), columns...)
+	index := newIndex(columns, unique)
+	scanned := 0
 	for id, record := range table.data {
-		value := record[column]
-		table.indexes[column][value] = append(table.indexes[column][value], id)
+		if scanned++; scanned%scanCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		if existing, conflict := index.conflict(record, id); conflict {
+			return &UniqueViolationError{Table: tableName, Columns: columns, Key: id, ExistingKey: existing}
+		}
+		index.add(record, id)
 	}
+	table.indexes[name] = index
 
+	db.emit(Mutation{Type: MutationCreateIndex, Table: tableName, Columns: columns, Unique: unique})
 	return nil
 }
 
@@ -173,61 +241,140 @@ func (db *InMemoryDB) SelectWithConditions(
 	conditions []Condition,
 	logicalOperator string,
 ) ([]map[string]interface{}, error) {
-	db.dbLock.RLock()
-	table, exists := db.tables[tableName]
-	db.dbLock.RUnlock()
-	if !exists {
-		return nil, fmt.Errorf("table %s does not exist", tableName)
+	return db.SelectWithConditionsContext(context.Background(), tableName, selectAttributes, conditions, logicalOperator)
+}
+
+func (db *InMemoryDB) SelectWithConditionsContext(
+	ctx context.Context,
+	tableName string,
+	selectAttributes []string,
+	conditions []Condition,
+	logicalOperator string,
+) ([]map[string]interface{}, error) {
+	defer db.observe("select_with_conditions", time.Now())
+	table, err := db.getTable(tableName)
+	if err != nil {
+		return nil, err
 	}
 
-	table.dataLock.RLock()
+	cache := db.cache.Load()
+	key, cacheable := queryKey(tableName, selectAttributes, conditions, logicalOperator)
+	cacheable = cacheable && cache != nil
+	if cacheable {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if rows, hit := cache.get(key, table); hit {
+			return rows, nil
+		}
+	}
+
+	table.rlockData()
 	defer table.dataLock.RUnlock()
 
 	var result []map[string]interface{}
 
-	// Iterate over all records in the table
-	for _, record := range table.data {
-		if evaluateConditions(record, conditions, logicalOperator) {
-			// Prepare the selected attributes for the result
-			selectedRecord := make(map[string]interface{})
-			for _, attr := range selectAttributes {
-				if value, exists := record[attr]; exists {
-					selectedRecord[attr] = value
-				}
+	// Iterate over the matching records in the table
+	err = table.scan(ctx, conditions, logicalOperator, func(_ string, record Record) error {
+		// Prepare the selected attributes for the result
+		selectedRecord := make(map[string]interface{})
+		for _, attr := range selectAttributes {
+			if value, exists := record[attr]; exists {
+				selectedRecord[attr] = value
 			}
-			result = append(result, selectedRecord)
 		}
+		result = append(result, selectedRecord)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
+	if cacheable {
+		cache.put(key, table, table.generation.Load(), result)
+	}
 	return result, nil
 }
 
-func evaluateConditions(record Record, conditions []Condition, logicalOperator string) bool {
+func (db *InMemoryDB) SelectExpressions(
+	tableName string,
+	projections []Projection,
+	conditions []Condition,
+	logicalOperator string,
+) ([]map[string]interface{}, error) {
+	return db.SelectExpressionsContext(context.Background(), tableName, projections, conditions, logicalOperator)
+}
+
+func (db *InMemoryDB) SelectExpressionsContext(
+	ctx context.Context,
+	tableName string,
+	projections []Projection,
+	conditions []Condition,
+	logicalOperator string,
+) ([]map[string]interface{}, error) {
+	defer db.observe("select_expressions", time.Now())
+	table, err := db.getTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	table.rlockData()
+	defer table.dataLock.RUnlock()
+
+	var result []map[string]interface{}
+	err = table.scan(ctx, conditions, logicalOperator, func(_ string, record Record) error {
+		selectedRecord := make(map[string]interface{}, len(projections))
+		for _, projection := range projections {
+			value, err := projection.Expr.Eval(record)
+			if err != nil {
+				return err
+			}
+			selectedRecord[projection.name()] = value
+		}
+		result = append(result, selectedRecord)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func evaluateConditions(record Record, conditions []Condition, logicalOperator string) (bool, error) {
 	results := make([]bool, len(conditions))
 
 	for i, condition := range conditions {
-		value, exists := record[condition.Attribute]
-		if !exists {
+		var value interface{}
+		if condition.Expr != nil {
+			var err error
+			if value, err = condition.Expr.Eval(record); err != nil {
+				return false, err
+			}
+		} else {
+			value = record[condition.Attribute]
+		}
+		if value == nil {
 			results[i] = false
 			continue
 		}
 
 		switch condition.Operator {
 		case "=":
-			results[i] = value == condition.Value
+			results[i] = valuesEqual(value, condition.Value)
 		case "!=":
-			results[i] = value != condition.Value
+			results[i] = !valuesEqual(value, condition.Value)
 		case "<":
-			results[i] = compareNumeric(value, condition.Value, func(a, b float64) bool { return a < b })
+			results[i] = compareWith(value, condition.Value, func(c int) bool { return c < 0 })
 		case ">":
-			results[i] = compareNumeric(value, condition.Value, func(a, b float64) bool { return a > b })
+			results[i] = compareWith(value, condition.Value, func(c int) bool { return c > 0 })
 		case "<=":
-			results[i] = compareNumeric(value, condition.Value, func(a, b float64) bool { return a <= b })
+			results[i] = compareWith(value, condition.Value, func(c int) bool { return c <= 0 })
 		case ">=":
-			results[i] = compareNumeric(value, condition.Value, func(a, b float64) bool { return a >= b })
+			results[i] = compareWith(value, condition.Value, func(c int) bool { return c >= 0 })
 		case "BETWEEN":
-			results[i] = compareNumeric(value, condition.Value, func(a, b float64) bool { return a >= b }) &&
-				compareNumeric(value, condition.SecondValue, func(a, b float64) bool { return a <= b })
+			results[i] = compareWith(value, condition.Value, func(c int) bool { return c >= 0 }) &&
+				compareWith(value, condition.SecondValue, func(c int) bool { return c <= 0 })
 		default:
 			results[i] = false
 		}
@@ -236,18 +383,18 @@ func evaluateConditions(record Record, conditions []Condition, logicalOperator s
 	if logicalOperator == "AND" {
 		for _, res := range results {
 			if !res {
-				return false
+				return false, nil
 			}
 		}
-		return true
+		return true, nil
 	} else if logicalOperator == "OR" {
 		for _, res := range results {
 			if res {
-				return true
+				return true, nil
 			}
 		}
-		return false
+		return false, nil
 	}
 
-	return false
+	return false, nil
 }
@@ -0,0 +1,194 @@
+package inmemorydb
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the histogram buckets used for
+// operation latencies and lock waits.
+var latencyBuckets = [...]time.Duration{
+	time.Microsecond, 5 * time.Microsecond, 10 * time.Microsecond, 50 * time.Microsecond,
+	100 * time.Microsecond, 500 * time.Microsecond, time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 500 * time.Millisecond,
+	time.Second,
+}
+
+// histogram counts durations into latencyBuckets. The zero value is ready to
+// use and safe for concurrent use.
+type histogram struct {
+	counts [len(latencyBuckets) + 1]atomic.Uint64 // One per bucket plus +Inf
+	sum    atomic.Int64                           // Nanoseconds
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := sort.Search(len(latencyBuckets), func(i int) bool { return d <= latencyBuckets[i] })
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+func (h *histogram) snapshot() Histogram {
+	snapshot := Histogram{Buckets: latencyBuckets[:], Counts: make([]uint64, len(latencyBuckets)), Sum: time.Duration(h.sum.Load())}
+	for i := range h.counts {
+		snapshot.Count += h.counts[i].Load()
+		if i < len(latencyBuckets) {
+			snapshot.Counts[i] = snapshot.Count
+		}
+	}
+	return snapshot
+}
+
+// Histogram is a point-in-time copy of a latency histogram. Counts[i] is the
+// number of observations of at most Buckets[i], like a Prometheus bucket.
+type Histogram struct {
+	Buckets []time.Duration
+	Counts  []uint64
+	Count   uint64
+	Sum     time.Duration
+}
+
+// tableStats are the counters kept for every table.
+type tableStats struct {
+	indexHits   atomic.Uint64
+	indexMisses atomic.Uint64
+	fullScans   atomic.Uint64
+	lockWait    histogram
+}
+
+// operationStats keeps a latency histogram per database operation.
+type operationStats struct {
+	histograms map[string]*histogram
+	lock       sync.RWMutex
+}
+
+func (o *operationStats) histogram(operation string) *histogram {
+	o.lock.RLock()
+	h, exists := o.histograms[operation]
+	o.lock.RUnlock()
+	if exists {
+		return h
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if h, exists = o.histograms[operation]; !exists {
+		if o.histograms == nil {
+			o.histograms = make(map[string]*histogram)
+		}
+		h = &histogram{}
+		o.histograms[operation] = h
+	}
+	return h
+}
+
+// observe records the latency of an operation that started at start. It is
+// meant to be deferred.
+func (db *InMemoryDB) observe(operation string, start time.Time) {
+	db.operations.histogram(operation).observe(time.Since(start))
+}
+
+// lockData and rlockData take dataLock and record how long the caller had to
+// wait for it.
+func (t *Table) lockData() {
+	start := time.Now()
+	t.dataLock.Lock()
+	t.stats.lockWait.observe(time.Since(start))
+}
+
+func (t *Table) rlockData() {
+	start := time.Now()
+	t.dataLock.RLock()
+	t.stats.lockWait.observe(time.Since(start))
+}
+
+// Stats describes the contents of the database and how it has been used
+// since it was created.
+type Stats struct {
+	Tables     []TableStats     // Ordered by name
+	Operations []OperationStats // Ordered by operation
+	QueryCache QueryCacheStats
+}
+
+// TableStats describes a table. A query that is answered through an index
+// counts as an index hit. A query that filters on columns but finds no usable
+// index counts as an index miss and falls back to a full scan, as does every
+// query without conditions.
+type TableStats struct {
+	Name        string
+	Rows        int
+	Indexes     []IndexStats // Ordered by name
+	IndexHits   uint64
+	IndexMisses uint64
+	FullScans   uint64
+	LockWait    Histogram // Time spent waiting for the table's lock
+}
+
+// IndexStats describes an index. Name is its columns joined by commas, Keys
+// the number of distinct values, Rows the number of rows it references and
+// Hits the number of queries it served.
+type IndexStats struct {
+	Name   string
+	Unique bool
+	Keys   int
+	Rows   int
+	Hits   uint64
+}
+
+type OperationStats struct {
+	Operation string
+	Latency   Histogram
+}
+
+func (db *InMemoryDB) Stats() Stats {
+	db.dbLock.RLock()
+	tables := make([]*Table, 0, len(db.tables))
+	for _, table := range db.tables {
+		tables = append(tables, table)
+	}
+	db.dbLock.RUnlock()
+	sort.Slice(tables, func(i, j int) bool { return tables[i].name < tables[j].name })
+
+	var stats Stats
+	for _, table := range tables {
+		stats.Tables = append(stats.Tables, table.statistics())
+	}
+
+	db.operations.lock.RLock()
+	for operation, h := range db.operations.histograms {
+		stats.Operations = append(stats.Operations, OperationStats{Operation: operation, Latency: h.snapshot()})
+	}
+	db.operations.lock.RUnlock()
+	sort.Slice(stats.Operations, func(i, j int) bool { return stats.Operations[i].Operation < stats.Operations[j].Operation })
+
+	if cache := db.cache.Load(); cache != nil {
+		stats.QueryCache = cache.statistics()
+	}
+	return stats
+}
+
+func (t *Table) statistics() TableStats {
+	// Reading the stats is not counted as lock wait.
+	t.dataLock.RLock()
+	defer t.dataLock.RUnlock()
+	t.indexLock.RLock()
+	defer t.indexLock.RUnlock()
+
+	stats := TableStats{
+		Name:        t.name,
+		Rows:        len(t.data),
+		IndexHits:   t.stats.indexHits.Load(),
+		IndexMisses: t.stats.indexMisses.Load(),
+		FullScans:   t.stats.fullScans.Load(),
+		LockWait:    t.stats.lockWait.snapshot(),
+	}
+	for name, index := range t.indexes {
+		rows := 0
+		for _, keys := range index.entries {
+			rows += len(keys)
+		}
+		stats.Indexes = append(stats.Indexes, IndexStats{Name: name, Unique: index.unique, Keys: len(index.entries), Rows: rows, Hits: index.hits.Load()})
+	}
+	sort.Slice(stats.Indexes, func(i, j int) bool { return stats.Indexes[i].Name < stats.Indexes[j].Name })
+	return stats
+}
@@ -0,0 +1,142 @@
+package inmemorydb
+
+import (
+	"context"
+	"fmt"
+)
+
+type MutationType string
+
+const (
+	MutationCreateTable MutationType = "CREATE_TABLE"
+	MutationInsert      MutationType = "INSERT"
+	MutationDelete      MutationType = "DELETE"
+	MutationCreateIndex MutationType = "CREATE_INDEX"
+	MutationCreateView  MutationType = "CREATE_VIEW"
+)
+
+// Mutation is a single committed change. Mutations of a table are emitted in
+// the order they were committed, so replaying them rebuilds the same state.
+type Mutation struct {
+	LSN     uint64 // Position in the replication log, assigned by the Leader
+	Type    MutationType
+	Table   string
+	Key     string
+	Record  Record
+	Version uint64 // Version the row was written with
+	Schema  map[string]string
+	Columns []string   // Columns of an index
+	Unique  bool       // Whether the index is unique
+	View    *ViewQuery // Definition of a materialized view
+}
+
+type mutationListener func(Mutation)
+
+// subscribe registers a listener that is called for every committed mutation.
+// Listeners run while the writer still holds the lock of the changed table.
+func (db *InMemoryDB) subscribe(listener mutationListener) func() {
+	db.listenerLock.Lock()
+	defer db.listenerLock.Unlock()
+	id := db.nextListenerID
+	db.nextListenerID++
+	db.listeners[id] = listener
+	return func() {
+		db.listenerLock.Lock()
+		defer db.listenerLock.Unlock()
+		delete(db.listeners, id)
+	}
+}
+
+// snapshotAndSubscribe returns the current contents of the database as a
+// list of mutations and registers listener for everything committed after it.
+// No write can slip in between the snapshot and the subscription.
+func (db *InMemoryDB) snapshotAndSubscribe(listener mutationListener) ([]Mutation, func()) {
+	var unsubscribe func()
+	snapshot := db.snapshot(func() { unsubscribe = db.subscribe(listener) })
+	return snapshot, unsubscribe
+}
+
+// snapshot returns the current contents of the database as a list of
+// mutations. during runs while no write can commit, so whatever it observes
+// is as of the snapshot.
+func (db *InMemoryDB) snapshot(during func()) []Mutation {
+	// Locking several tables at once is only safe while holding scopeLock.
+	db.scopeLock.Lock()
+	defer db.scopeLock.Unlock()
+	db.dbLock.RLock()
+	defer db.dbLock.RUnlock()
+
+	var snapshot, views []Mutation
+	for name, table := range db.tables {
+		table.rlockData()
+		defer table.dataLock.RUnlock()
+		table.indexLock.RLock()
+		defer table.indexLock.RUnlock()
+
+		// Views are recomputed by the follower once their sources exist.
+		if table.view != nil {
+			query := table.view.query
+			views = append(views, Mutation{Type: MutationCreateView, Table: name, View: &query})
+			for _, index := range table.indexes {
+				views = append(views, Mutation{Type: MutationCreateIndex, Table: name, Columns: index.columns})
+			}
+			continue
+		}
+
+		snapshot = append(snapshot, Mutation{Type: MutationCreateTable, Table: name, Schema: cloneSchema(table.schema)})
+		for key, record := range table.data {
+			snapshot = append(snapshot, Mutation{Type: MutationInsert, Table: name, Key: key, Record: cloneRecord(record), Version: table.versions[key]})
+		}
+		for _, index := range table.indexes {
+			snapshot = append(snapshot, Mutation{Type: MutationCreateIndex, Table: name, Columns: index.columns, Unique: index.unique})
+		}
+	}
+	during()
+	return append(snapshot, views...)
+}
+
+func (db *InMemoryDB) emit(mutation Mutation) {
+	db.listenerLock.RLock()
+	defer db.listenerLock.RUnlock()
+	for _, listener := range db.listeners {
+		listener(mutation)
+	}
+}
+
+// ApplyMutation replays a mutation received from another node.
+func (db *InMemoryDB) ApplyMutation(mutation Mutation) error {
+	switch mutation.Type {
+	case MutationCreateTable:
+		return db.CreateTable(mutation.Table, mutation.Schema)
+	case MutationInsert:
+		return db.applyInsert(mutation.Table, mutation.Key, mutation.Record, mutation.Version)
+	case MutationDelete:
+		return db.applyDelete(mutation.Table, mutation.Key)
+	case MutationCreateIndex:
+		if mutation.Unique {
+			return db.CreateUniqueIndex(mutation.Table, mutation.Columns...)
+		}
+		return db.CreateIndex(mutation.Table, mutation.Columns...)
+	case MutationCreateView:
+		return db.CreateMaterializedView(mutation.Table, *mutation.View)
+	default:
+		return fmt.Errorf("unknown mutation type %s", mutation.Type)
+	}
+}
+
+// applyInsert writes a replicated row with the version it had on the leader.
+// Triggers already ran on the leader and their writes are replicated too.
+func (db *InMemoryDB) applyInsert(tableName string, key string, record Record, version uint64) error {
+	return db.write(context.Background(), tableName, func(s *writeScope, table *Table) error {
+		s.replay = true
+		_, err := s.insert(table, key, record, version)
+		return err
+	})
+}
+
+func (db *InMemoryDB) applyDelete(tableName string, key string) error {
+	return db.write(context.Background(), tableName, func(s *writeScope, table *Table) error {
+		s.replay = true
+		return s.delete(table, key)
+	})
+}
@@ -0,0 +1,117 @@
+package inmemorydb
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WritePrometheus writes stats in the Prometheus text exposition format.
+func WritePrometheus(w io.Writer, stats Stats) error {
+	out := bufio.NewWriter(w)
+
+	writeHeader(out, "imdb_table_rows", "gauge", "Number of rows stored in the table.")
+	for _, table := range stats.Tables {
+		fmt.Fprintf(out, "imdb_table_rows%s %d\n", labels("table", table.Name), table.Rows)
+	}
+	writeHeader(out, "imdb_index_keys", "gauge", "Number of distinct values in the index.")
+	for _, table := range stats.Tables {
+		for _, index := range table.Indexes {
+			fmt.Fprintf(out, "imdb_index_keys%s %d\n", labels("table", table.Name, "index", index.Name), index.Keys)
+		}
+	}
+	writeHeader(out, "imdb_index_rows", "gauge", "Number of rows referenced by the index.")
+	for _, table := range stats.Tables {
+		for _, index := range table.Indexes {
+			fmt.Fprintf(out, "imdb_index_rows%s %d\n", labels("table", table.Name, "index", index.Name), index.Rows)
+		}
+	}
+	writeHeader(out, "imdb_index_hits_total", "counter", "Queries answered through the index.")
+	for _, table := range stats.Tables {
+		for _, index := range table.Indexes {
+			fmt.Fprintf(out, "imdb_index_hits_total%s %d\n", labels("table", table.Name, "index", index.Name), index.Hits)
+		}
+	}
+	writeHeader(out, "imdb_index_misses_total", "counter", "Filtered queries on the table that found no usable index.")
+	for _, table := range stats.Tables {
+		fmt.Fprintf(out, "imdb_index_misses_total%s %d\n", labels("table", table.Name), table.IndexMisses)
+	}
+	writeHeader(out, "imdb_full_scans_total", "counter", "Queries that visited every row of the table.")
+	for _, table := range stats.Tables {
+		fmt.Fprintf(out, "imdb_full_scans_total%s %d\n", labels("table", table.Name), table.FullScans)
+	}
+	writeHeader(out, "imdb_operation_duration_seconds", "histogram", "Latency of database operations.")
+	for _, operation := range stats.Operations {
+		writeHistogram(out, "imdb_operation_duration_seconds", "operation", operation.Operation, operation.Latency)
+	}
+	writeHeader(out, "imdb_lock_wait_seconds", "histogram", "Time spent waiting for the lock of the table.")
+	for _, table := range stats.Tables {
+		writeHistogram(out, "imdb_lock_wait_seconds", "table", table.Name, table.LockWait)
+	}
+	if cache := stats.QueryCache; cache.Enabled {
+		for _, metric := range []struct {
+			name, kind, help string
+			value            uint64
+		}{
+			{"imdb_query_cache_hits_total", "counter", "Queries answered from the query cache.", cache.Hits},
+			{"imdb_query_cache_misses_total", "counter", "Cacheable queries that had to be run.", cache.Misses},
+			{"imdb_query_cache_evictions_total", "counter", "Entries evicted to stay within the memory bound.", cache.Evictions},
+			{"imdb_query_cache_invalidations_total", "counter", "Entries dropped because their table changed.", cache.Invalidations},
+			{"imdb_query_cache_entries", "gauge", "Results held by the query cache.", uint64(cache.Entries)},
+			{"imdb_query_cache_bytes", "gauge", "Approximate size of the results held by the query cache.", uint64(cache.Bytes)},
+		} {
+			writeHeader(out, metric.name, metric.kind, metric.help)
+			fmt.Fprintf(out, "%s %d\n", metric.name, metric.value)
+		}
+	}
+	return out.Flush()
+}
+
+// MetricsHandler serves the stats of db to a Prometheus scraper. The metrics
+// are rendered before anything is sent, so a failure is reported as a 500
+// instead of a truncated page.
+func MetricsHandler(db Database) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body bytes.Buffer
+		if err := WritePrometheus(&body, db.Stats()); err != nil {
+			http.Error(w, fmt.Sprintf("rendering metrics: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(body.Bytes())
+	})
+}
+
+func writeHeader(out io.Writer, name, kind, help string) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeHistogram(out io.Writer, name, label, value string, h Histogram) {
+	for i, bound := range h.Buckets {
+		le := strconv.FormatFloat(bound.Seconds(), 'g', -1, 64)
+		fmt.Fprintf(out, "%s_bucket%s %d\n", name, labels(label, value, "le", le), h.Counts[i])
+	}
+	fmt.Fprintf(out, "%s_bucket%s %d\n", name, labels(label, value, "le", "+Inf"), h.Count)
+	fmt.Fprintf(out, "%s_sum%s %s\n", name, labels(label, value), strconv.FormatFloat(h.Sum.Seconds(), 'g', -1, 64))
+	fmt.Fprintf(out, "%s_count%s %d\n", name, labels(label, value), h.Count)
+}
+
+// labels formats name/value pairs as a Prometheus label set.
+func labels(pairs ...string) string {
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, `%s="%s"`, pairs[i], labelEscaper.Replace(pairs[i+1]))
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
@@ -0,0 +1,45 @@
+package inmemorydb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	db := NewInMemoryDB()
+	mustSucceed(t, db.CreateTable("users", map[string]string{"name": "string"}))
+	mustSucceed(t, db.Insert("users", "1", Record{"name": "Alice"}))
+	mustSucceed(t, db.Insert("users", "2", Record{"name": "Bob"}))
+	mustSucceed(t, db.CreateIndex("users", "name"))
+	if _, err := db.Select("users", "name", "name", "Alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	MetricsHandler(db).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", recorder.Code, http.StatusOK)
+	}
+	if got := recorder.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	tests := []struct {
+		name string
+		line string
+	}{
+		{name: "row count", line: `imdb_table_rows{table="users"} 2`},
+		{name: "index hits", line: `imdb_index_hits_total{table="users",index="name"} 1`},
+		{name: "metric type", line: "# TYPE imdb_table_rows gauge"},
+	}
+	body := recorder.Body.String()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !strings.Contains(body, tt.line+"\n") {
+				t.Errorf("metrics do not contain %q:\n%s", tt.line, body)
+			}
+		})
+	}
+}
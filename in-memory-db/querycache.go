@@ -0,0 +1,214 @@
+package inmemorydb
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// queryCache keeps the results of SelectWithConditions calls. An entry
+// remembers the generation of its table when it was filled and is only served
+// while the table is still at that generation, so any committed change to
+// the table's rows invalidates it. Entries are evicted least recently used
+// first once the approximate size of all results exceeds maxBytes.
+type queryCache struct {
+	maxBytes int
+	bytes    int
+	entries  map[string]*list.Element       // Normalized query -> Entry in lru
+	byTable  map[*Table]map[string]struct{} // Queries cached per table
+	lru      *list.List                     // Of *cachedQuery, most recently used first
+	stats    QueryCacheStats
+	lock     sync.Mutex
+}
+
+type cachedQuery struct {
+	key        string
+	table      *Table
+	generation uint64
+	rows       []map[string]interface{}
+	size       int
+}
+
+// QueryCacheStats describes the query cache since it was enabled.
+type QueryCacheStats struct {
+	Enabled       bool
+	Hits          uint64
+	Misses        uint64
+	Evictions     uint64 // Entries dropped to stay within the memory bound
+	Invalidations uint64 // Entries dropped because their table changed
+	Entries       int
+	Bytes         int // Approximate size of the cached results
+	MaxBytes      int
+}
+
+// HitRate is the fraction of cacheable queries answered from the cache.
+func (s QueryCacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// EnableQueryCache caches the results of SelectWithConditions on db, keeping
+// at most about maxBytes of results. Queries are matched after normalizing
+// the order of attributes and conditions. Conditions on expressions are never
+// cached. A maxBytes of zero or less turns the cache off again.
+func EnableQueryCache(db Database, maxBytes int) error {
+	target, ok := db.(*InMemoryDB)
+	if !ok {
+		return fmt.Errorf("query cache is not supported for %T", db)
+	}
+	if maxBytes <= 0 {
+		target.cache.Store(nil)
+		return nil
+	}
+	target.cache.Store(&queryCache{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		byTable:  make(map[*Table]map[string]struct{}),
+		lru:      list.New(),
+	})
+	return nil
+}
+
+// queryKey normalizes a query. ok is false when the query cannot be cached.
+func queryKey(tableName string, selectAttributes []string, conditions []Condition, logicalOperator string) (string, bool) {
+	attributes := append([]string(nil), selectAttributes...)
+	sort.Strings(attributes)
+
+	parts := make([]string, 0, len(conditions))
+	for _, condition := range conditions {
+		if condition.Expr != nil {
+			return "", false
+		}
+		parts = append(parts, fmt.Sprintf("%q %s %s %s", condition.Attribute, strings.ToUpper(condition.Operator),
+			normalizedValue(condition.Value), normalizedValue(condition.SecondValue)))
+	}
+	// AND and OR do not depend on the order of their operands.
+	sort.Strings(parts)
+
+	return fmt.Sprintf("%q|%q|%s|%s", tableName, attributes, strings.ToUpper(logicalOperator), strings.Join(parts, "|")), true
+}
+
+func normalizedValue(value interface{}) string {
+	if t, ok := value.(time.Time); ok {
+		return "time.Time:" + t.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprintf("%T:%#v", value, value)
+}
+
+// get returns a copy of the cached result of key if table has not changed
+// since it was cached.
+func (c *queryCache) get(key string, table *Table) ([]map[string]interface{}, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	element, exists := c.entries[key]
+	if !exists {
+		c.stats.Misses++
+		return nil, false
+	}
+	entry := element.Value.(*cachedQuery)
+	if entry.table != table || entry.generation != table.generation.Load() {
+		c.remove(element)
+		c.stats.Invalidations++
+		c.stats.Misses++
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	c.stats.Hits++
+	return cloneRows(entry.rows), true
+}
+
+// put caches a copy of rows, the result of key at the given generation of
+// table.
+func (c *queryCache) put(key string, table *Table, generation uint64, rows []map[string]interface{}) {
+	size := len(key) + approximateSize(rows)
+	if size > c.maxBytes {
+		return
+	}
+	entry := &cachedQuery{key: key, table: table, generation: generation, rows: cloneRows(rows), size: size}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if element, exists := c.entries[key]; exists {
+		c.remove(element)
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	if c.byTable[table] == nil {
+		c.byTable[table] = make(map[string]struct{})
+	}
+	c.byTable[table][key] = struct{}{}
+	c.bytes += size
+	for c.bytes > c.maxBytes {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// invalidate drops the entries of table that are out of date, so they do
+// not take up memory until they are evicted.
+func (c *queryCache) invalidate(table *Table) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	generation := table.generation.Load()
+	for key := range c.byTable[table] {
+		element := c.entries[key]
+		if element.Value.(*cachedQuery).generation != generation {
+			c.remove(element)
+			c.stats.Invalidations++
+		}
+	}
+}
+
+// remove drops an entry. The caller must hold lock.
+func (c *queryCache) remove(element *list.Element) {
+	entry := c.lru.Remove(element).(*cachedQuery)
+	delete(c.entries, entry.key)
+	delete(c.byTable[entry.table], entry.key)
+	if len(c.byTable[entry.table]) == 0 {
+		delete(c.byTable, entry.table)
+	}
+	c.bytes -= entry.size
+}
+
+func (c *queryCache) statistics() QueryCacheStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	stats := c.stats
+	stats.Enabled = true
+	stats.Entries = len(c.entries)
+	stats.Bytes = c.bytes
+	stats.MaxBytes = c.maxBytes
+	return stats
+}
+
+func cloneRows(rows []map[string]interface{}) []map[string]interface{} {
+	if rows == nil {
+		return nil
+	}
+	clone := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		clone[i] = cloneRecord(row)
+	}
+	return clone
+}
+
+// approximateSize estimates the memory held by rows in bytes.
+func approximateSize(rows []map[string]interface{}) int {
+	size := 24
+	for _, row := range rows {
+		size += 48
+		for column, value := range row {
+			size += 32 + len(column)
+			if s, ok := value.(string); ok {
+				size += len(s)
+			} else if _, ok := value.(time.Time); ok {
+				size += 24
+			}
+		}
+	}
+	return size
+}
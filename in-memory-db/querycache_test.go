@@ -0,0 +1,156 @@
+package inmemorydb
+
+import (
+	"testing"
+)
+
+func TestQueryCache(t *testing.T) {
+	paris := []Condition{{Attribute: "city", Operator: "=", Value: "Paris"}, {Attribute: "age", Operator: ">", Value: 20}}
+	query := func(db Database, conditions []Condition) int {
+		t.Helper()
+		rows, err := db.SelectWithConditions("users", []string{"name", "age"}, conditions, "AND")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(rows)
+	}
+
+	tests := []struct {
+		name  string
+		then  func(db Database) int // Runs after the Paris query was cached and returns the rows it finds
+		rows  int
+		hit   bool
+		stale bool // The cached entry was invalidated
+	}{
+		{
+			name: "same query",
+			then: func(db Database) int { return query(db, paris) },
+			rows: 2,
+			hit:  true,
+		},
+		{
+			name: "conditions in another order",
+			then: func(db Database) int { return query(db, []Condition{paris[1], paris[0]}) },
+			rows: 2,
+			hit:  true,
+		},
+		{
+			name: "value of another type",
+			then: func(db Database) int {
+				return query(db, []Condition{paris[0], {Attribute: "age", Operator: ">", Value: 20.0}})
+			},
+			rows: 2,
+		},
+		{
+			name: "after an insert",
+			then: func(db Database) int {
+				mustSucceed(t, db.Insert("users", "4", Record{"name": "Dave", "city": "Paris", "age": 50}))
+				return query(db, paris)
+			},
+			rows:  3,
+			stale: true,
+		},
+		{
+			name: "after a delete",
+			then: func(db Database) int {
+				mustSucceed(t, db.Delete("users", "1"))
+				return query(db, paris)
+			},
+			rows:  1,
+			stale: true,
+		},
+		{
+			name: "after a write to another table",
+			then: func(db Database) int {
+				mustSucceed(t, db.Insert("cities", "Paris", Record{"country": "France"}))
+				return query(db, paris)
+			},
+			rows: 2,
+			hit:  true,
+		},
+		{
+			name: "after a rolled back write",
+			then: func(db Database) int {
+				if err := db.Insert("users", "4", Record{"name": "Dave"}); err == nil {
+					t.Fatal("Insert() of an incomplete row succeeded")
+				}
+				return query(db, paris)
+			},
+			rows: 2,
+			hit:  true,
+		},
+		{
+			name: "expression condition",
+			then: func(db Database) int {
+				return query(db, []Condition{paris[0], {Expr: Col("age"), Operator: ">", Value: 20}})
+			},
+			rows: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newCacheFixture(t, 1<<20)
+			query(db, paris)
+			before := db.Stats().QueryCache
+
+			if got := tt.then(db); got != tt.rows {
+				t.Errorf("rows = %d, want %d", got, tt.rows)
+			}
+			after := db.Stats().QueryCache
+			if hit := after.Hits > before.Hits; hit != tt.hit {
+				t.Errorf("served from the cache: %t, want %t", hit, tt.hit)
+			}
+			if stale := after.Invalidations > before.Invalidations; stale != tt.stale {
+				t.Errorf("invalidated: %t, want %t", stale, tt.stale)
+			}
+		})
+	}
+}
+
+func TestQueryCacheBounds(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxBytes  int
+		entries   int
+		evictions uint64
+	}{
+		{name: "room for both queries", maxBytes: 1 << 20, entries: 2},
+		{name: "room for one query", maxBytes: 500, entries: 1, evictions: 1},
+		{name: "disabled", maxBytes: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newCacheFixture(t, tt.maxBytes)
+			for _, city := range []string{"Paris", "Rome"} {
+				conditions := []Condition{{Attribute: "city", Operator: "=", Value: city}}
+				if _, err := db.SelectWithConditions("users", []string{"name", "city", "age"}, conditions, "AND"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			stats := db.Stats().QueryCache
+			if stats.Enabled != (tt.maxBytes > 0) || stats.Entries != tt.entries || stats.Evictions != tt.evictions {
+				t.Errorf("stats = %+v, want %d entries after %d evictions", stats, tt.entries, tt.evictions)
+			}
+			if stats.Bytes > tt.maxBytes {
+				t.Errorf("cache holds %d bytes, more than its bound of %d", stats.Bytes, tt.maxBytes)
+			}
+		})
+	}
+}
+
+// newCacheFixture returns a database with a query cache of maxBytes, holding
+// three users, two of them in Paris, and an empty cities table.
+func newCacheFixture(t *testing.T, maxBytes int) *InMemoryDB {
+	t.Helper()
+	db := NewInMemoryDB().(*InMemoryDB)
+	mustSucceed(t, db.CreateTable("users", map[string]string{"name": "string", "city": "string", "age": "int"}))
+	mustSucceed(t, db.CreateTable("cities", map[string]string{"country": "string"}))
+	mustSucceed(t, db.Insert("users", "1", Record{"name": "Alice", "city": "Paris", "age": 30}))
+	mustSucceed(t, db.Insert("users", "2", Record{"name": "Bob", "city": "Paris", "age": 25}))
+	mustSucceed(t, db.Insert("users", "3", Record{"name": "Carol", "city": "Rome", "age": 40}))
+	mustSucceed(t, EnableQueryCache(db, maxBytes))
+	return db
+}
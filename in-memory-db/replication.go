@@ -0,0 +1,499 @@
+package inmemorydb
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	replicationBatchSize = 256
+	heartbeatInterval    = 100 * time.Millisecond
+)
+
+// replicationHello is sent by a follower right after it connects.
+type replicationHello struct {
+	FromLSN uint64 // Last LSN the follower has applied
+}
+
+// replicationAck is sent by a follower after it applied a frame.
+type replicationAck struct {
+	AppliedLSN uint64
+}
+
+// replicationFrame carries a batch of mutations from the leader. A frame
+// without mutations is a heartbeat that only advertises the leader's LSN.
+// A follower behind the log gets a frame with a Snapshot of the database as
+// of SnapshotLSN instead, and continues from there.
+type replicationFrame struct {
+	LeaderLSN   uint64
+	Mutations   []Mutation
+	Snapshot    []Mutation
+	SnapshotLSN uint64
+}
+
+// Leader ships every committed mutation of a database to its followers.
+// The log only keeps the mutations some connected follower has not
+// acknowledged yet; a follower that needs older ones, such as one that
+// connects later, starts from a snapshot. Followers must start from an empty
+// database.
+type Leader struct {
+	db       *InMemoryDB
+	listener net.Listener
+
+	log         []Mutation          // log[i] has LSN base+i+1
+	base        uint64              // LSN of the last mutation compacted away
+	acked       map[net.Conn]uint64 // Connected follower -> Last LSN it acknowledged
+	appended    chan struct{}       // Closed and replaced whenever the log grows
+	logLock     sync.Mutex
+	unsubscribe func()
+
+	closed    chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+func NewLeader(db Database, addr string) (*Leader, error) {
+	source, ok := db.(*InMemoryDB)
+	if !ok {
+		return nil, fmt.Errorf("replication is not supported for %T", db)
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	leader := &Leader{
+		db:       source,
+		listener: listener,
+		acked:    make(map[net.Conn]uint64),
+		appended: make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	snapshot, unsubscribe := source.snapshotAndSubscribe(leader.append)
+	leader.unsubscribe = unsubscribe
+	for _, mutation := range snapshot {
+		leader.append(mutation)
+	}
+
+	leader.wg.Add(1)
+	go leader.acceptLoop()
+	return leader, nil
+}
+
+// Addr returns the address followers should connect to.
+func (l *Leader) Addr() string {
+	return l.listener.Addr().String()
+}
+
+// LSN returns the sequence number of the last committed mutation.
+func (l *Leader) LSN() uint64 {
+	l.logLock.Lock()
+	defer l.logLock.Unlock()
+	return l.lsn()
+}
+
+// LogSize returns the number of mutations kept for followers.
+func (l *Leader) LogSize() int {
+	l.logLock.Lock()
+	defer l.logLock.Unlock()
+	return len(l.log)
+}
+
+// lsn is the sequence number of the last mutation. The caller must hold
+// logLock.
+func (l *Leader) lsn() uint64 {
+	return l.base + uint64(len(l.log))
+}
+
+func (l *Leader) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		l.unsubscribe()
+		close(l.closed)
+		err = l.listener.Close()
+		l.wg.Wait()
+	})
+	return err
+}
+
+func (l *Leader) append(mutation Mutation) {
+	l.logLock.Lock()
+	defer l.logLock.Unlock()
+	mutation.LSN = l.lsn() + 1
+	l.log = append(l.log, mutation)
+	if len(l.acked) == 0 {
+		l.compact()
+	}
+	close(l.appended)
+	l.appended = make(chan struct{})
+}
+
+// compact drops the mutations every connected follower has acknowledged, or
+// the whole log if no follower is connected. The caller must hold logLock.
+func (l *Leader) compact() {
+	upTo := l.lsn()
+	for _, acked := range l.acked {
+		upTo = min(upTo, acked)
+	}
+	if upTo <= l.base {
+		return
+	}
+	// Copy what is left so the dropped mutations can be freed
+	l.log = append([]Mutation(nil), l.log[upTo-l.base:]...)
+	l.base = upTo
+}
+
+// acknowledge records that the follower on conn has applied lsn.
+func (l *Leader) acknowledge(conn net.Conn, lsn uint64) {
+	l.logLock.Lock()
+	defer l.logLock.Unlock()
+	if _, connected := l.acked[conn]; connected && lsn > l.acked[conn] {
+		l.acked[conn] = lsn
+		l.compact()
+	}
+}
+
+// connect registers the follower on conn, which has applied lsn. If the log
+// no longer reaches back to lsn, it returns a snapshot of the database and
+// the LSN the follower continues from.
+func (l *Leader) connect(conn net.Conn, lsn uint64) ([]Mutation, uint64) {
+	l.logLock.Lock()
+	if lsn >= l.base {
+		l.acked[conn] = lsn
+		l.logLock.Unlock()
+		return nil, lsn
+	}
+	l.logLock.Unlock()
+
+	// No write commits while the snapshot is taken, so the log ends right at it
+	var snapshotLSN uint64
+	snapshot := l.db.snapshot(func() {
+		l.logLock.Lock()
+		defer l.logLock.Unlock()
+		snapshotLSN = l.lsn()
+		l.acked[conn] = snapshotLSN
+	})
+	return snapshot, snapshotLSN
+}
+
+func (l *Leader) disconnect(conn net.Conn) {
+	l.logLock.Lock()
+	defer l.logLock.Unlock()
+	delete(l.acked, conn)
+	l.compact()
+}
+
+// entriesAfter returns up to replicationBatchSize mutations following lsn, the
+// current leader LSN and a channel that is closed when more entries arrive.
+// lsn is never behind the log, as the follower reading it has not
+// acknowledged anything past lsn.
+func (l *Leader) entriesAfter(lsn uint64) ([]Mutation, uint64, <-chan struct{}) {
+	l.logLock.Lock()
+	defer l.logLock.Unlock()
+	end := l.lsn()
+	if lsn > end {
+		lsn = end
+	}
+	if end-lsn > replicationBatchSize {
+		end = lsn + replicationBatchSize
+	}
+	batch := make([]Mutation, end-lsn)
+	copy(batch, l.log[lsn-l.base:end-l.base])
+	return batch, l.lsn(), l.appended
+}
+
+func (l *Leader) acceptLoop() {
+	defer l.wg.Done()
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			return
+		}
+		l.wg.Add(1)
+		go l.serve(conn)
+	}
+}
+
+func (l *Leader) serve(conn net.Conn) {
+	defer l.wg.Done()
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-l.closed:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	decoder := gob.NewDecoder(conn)
+	var hello replicationHello
+	if err := decoder.Decode(&hello); err != nil {
+		return
+	}
+	snapshot, next := l.connect(conn, hello.FromLSN)
+	defer l.disconnect(conn)
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		for {
+			var ack replicationAck
+			if err := decoder.Decode(&ack); err != nil {
+				return
+			}
+			l.acknowledge(conn, ack.AppliedLSN)
+		}
+	}()
+
+	encoder := gob.NewEncoder(conn)
+	if snapshot != nil {
+		if err := encoder.Encode(replicationFrame{LeaderLSN: next, Snapshot: snapshot, SnapshotLSN: next}); err != nil {
+			return
+		}
+	}
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		batch, leaderLSN, appended := l.entriesAfter(next)
+		if err := encoder.Encode(replicationFrame{LeaderLSN: leaderLSN, Mutations: batch}); err != nil {
+			return
+		}
+		next += uint64(len(batch))
+		if next < leaderLSN {
+			continue
+		}
+		select {
+		case <-appended:
+		case <-heartbeat.C:
+		case <-l.closed:
+			return
+		}
+	}
+}
+
+// ReplicationLag describes how far a follower is behind its leader. Time is
+// how long the follower has been behind, zero when it is caught up.
+type ReplicationLag struct {
+	Entries uint64
+	Time    time.Duration
+}
+
+// Follower applies the mutations streamed by a Leader to a local database.
+// Until it is promoted the local database only serves reads.
+type Follower struct {
+	db      *InMemoryDB
+	conn    net.Conn
+	encoder *gob.Encoder // Sends the hello, then acknowledgements
+
+	applied    uint64
+	leaderLSN  uint64
+	caughtUpAt time.Time
+	promoted   bool
+	err        error
+	progress   chan struct{} // Closed and replaced after every frame
+	mu         sync.Mutex
+
+	done chan struct{}
+}
+
+func NewFollower(leaderAddr string) (*Follower, error) {
+	conn, err := net.Dial("tcp", leaderAddr)
+	if err != nil {
+		return nil, err
+	}
+	encoder := gob.NewEncoder(conn)
+	if err := encoder.Encode(replicationHello{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	follower := &Follower{
+		db:         NewInMemoryDB().(*InMemoryDB),
+		conn:       conn,
+		encoder:    encoder,
+		caughtUpAt: time.Now(),
+		progress:   make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go follower.replicate()
+	return follower, nil
+}
+
+// DB returns the follower's database. Writes fail with ErrReadOnly until the
+// follower is promoted.
+func (f *Follower) DB() Database {
+	return &guardedDB{db: f.db, check: f.checkWritable}
+}
+
+func (f *Follower) Lag() ReplicationLag {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.applied >= f.leaderLSN {
+		return ReplicationLag{}
+	}
+	return ReplicationLag{Entries: f.leaderLSN - f.applied, Time: time.Since(f.caughtUpAt)}
+}
+
+// AppliedLSN returns the LSN of the last mutation applied locally.
+func (f *Follower) AppliedLSN() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.applied
+}
+
+// Err returns the error that stopped replication, if any.
+func (f *Follower) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+// WaitForLSN blocks until the follower has applied lsn or the timeout expires.
+func (f *Follower) WaitForLSN(lsn uint64, timeout time.Duration) error {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		f.mu.Lock()
+		applied, progress, err := f.applied, f.progress, f.err
+		f.mu.Unlock()
+		if applied >= lsn {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		select {
+		case <-progress:
+		case <-f.done:
+		case <-deadline.C:
+			return fmt.Errorf("timed out waiting for LSN %d, applied %d", lsn, applied)
+		}
+	}
+}
+
+// Promote stops replication and makes the local database writable. It can
+// then be handed to NewLeader to serve followers of its own.
+func (f *Follower) Promote() (Database, error) {
+	f.mu.Lock()
+	if f.promoted {
+		f.mu.Unlock()
+		return nil, errors.New("follower is already promoted")
+	}
+	f.promoted = true
+	f.mu.Unlock()
+
+	f.conn.Close()
+	<-f.done
+	return f.db, nil
+}
+
+func (f *Follower) replicate() {
+	defer close(f.done)
+	decoder := gob.NewDecoder(f.conn)
+	for {
+		var frame replicationFrame
+		if err := decoder.Decode(&frame); err != nil {
+			f.stop(err)
+			return
+		}
+		if frame.Snapshot != nil {
+			if err := f.restart(frame.Snapshot, frame.SnapshotLSN); err != nil {
+				f.stop(err)
+				f.conn.Close()
+				return
+			}
+		}
+		for _, mutation := range frame.Mutations {
+			if err := f.apply(mutation); err != nil {
+				f.stop(err)
+				f.conn.Close()
+				return
+			}
+		}
+
+		f.mu.Lock()
+		f.leaderLSN = frame.LeaderLSN
+		if f.applied >= f.leaderLSN {
+			f.caughtUpAt = time.Now()
+		}
+		applied := f.applied
+		close(f.progress)
+		f.progress = make(chan struct{})
+		f.mu.Unlock()
+
+		// The leader keeps its log until every follower acknowledged it, so
+		// a follower that can no longer acknowledge stops rather than hold
+		// the log back.
+		if len(frame.Mutations) > 0 || frame.Snapshot != nil {
+			if err := f.encoder.Encode(replicationAck{AppliedLSN: applied}); err != nil {
+				f.stop(fmt.Errorf("acknowledging LSN %d: %w", applied, err))
+				f.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// restart loads a snapshot taken at lsn into the follower's database, which
+// must not have applied anything yet.
+func (f *Follower) restart(snapshot []Mutation, lsn uint64) error {
+	if applied := f.AppliedLSN(); applied != 0 {
+		return fmt.Errorf("replication snapshot at LSN %d after applying LSN %d", lsn, applied)
+	}
+	for _, mutation := range snapshot {
+		if err := f.db.ApplyMutation(mutation); err != nil {
+			return fmt.Errorf("applying snapshot: %w", err)
+		}
+	}
+	f.mu.Lock()
+	f.applied = lsn
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *Follower) apply(mutation Mutation) error {
+	f.mu.Lock()
+	expected := f.applied + 1
+	f.mu.Unlock()
+	if mutation.LSN != expected {
+		return fmt.Errorf("replication gap: expected LSN %d, got %d", expected, mutation.LSN)
+	}
+	if err := f.db.ApplyMutation(mutation); err != nil {
+		return fmt.Errorf("applying LSN %d: %w", mutation.LSN, err)
+	}
+
+	f.mu.Lock()
+	f.applied = mutation.LSN
+	f.mu.Unlock()
+	return nil
+}
+
+// stop records why replication ended. Closing the connection on promotion is
+// expected and not reported as an error.
+func (f *Follower) stop(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.promoted {
+		f.err = err
+	}
+}
+
+func (f *Follower) isPromoted() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.promoted
+}
+
+// checkWritable rejects everything but reads until the follower is promoted.
+func (f *Follower) checkWritable(_ string, required Permission) error {
+	if required > PermissionRead && !f.isPromoted() {
+		return ErrReadOnly
+	}
+	return nil
+}
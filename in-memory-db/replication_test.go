@@ -0,0 +1,386 @@
+package inmemorydb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLeaderCompactsAcknowledgedLog(t *testing.T) {
+	tests := []struct {
+		name   string
+		before int // Rows written before the follower connects
+		after  int // Rows written once it is connected
+	}{
+		{name: "follower from the start", after: 50},
+		{name: "follower joins after compaction", before: 50},
+		{name: "follower joins, then more writes", before: 50, after: 50},
+		{name: "more than one batch", before: 10, after: 3 * replicationBatchSize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewInMemoryDB()
+			mustSucceed(t, db.CreateTable("users", map[string]string{"name": "string"}))
+			leader, err := NewLeader(db, "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer leader.Close()
+
+			for i := 0; i < tt.before; i++ {
+				mustSucceed(t, db.Insert("users", fmt.Sprint(i), Record{"name": "before"}))
+			}
+			if size := leader.LogSize(); size != 0 {
+				t.Errorf("log without followers has %d mutations, want 0", size)
+			}
+
+			follower, err := NewFollower(leader.Addr())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tt.after; i++ {
+				mustSucceed(t, db.Insert("users", fmt.Sprint(tt.before+i), Record{"name": "after"}))
+			}
+			if err := follower.WaitForLSN(leader.LSN(), 5*time.Second); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tt.before+tt.after; i++ {
+				if _, _, err := follower.DB().Get("users", fmt.Sprint(i)); err != nil {
+					t.Fatalf("follower is missing row %d: %v", i, err)
+				}
+			}
+			eventually(t, "log compacted after acknowledgement", func() bool { return leader.LogSize() == 0 })
+
+			// The log does not wait for a follower that is gone
+			if _, err := follower.Promote(); err != nil {
+				t.Fatal(err)
+			}
+			mustSucceed(t, db.Insert("users", "last", Record{"name": "last"}))
+			eventually(t, "log compacted after disconnect", func() bool { return leader.LogSize() == 0 })
+		})
+	}
+}
+
+func TestFollowerReplicates(t *testing.T) {
+	totals := ViewQuery{
+		Table:      "users",
+		GroupBy:    []string{"name"},
+		Aggregates: []Aggregate{{Function: "COUNT", Alias: "users"}},
+	}
+	tests := []struct {
+		name  string
+		write func(t *testing.T, db Database)
+		check func(t *testing.T, db Database)
+	}{
+		{
+			name:  "delete",
+			write: func(t *testing.T, db Database) { mustSucceed(t, db.Delete("users", "1")) },
+			check: func(t *testing.T, db Database) {
+				if _, _, err := db.Get("users", "1"); !errors.Is(err, ErrRecordNotFound) {
+					t.Errorf("Get() of the deleted row error = %v, want %v", err, ErrRecordNotFound)
+				}
+				if _, _, err := db.Get("users", "2"); err != nil {
+					t.Errorf("Get() of the remaining row: %v", err)
+				}
+			},
+		},
+		{
+			name: "update",
+			write: func(t *testing.T, db Database) {
+				if _, err := db.UpdateIfVersion("users", "1", Record{"name": "Carol"}, 1); err != nil {
+					t.Fatal(err)
+				}
+			},
+			check: func(t *testing.T, db Database) {
+				record, version, err := db.Get("users", "1")
+				if err != nil || record["name"] != "Carol" || version != 3 {
+					t.Errorf("Get() = %v at version %d, %v, want Carol at version 3", record, version, err)
+				}
+			},
+		},
+		{
+			name: "create indexes",
+			write: func(t *testing.T, db Database) {
+				mustSucceed(t, db.CreateIndex("users", "name"))
+				mustSucceed(t, db.CreateUniqueIndex("users", "name", "age"))
+			},
+			check: func(t *testing.T, db Database) {
+				var indexes []IndexStats
+				for _, table := range db.Stats().Tables {
+					if table.Name == "users" {
+						indexes = table.Indexes
+					}
+				}
+				if len(indexes) != 2 || indexes[0].Name != "name" || indexes[0].Unique || indexes[1].Name != "name,age" || !indexes[1].Unique {
+					t.Errorf("indexes = %+v, want name and a unique name,age", indexes)
+				}
+			},
+		},
+		{
+			name: "materialized view",
+			write: func(t *testing.T, db Database) {
+				mustSucceed(t, db.CreateMaterializedView("totals", totals))
+				mustSucceed(t, db.Insert("users", "3", Record{"name": "Alice", "age": 41}))
+			},
+			check: func(t *testing.T, db Database) {
+				rows, err := db.SelectWithConditions("totals", []string{"users"}, []Condition{{Attribute: "name", Operator: "=", Value: "Alice"}}, "AND")
+				if err != nil || len(rows) != 1 || rows[0]["users"] != 2 {
+					t.Errorf("view rows for Alice = %v, %v, want a count of 2", rows, err)
+				}
+			},
+		},
+		{
+			name: "restore with a view sorted before its source",
+			write: func(t *testing.T, db Database) {
+				source := NewInMemoryDB()
+				mustSucceed(t, source.CreateTable("people", map[string]string{"name": "string"}))
+				mustSucceed(t, source.Insert("people", "1", Record{"name": "Dave"}))
+				mustSucceed(t, source.CreateMaterializedView("a_totals", ViewQuery{
+					Table:      "people",
+					Aggregates: []Aggregate{{Function: "COUNT", Alias: "people"}},
+				}))
+				mustSucceed(t, db.Restore(bytes.NewReader(backupOf(t, source))))
+			},
+			check: func(t *testing.T, db Database) {
+				row, _, err := db.Get("a_totals", "")
+				if err != nil || row["people"] != 1 {
+					t.Errorf("Get() of the restored view = %v, %v, want a count of 1", row, err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewInMemoryDB()
+			leader, err := NewLeader(db, "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer leader.Close()
+			follower, err := NewFollower(leader.Addr())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer follower.Promote()
+
+			mustSucceed(t, db.CreateTable("users", map[string]string{"name": "string", "age": "int"}))
+			mustSucceed(t, db.Insert("users", "1", Record{"name": "Alice", "age": 30}))
+			mustSucceed(t, db.Insert("users", "2", Record{"name": "Bob", "age": 25}))
+			tt.write(t, db)
+			if err := follower.WaitForLSN(leader.LSN(), 5*time.Second); err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, follower.DB())
+		})
+	}
+}
+
+func TestFollowerReadOnlyUntilPromoted(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(db Database) error
+	}{
+		{name: "create table", write: func(db Database) error {
+			return db.CreateTable("orders", map[string]string{"item": "string"})
+		}},
+		{name: "insert", write: func(db Database) error { return db.Insert("users", "2", Record{"name": "Bob"}) }},
+		{name: "update", write: func(db Database) error {
+			_, err := db.UpdateIfVersion("users", "1", Record{"name": "Carol"}, 1)
+			return err
+		}},
+		{name: "delete", write: func(db Database) error { return db.Delete("users", "1") }},
+		{name: "create index", write: func(db Database) error { return db.CreateIndex("users", "name") }},
+		{name: "create view", write: func(db Database) error {
+			return db.CreateMaterializedView("totals", ViewQuery{Table: "users", Aggregates: []Aggregate{{Function: "COUNT", Alias: "users"}}})
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewInMemoryDB()
+			mustSucceed(t, db.CreateTable("users", map[string]string{"name": "string"}))
+			mustSucceed(t, db.Insert("users", "1", Record{"name": "Alice"}))
+			leader, err := NewLeader(db, "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer leader.Close()
+			follower, err := NewFollower(leader.Addr())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := follower.WaitForLSN(leader.LSN(), 5*time.Second); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := tt.write(follower.DB()); !errors.Is(err, ErrReadOnly) {
+				t.Fatalf("write before promotion: error = %v, want %v", err, ErrReadOnly)
+			}
+			if _, _, err := follower.DB().Get("users", "1"); err != nil {
+				t.Errorf("read before promotion: %v", err)
+			}
+
+			promoted, err := follower.Promote()
+			if err != nil {
+				t.Fatal(err)
+			}
+			mustSucceed(t, tt.write(promoted))
+			if _, err := follower.Promote(); err == nil {
+				t.Error("second Promote() succeeded")
+			}
+			// The leader's later writes no longer reach the promoted database
+			mustSucceed(t, db.Insert("users", "9", Record{"name": "Zoe"}))
+			time.Sleep(2 * heartbeatInterval)
+			if _, _, err := promoted.Get("users", "9"); !errors.Is(err, ErrRecordNotFound) {
+				t.Errorf("Get() of a row written after promotion error = %v, want %v", err, ErrRecordNotFound)
+			}
+		})
+	}
+}
+
+func TestFollowerLag(t *testing.T) {
+	tests := []struct {
+		name    string
+		frames  []replicationFrame
+		applied uint64
+		entries uint64 // Expected lag in entries once the frames are applied
+	}{
+		{
+			name:    "caught up",
+			frames:  []replicationFrame{{LeaderLSN: 2, Mutations: testMutations(1, 2)}},
+			applied: 2,
+		},
+		{
+			name:    "behind",
+			frames:  []replicationFrame{{LeaderLSN: 5, Mutations: testMutations(1, 2)}},
+			applied: 2,
+			entries: 3,
+		},
+		{
+			name: "caught up again",
+			frames: []replicationFrame{
+				{LeaderLSN: 5, Mutations: testMutations(1, 2)},
+				{LeaderLSN: 5, Mutations: testMutations(3, 5)},
+			},
+			applied: 5,
+		},
+		{
+			name: "behind after a heartbeat",
+			frames: []replicationFrame{
+				{LeaderLSN: 2, Mutations: testMutations(1, 2)},
+				{LeaderLSN: 4},
+			},
+			applied: 2,
+			entries: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			follower, conn := scriptedFollower(t)
+			encoder := gob.NewEncoder(conn)
+			for _, frame := range tt.frames {
+				if err := encoder.Encode(frame); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Wait until the follower has processed the last frame
+			last := tt.frames[len(tt.frames)-1].LeaderLSN
+			eventually(t, "frames applied", func() bool {
+				follower.mu.Lock()
+				defer follower.mu.Unlock()
+				return follower.leaderLSN == last && follower.applied == tt.applied
+			})
+
+			lag := follower.Lag()
+			if lag.Entries != tt.entries {
+				t.Errorf("Lag().Entries = %d, want %d", lag.Entries, tt.entries)
+			}
+			if behind := lag.Time > 0; behind != (tt.entries > 0) {
+				t.Errorf("Lag().Time = %v, want it positive: %t", lag.Time, tt.entries > 0)
+			}
+		})
+	}
+}
+
+func TestFollowerStopsWhenAckFails(t *testing.T) {
+	follower, conn := scriptedFollower(t)
+	// The follower can read frames but no longer send acknowledgements
+	if err := follower.conn.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gob.NewEncoder(conn).Encode(replicationFrame{LeaderLSN: 1, Mutations: testMutations(1, 1)}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-follower.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("follower kept replicating after failing to acknowledge")
+	}
+	if err := follower.Err(); err == nil || !strings.Contains(err.Error(), "acknowledging LSN 1") {
+		t.Errorf("Err() = %v, want the failed acknowledgement", err)
+	}
+}
+
+// scriptedFollower connects a follower to a listener that stands in for the
+// leader. It returns the follower and the leader's end of the connection,
+// ready for frames.
+func scriptedFollower(t *testing.T) (*Follower, net.Conn) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	follower, err := NewFollower(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		follower.Promote()
+	})
+	var hello replicationHello
+	if err := gob.NewDecoder(conn).Decode(&hello); err != nil {
+		t.Fatal(err)
+	}
+	return follower, conn
+}
+
+// testMutations returns the mutations with LSNs from through to: the first
+// creates a table, the others insert into it.
+func testMutations(from, to uint64) []Mutation {
+	var mutations []Mutation
+	for lsn := from; lsn <= to; lsn++ {
+		if lsn == 1 {
+			mutations = append(mutations, Mutation{LSN: lsn, Type: MutationCreateTable, Table: "users", Schema: map[string]string{"name": "string"}})
+			continue
+		}
+		mutations = append(mutations, Mutation{LSN: lsn, Type: MutationInsert, Table: "users", Key: fmt.Sprint(lsn), Record: Record{"name": "user"}, Version: lsn})
+	}
+	return mutations
+}
+
+// eventually fails the test unless cond holds within a second.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting: %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
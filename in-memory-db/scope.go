@@ -0,0 +1,208 @@
+package inmemorydb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// maxTriggerDepth bounds how deeply triggers may fire other triggers.
+const maxTriggerDepth = 16
+
+// writeScope is the unit every write runs in. It locks the tables it writes
+// to, fires their triggers, and on failure undoes all of its changes. The
+// mutations of a scope are only emitted once the whole scope has succeeded.
+//
+// A scope that touches more than one table first takes db.scopeLock. Only one
+// such scope runs at a time, so two of them can never wait on each other's
+// table locks, and everybody else holds at most one table lock at a time.
+type writeScope struct {
+	db        *InMemoryDB
+	locked    []*Table
+	serial    bool           // Holds db.scopeLock
+	replay    bool           // Applies replicated mutations: no triggers, no new versions
+	undo      []func() error // Run in reverse order to roll the scope back
+	mutations []Mutation
+	depth     int
+}
+
+// write runs fn in a new scope that holds the lock of the named table.
+func (db *InMemoryDB) write(ctx context.Context, tableName string, fn func(s *writeScope, table *Table) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	table, err := db.getTable(tableName)
+	if err != nil {
+		return err
+	}
+
+	s := &writeScope{db: db}
+	s.lockFirst(table)
+	defer s.unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := fn(s, table); err != nil {
+		if rollbackErr := s.rollback(); rollbackErr != nil {
+			return errors.Join(err, rollbackErr)
+		}
+		return err
+	}
+	for _, mutation := range s.mutations {
+		db.emit(mutation)
+	}
+	if cache := db.cache.Load(); cache != nil {
+		for _, table := range s.locked {
+			cache.invalidate(table)
+		}
+	}
+	return nil
+}
+
+// lockFirst locks the table the scope starts with. Tables with triggers may
+// pull further tables into the scope, so their writes are serialized first.
+func (s *writeScope) lockFirst(table *Table) {
+	table.lockData()
+	if !table.needsSerialScope() {
+		s.locked = append(s.locked, table)
+		return
+	}
+	table.dataLock.Unlock()
+
+	s.db.scopeLock.Lock()
+	s.serial = true
+	table.lockData()
+	s.locked = append(s.locked, table)
+}
+
+// lock adds table to the scope.
+func (s *writeScope) lock(table *Table) error {
+	for _, locked := range s.locked {
+		if locked == table {
+			return nil
+		}
+	}
+	if !s.serial {
+		return fmt.Errorf("cannot write to table %s from this scope", table.name)
+	}
+	table.lockData()
+	s.locked = append(s.locked, table)
+	return nil
+}
+
+func (s *writeScope) unlock() {
+	for i := len(s.locked) - 1; i >= 0; i-- {
+		s.locked[i].dataLock.Unlock()
+	}
+	if s.serial {
+		s.db.scopeLock.Unlock()
+	}
+}
+
+// rollback runs every undo step, even if some fail, and returns their errors.
+func (s *writeScope) rollback() error {
+	var errs []error
+	for i := len(s.undo) - 1; i >= 0; i-- {
+		if err := s.undo[i](); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.undo = nil
+	s.mutations = nil
+	if len(errs) > 0 {
+		return fmt.Errorf("rolling back: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// saveRow registers an undo step that restores the current row under key.
+func (s *writeScope) saveRow(table *Table, key string) {
+	record, existed := table.data[key]
+	version := table.versions[key]
+	s.undo = append(s.undo, func() error {
+		if existed {
+			table.put(key, record, version)
+		} else {
+			table.remove(key)
+		}
+		return nil
+	})
+}
+
+// insert stores record under key, firing the insert triggers of the table.
+// Before-triggers may change the record or reject the write. A zero version
+// assigns the next version of the table. The caller must have added table to
+// the scope.
+func (s *writeScope) insert(table *Table, key string, record Record, version uint64) (uint64, error) {
+	if table.view != nil {
+		return 0, fmt.Errorf("%w: %s", ErrReadOnlyTable, table.name)
+	}
+	old := table.data[key]
+	event := &TriggerEvent{Table: table.name, Kind: TriggerInsert, Key: key, Old: old, New: record, Tx: &TriggerTx{scope: s}}
+	if err := s.fire(table, event, TriggerBefore); err != nil {
+		return 0, err
+	}
+	if err := table.validate(event.New); err != nil {
+		return 0, err
+	}
+	if err := table.checkUnique(key, event.New); err != nil {
+		return 0, err
+	}
+
+	s.saveRow(table, key)
+	version = table.put(key, event.New, version)
+	s.mutations = append(s.mutations, Mutation{Type: MutationInsert, Table: table.name, Key: key, Record: cloneRecord(event.New), Version: version})
+	if err := s.maintainViews(table, old, event.New); err != nil {
+		return 0, err
+	}
+
+	if err := s.fire(table, event, TriggerAfter); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// delete removes the row stored under key, firing the delete triggers of the
+// table. Deleting a missing row does nothing.
+func (s *writeScope) delete(table *Table, key string) error {
+	if table.view != nil {
+		return fmt.Errorf("%w: %s", ErrReadOnlyTable, table.name)
+	}
+	old, existed := table.data[key]
+	if !existed {
+		return nil
+	}
+	event := &TriggerEvent{Table: table.name, Kind: TriggerDelete, Key: key, Old: old, Tx: &TriggerTx{scope: s}}
+	if err := s.fire(table, event, TriggerBefore); err != nil {
+		return err
+	}
+
+	s.saveRow(table, key)
+	table.remove(key)
+	s.mutations = append(s.mutations, Mutation{Type: MutationDelete, Table: table.name, Key: key})
+	if err := s.maintainViews(table, old, nil); err != nil {
+		return err
+	}
+
+	return s.fire(table, event, TriggerAfter)
+}
+
+func (s *writeScope) fire(table *Table, event *TriggerEvent, timing TriggerTiming) error {
+	triggers := table.triggers[triggerSlot{kind: event.Kind, timing: timing}]
+	if len(triggers) == 0 || s.replay {
+		return nil
+	}
+	if s.depth >= maxTriggerDepth {
+		return fmt.Errorf("triggers nested deeper than %d levels on table %s", maxTriggerDepth, table.name)
+	}
+
+	s.depth++
+	defer func() { s.depth-- }()
+	for _, trigger := range triggers {
+		if err := trigger(event); err != nil {
+			return fmt.Errorf("%s trigger on table %s: %w", timing, table.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package inmemorydb
+
+import (
+	"context"
+	"fmt"
+)
+
+// validate checks that record has a value of the right type for every column.
+func (t *Table) validate(record Record) error {
+	for column, dataType := range t.schema {
+		if value, ok := record[column]; ok {
+			if actual := fmt.Sprintf("%T", value); actual != dataType {
+				return &SchemaError{Table: t.name, Column: column, Expected: dataType, Actual: actual}
+			}
+		} else {
+			//Ignore this if we want optional fields
+			return &SchemaError{Table: t.name, Column: column, Expected: dataType}
+		}
+	}
+	return nil
+}
+
+// put stores record under key and keeps the indexes in sync. A zero version
+// assigns the next version of the table. The caller must hold dataLock.
+func (t *Table) put(key string, record Record, version uint64) uint64 {
+	if version == 0 {
+		t.lastVersion++
+		version = t.lastVersion
+	} else if version > t.lastVersion {
+		t.lastVersion = version
+	}
+
+	t.generation.Add(1)
+	t.indexLock.Lock()
+	defer t.indexLock.Unlock()
+	if old, exists := t.data[key]; exists {
+		t.unindex(key, old)
+	}
+	t.data[key] = record
+	t.versions[key] = version
+	for _, index := range t.indexes {
+		index.add(record, key)
+	}
+	return version
+}
+
+// remove deletes the row stored under key. The caller must hold dataLock.
+func (t *Table) remove(key string) {
+	record, exists := t.data[key]
+	if !exists {
+		return
+	}
+	t.generation.Add(1)
+	t.indexLock.Lock()
+	t.unindex(key, record)
+	t.indexLock.Unlock()
+	delete(t.data, key)
+	delete(t.versions, key)
+}
+
+// checkUnique rejects record if a unique index already holds its values for
+// another row. The caller must hold dataLock.
+func (t *Table) checkUnique(key string, record Record) error {
+	t.indexLock.RLock()
+	defer t.indexLock.RUnlock()
+	for _, index := range t.indexes {
+		if existing, conflict := index.conflict(record, key); conflict {
+			return &UniqueViolationError{Table: t.name, Columns: index.columns, Key: key, ExistingKey: existing}
+		}
+	}
+	return nil
+}
+
+// unindex drops key from the index entries of record. The caller must hold
+// indexLock.
+func (t *Table) unindex(key string, record Record) {
+	for _, index := range t.indexes {
+		index.remove(record, key)
+	}
+}
+
+// scanCheckInterval is how many rows a scan visits between checks for a
+// cancelled context.
+const scanCheckInterval = 256
+
+// scan calls fn for every row matching the conditions. For AND queries the
+// first condition on an indexed column narrows the rows that are checked,
+// otherwise all rows are scanned. The scan stops with the context's error
+// once ctx is done. The caller must hold dataLock.
+func (t *Table) scan(ctx context.Context, conditions []Condition, logicalOperator string, fn func(key string, record Record) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	scanned := 0
+	visit := func(key string, record Record) error {
+		if scanned++; scanned%scanCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		matched, err := evaluateConditions(record, conditions, logicalOperator)
+		if err != nil || !matched {
+			return err
+		}
+		return fn(key, record)
+	}
+
+	if keys, ok := t.indexedCandidates(conditions, logicalOperator); ok {
+		t.stats.indexHits.Add(1)
+		for _, key := range keys {
+			if err := visit(key, t.data[key]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(conditions) > 0 {
+		t.stats.indexMisses.Add(1)
+	}
+	t.stats.fullScans.Add(1)
+	for key, record := range t.data {
+		if err := visit(key, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexedCandidates returns the keys of the rows an index says can match. ok
+// is false when no index applies. The index matching the most leading
+// columns with equality conditions wins, then one that also covers a range on
+// the next column, then the one with the lowest name.
+func (t *Table) indexedCandidates(conditions []Condition, logicalOperator string) ([]string, bool) {
+	if logicalOperator != "AND" {
+		return nil, false
+	}
+	t.indexLock.RLock()
+	defer t.indexLock.RUnlock()
+
+	var best *index
+	var bestMatch indexMatch
+	bestScore := 0
+	for name, index := range t.indexes {
+		m, ok := index.match(conditions)
+		if !ok {
+			continue
+		}
+		score := 2 * len(m.equal)
+		if m.last != nil {
+			score++
+		}
+		if score > bestScore || score == bestScore && name < indexName(best.columns) {
+			best, bestMatch, bestScore = index, m, score
+		}
+	}
+	if best == nil {
+		return nil, false
+	}
+	best.hits.Add(1)
+	return best.search(bestMatch), true
+}
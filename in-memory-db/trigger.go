@@ -0,0 +1,147 @@
+package inmemorydb
+
+import "fmt"
+
+type TriggerKind int
+
+const (
+	TriggerInsert TriggerKind = iota
+	TriggerDelete
+)
+
+func (k TriggerKind) String() string {
+	if k == TriggerDelete {
+		return "delete"
+	}
+	return "insert"
+}
+
+type TriggerTiming int
+
+const (
+	TriggerBefore TriggerTiming = iota
+	TriggerAfter
+)
+
+func (t TriggerTiming) String() string {
+	if t == TriggerAfter {
+		return "after"
+	}
+	return "before"
+}
+
+// TriggerEvent describes the write a trigger fires for. Before-triggers may
+// replace or modify New. Old is nil when an insert creates a new row, New is
+// nil for deletes.
+type TriggerEvent struct {
+	Table string
+	Kind  TriggerKind
+	Key   string
+	Old   Record
+	New   Record
+	Tx    *TriggerTx // Reads and writes inside the scope of the triggering write
+}
+
+// TriggerFunc runs inside the lock scope of the write that fired it. Returning
+// an error rejects the write and undoes everything done in the scope,
+// including the writes of other triggers.
+type TriggerFunc func(event *TriggerEvent) error
+
+type triggerSlot struct {
+	kind   TriggerKind
+	timing TriggerTiming
+}
+
+// RegisterTrigger runs fn before or after every insert or delete on a table.
+func (db *InMemoryDB) RegisterTrigger(tableName string, kind TriggerKind, timing TriggerTiming, fn TriggerFunc) error {
+	table, err := db.getTable(tableName)
+	if err != nil {
+		return err
+	}
+
+	table.lockData()
+	defer table.dataLock.Unlock()
+	if table.view != nil {
+		return fmt.Errorf("%w: cannot register a trigger on %s", ErrReadOnlyTable, tableName)
+	}
+	if table.triggers == nil {
+		table.triggers = make(map[triggerSlot][]TriggerFunc)
+	}
+	slot := triggerSlot{kind: kind, timing: timing}
+	table.triggers[slot] = append(table.triggers[slot], fn)
+	return nil
+}
+
+// needsSerialScope reports whether writes to the table may lock other tables.
+// The caller must hold dataLock.
+func (t *Table) needsSerialScope() bool {
+	return len(t.triggers) > 0 || len(t.views) > 0
+}
+
+// TriggerTx gives triggers access to the database without leaving the scope
+// of the write that fired them. Writes made through it fire triggers too and
+// are undone if the scope fails.
+type TriggerTx struct {
+	scope *writeScope
+	check func(tableName string, required Permission) error // Grants of the session that registered the trigger, if any
+}
+
+// guarded returns a TriggerTx that also checks every access against check.
+func (tx *TriggerTx) guarded(check func(tableName string, required Permission) error) *TriggerTx {
+	if tx.check == nil {
+		return &TriggerTx{scope: tx.scope, check: check}
+	}
+	outer := tx.check
+	return &TriggerTx{scope: tx.scope, check: func(tableName string, required Permission) error {
+		if err := outer(tableName, required); err != nil {
+			return err
+		}
+		return check(tableName, required)
+	}}
+}
+
+func (tx *TriggerTx) Get(tableName string, key string) (Record, uint64, error) {
+	table, err := tx.table(tableName, PermissionRead)
+	if err != nil {
+		return nil, 0, err
+	}
+	record, found := table.data[key]
+	if !found {
+		return nil, 0, recordNotFound(tableName, key)
+	}
+	return record, table.versions[key], nil
+}
+
+func (tx *TriggerTx) Insert(tableName string, key string, record Record) error {
+	table, err := tx.table(tableName, PermissionWrite)
+	if err != nil {
+		return err
+	}
+	_, err = tx.scope.insert(table, key, record, 0)
+	return err
+}
+
+func (tx *TriggerTx) Delete(tableName string, key string) error {
+	table, err := tx.table(tableName, PermissionWrite)
+	if err != nil {
+		return err
+	}
+	return tx.scope.delete(table, key)
+}
+
+// table adds the named table to the scope, once the access is allowed.
+func (tx *TriggerTx) table(tableName string, required Permission) (*Table, error) {
+	if tx.check != nil {
+		if err := tx.check(tableName, required); err != nil {
+			return nil, err
+		}
+	}
+	table, err := tx.scope.db.getTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.scope.lock(table); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
@@ -0,0 +1,94 @@
+package inmemorydb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTriggerKeepsRegisteringSessionGrants(t *testing.T) {
+	tests := []struct {
+		name    string
+		audit   Permission // What the trigger's owner may do on audit
+		trigger TriggerFunc
+		denied  bool
+	}{
+		{
+			name:  "write without grant",
+			audit: PermissionNone,
+			trigger: func(event *TriggerEvent) error {
+				return event.Tx.Insert("audit", event.Key, Record{"action": "insert"})
+			},
+			denied: true,
+		},
+		{
+			name:  "write with read grant",
+			audit: PermissionRead,
+			trigger: func(event *TriggerEvent) error {
+				return event.Tx.Insert("audit", event.Key, Record{"action": "insert"})
+			},
+			denied: true,
+		},
+		{
+			name:  "read without grant",
+			audit: PermissionNone,
+			trigger: func(event *TriggerEvent) error {
+				_, _, err := event.Tx.Get("audit", "seed")
+				return err
+			},
+			denied: true,
+		},
+		{
+			name:  "write with write grant",
+			audit: PermissionWrite,
+			trigger: func(event *TriggerEvent) error {
+				return event.Tx.Insert("audit", event.Key, Record{"action": "insert"})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewInMemoryDB()
+			control := NewAccessControl(db, "root")
+			root, err := control.OpenSession("root")
+			if err != nil {
+				t.Fatal(err)
+			}
+			mustSucceed(t, root.CreateTable("orders", map[string]string{"item": "string"}))
+			mustSucceed(t, root.CreateTable("audit", map[string]string{"action": "string"}))
+			mustSucceed(t, root.Insert("audit", "seed", Record{"action": "seed"}))
+			mustSucceed(t, root.CreateRole("clerk"))
+			mustSucceed(t, root.Grant("clerk", "orders", PermissionAdmin))
+			if tt.audit != PermissionNone {
+				mustSucceed(t, root.Grant("clerk", "audit", tt.audit))
+			}
+			mustSucceed(t, root.CreateUser("carol"))
+			mustSucceed(t, root.AssignRole("carol", "clerk"))
+
+			carol, err := control.OpenSession("carol")
+			if err != nil {
+				t.Fatal(err)
+			}
+			mustSucceed(t, carol.RegisterTrigger("orders", TriggerInsert, TriggerAfter, tt.trigger))
+
+			err = carol.Insert("orders", "1", Record{"item": "book"})
+			var denied *PermissionDeniedError
+			if got := errors.As(err, &denied); got != tt.denied {
+				t.Fatalf("Insert() error = %v, want permission denied: %t", err, tt.denied)
+			}
+			if tt.denied && denied.Table != "audit" {
+				t.Errorf("denied on %q, want audit", denied.Table)
+			}
+
+			// A denied trigger undoes the write that fired it
+			_, _, orderErr := db.Get("orders", "1")
+			_, _, auditErr := db.Get("audit", "1")
+			if tt.denied && (orderErr == nil || auditErr == nil) {
+				t.Errorf("denied insert left rows behind: orders %v, audit %v", orderErr, auditErr)
+			}
+			if !tt.denied && (orderErr != nil || auditErr != nil) {
+				t.Errorf("allowed insert is missing rows: orders %v, audit %v", orderErr, auditErr)
+			}
+		})
+	}
+}
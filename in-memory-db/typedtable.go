@@ -0,0 +1,226 @@
+package inmemorydb
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// TypedTable stores values of the struct type T as rows of a table.
+//
+// Columns are derived from the exported fields of T. The `db` tag renames a
+// column, `db:"-"` skips a field and the `key` option marks the string field
+// used as the row key. Fields must be booleans, numbers, strings, time.Time,
+// or types defined on those such as time.Duration and Decimal:
+//
+//	type User struct {
+//		ID   string `db:"id,key"`
+//		Name string `db:"name"`
+//		Age  int    `db:"age"`
+//	}
+type TypedTable[T any] struct {
+	db      Database
+	name    string
+	columns []typedColumn
+	key     int // Position of the key column in columns
+	schema  map[string]string
+}
+
+type typedColumn struct {
+	name     string
+	field    int
+	dataType string
+}
+
+// NewTable creates a table whose schema is derived from the fields of T.
+func NewTable[T any](db Database, name string) (*TypedTable[T], error) {
+	structType := reflect.TypeOf((*T)(nil)).Elem()
+	if structType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("typed table %s: %s is not a struct", name, structType)
+	}
+
+	table := &TypedTable[T]{db: db, name: name, key: -1, schema: make(map[string]string)}
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		column, options, _ := strings.Cut(field.Tag.Get("db"), ",")
+		if column == "-" {
+			continue
+		}
+		if column == "" {
+			column = field.Name
+		}
+		if _, exists := table.schema[column]; exists {
+			return nil, fmt.Errorf("typed table %s: duplicate column %s", name, column)
+		}
+
+		if !supportedFieldType(field.Type) {
+			return nil, fmt.Errorf("typed table %s: field %s has unsupported type %s", name, field.Name, field.Type)
+		}
+		dataType := fmt.Sprintf("%T", reflect.Zero(field.Type).Interface())
+		if slices.Contains(strings.Split(options, ","), "key") {
+			if table.key != -1 {
+				return nil, fmt.Errorf("typed table %s: more than one key field", name)
+			}
+			if field.Type.Kind() != reflect.String {
+				return nil, fmt.Errorf("typed table %s: key field %s must be a string", name, field.Name)
+			}
+			table.key = len(table.columns)
+		}
+		table.columns = append(table.columns, typedColumn{name: column, field: i, dataType: dataType})
+		table.schema[column] = dataType
+	}
+	if table.key == -1 {
+		return nil, fmt.Errorf("typed table %s: no field is tagged as key", name)
+	}
+
+	if err := db.CreateTable(name, table.schema); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+// supportedFieldType tells whether values of a field type can be stored in a
+// column: they need a fixed, comparable type for schema checks and indexes.
+func supportedFieldType(fieldType reflect.Type) bool {
+	switch fieldType.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return fieldType == reflect.TypeOf(time.Time{})
+}
+
+func (t *TypedTable[T]) Insert(value T) error {
+	record, key := t.toRecord(value)
+	return t.db.Insert(t.name, key, record)
+}
+
+func (t *TypedTable[T]) Get(key string) (T, error) {
+	record, _, err := t.db.Get(t.name, key)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return t.fromRecord(record)
+}
+
+func (t *TypedTable[T]) Delete(key string) error {
+	return t.db.Delete(t.name, key)
+}
+
+func (t *TypedTable[T]) CreateIndex(columns ...string) error {
+	return t.db.CreateIndex(t.name, columns...)
+}
+
+func (t *TypedTable[T]) CreateUniqueIndex(columns ...string) error {
+	return t.db.CreateUniqueIndex(t.name, columns...)
+}
+
+// Where returns every value matching the conditions, combined with the
+// logical operator ("AND" or "OR"). Conditions are checked against the schema
+// so a mistyped column or value is an error instead of an empty result.
+func (t *TypedTable[T]) Where(logicalOperator string, conditions ...Condition) ([]T, error) {
+	for _, condition := range conditions {
+		if err := t.checkCondition(condition); err != nil {
+			return nil, err
+		}
+	}
+
+	columns := make([]string, len(t.columns))
+	for i, column := range t.columns {
+		columns[i] = column.name
+	}
+	records, err := t.db.SelectWithConditions(t.name, columns, conditions, logicalOperator)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]T, 0, len(records))
+	for _, record := range records {
+		value, err := t.fromRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func (t *TypedTable[T]) checkCondition(condition Condition) error {
+	dataType, exists := t.schema[condition.Attribute]
+	if !exists {
+		return &SchemaError{Table: t.name, Column: condition.Attribute}
+	}
+	for _, value := range []interface{}{condition.Value, condition.SecondValue} {
+		if actual := fmt.Sprintf("%T", value); value != nil && actual != dataType {
+			return &SchemaError{Table: t.name, Column: condition.Attribute, Expected: dataType, Actual: actual}
+		}
+	}
+	return nil
+}
+
+func (t *TypedTable[T]) toRecord(value T) (Record, string) {
+	structValue := reflect.ValueOf(value)
+	record := make(Record, len(t.columns))
+	for _, column := range t.columns {
+		record[column.name] = structValue.Field(column.field).Interface()
+	}
+	return record, structValue.Field(t.columns[t.key].field).String()
+}
+
+func (t *TypedTable[T]) fromRecord(record map[string]interface{}) (T, error) {
+	var value T
+	structValue := reflect.ValueOf(&value).Elem()
+	for _, column := range t.columns {
+		columnValue, exists := record[column.name]
+		if !exists || columnValue == nil {
+			continue
+		}
+		field := structValue.Field(column.field)
+		v := reflect.ValueOf(columnValue)
+		if !v.Type().AssignableTo(field.Type()) {
+			return value, &SchemaError{Table: t.name, Column: column.name, Expected: column.dataType, Actual: v.Type().String()}
+		}
+		field.Set(v)
+	}
+	return value, nil
+}
+
+// Field names a column whose values have type V, so conditions built from it
+// can only compare against values of that type.
+type Field[V any] string
+
+func (f Field[V]) Eq(value V) Condition {
+	return Condition{Attribute: string(f), Operator: "=", Value: value}
+}
+
+func (f Field[V]) Ne(value V) Condition {
+	return Condition{Attribute: string(f), Operator: "!=", Value: value}
+}
+
+func (f Field[V]) Lt(value V) Condition {
+	return Condition{Attribute: string(f), Operator: "<", Value: value}
+}
+
+func (f Field[V]) Le(value V) Condition {
+	return Condition{Attribute: string(f), Operator: "<=", Value: value}
+}
+
+func (f Field[V]) Gt(value V) Condition {
+	return Condition{Attribute: string(f), Operator: ">", Value: value}
+}
+
+func (f Field[V]) Ge(value V) Condition {
+	return Condition{Attribute: string(f), Operator: ">=", Value: value}
+}
+
+func (f Field[V]) Between(low, high V) Condition {
+	return Condition{Attribute: string(f), Operator: "BETWEEN", Value: low, SecondValue: high}
+}
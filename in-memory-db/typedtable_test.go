@@ -0,0 +1,134 @@
+package inmemorydb
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type typedOrder struct {
+	ID       string        `db:"id,key"`
+	Customer string        `db:"customer"`
+	Quantity int           `db:"quantity"`
+	Amount   Decimal       `db:"amount"`
+	Placed   time.Time     `db:"placed"`
+	Window   time.Duration `db:"window"`
+	Paid     bool          `db:"paid"`
+	Notes    []string      `db:"-"`
+}
+
+func TestNewTableFieldTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		create  func(db Database) error
+		wantErr string
+	}{
+		{
+			name:   "supported types",
+			create: func(db Database) error { _, err := NewTable[typedOrder](db, "orders"); return err },
+		},
+		{
+			name: "interface",
+			create: func(db Database) error {
+				_, err := NewTable[struct {
+					ID    string      `db:"id,key"`
+					Value interface{} `db:"value"`
+				}](db, "values")
+				return err
+			},
+			wantErr: "field Value has unsupported type interface {}",
+		},
+		{
+			name: "pointer",
+			create: func(db Database) error {
+				_, err := NewTable[struct {
+					ID    string `db:"id,key"`
+					Count *int   `db:"count"`
+				}](db, "counts")
+				return err
+			},
+			wantErr: "field Count has unsupported type *int",
+		},
+		{
+			name: "slice",
+			create: func(db Database) error {
+				_, err := NewTable[struct {
+					ID   string   `db:"id,key"`
+					Tags []string `db:"tags"`
+				}](db, "tags")
+				return err
+			},
+			wantErr: "field Tags has unsupported type []string",
+		},
+		{
+			name: "struct",
+			create: func(db Database) error {
+				_, err := NewTable[struct {
+					ID      string     `db:"id,key"`
+					Address typedOrder `db:"address"`
+				}](db, "addresses")
+				return err
+			},
+			wantErr: "field Address has unsupported type inmemorydb.typedOrder",
+		},
+		{
+			name: "key among other options",
+			create: func(db Database) error {
+				_, err := NewTable[struct {
+					ID   string `db:"id,omitempty,key"`
+					Name string `db:"name,omitempty"`
+				}](db, "names")
+				return err
+			},
+		},
+		{
+			name: "option that only starts with key",
+			create: func(db Database) error {
+				_, err := NewTable[struct {
+					ID string `db:"id,keyed"`
+				}](db, "ids")
+				return err
+			},
+			wantErr: "no field is tagged as key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewInMemoryDB()
+			err := tt.create(db)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("NewTable() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("NewTable() error = %v, want it to mention %q", err, tt.wantErr)
+			}
+			if len(db.(*InMemoryDB).tables) != 0 {
+				t.Error("a rejected typed table was created")
+			}
+		})
+	}
+}
+
+func TestTypedTableRoundTrip(t *testing.T) {
+	db := NewInMemoryDB()
+	orders, err := NewTable[typedOrder](db, "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	placed := time.Date(2024, time.May, 15, 12, 0, 0, 0, time.UTC)
+	want := typedOrder{ID: "1", Customer: "Alice", Quantity: 2, Amount: mustDecimal(t, "19.99"), Placed: placed, Window: time.Hour, Paid: true}
+	mustSucceed(t, orders.Insert(want))
+
+	got, err := orders.Get("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Get() = %+v, want %+v", got, want)
+	}
+}
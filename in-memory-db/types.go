@@ -1,21 +1,50 @@
 package inmemorydb
 
-import "sync"
+import (
+	"encoding/gob"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Schema data types for the non-builtin column types.
+const (
+	TypeTime     = "time.Time"
+	TypeDuration = "time.Duration"
+	TypeDecimal  = "inmemorydb.Decimal"
+)
+
+// Values travel as interface{} in replication frames and backups, so gob has
+// to know the column types that are not builtin.
+func init() {
+	gob.Register(time.Time{})
+	gob.Register(time.Duration(0))
+	gob.Register(Decimal(0))
+}
 
 type Record map[string]interface{}
 
 type Table struct {
 	name      string
-	schema    map[string]string                   // Column name -> Data type
-	data      map[string]Record                   // Row ID -> Record (row data)
-	indexes   map[string]map[interface{}][]string // Column -> Value -> List of Row IDs
+	schema    map[string]string // Column name -> Data type
+	data      map[string]Record // Row ID -> Record (row data)
+	versions  map[string]uint64 // Row ID -> Version of the row
+	indexes   map[string]*index // Column -> Value -> List of Row IDs
 	dataLock  sync.RWMutex
 	indexLock sync.RWMutex // Lock for index operations
 	persisted bool         // Flag for persistence support
+
+	stats       tableStats
+	generation  atomic.Uint64                 // Bumped on every change to the rows, see queryCache
+	lastVersion uint64                        // Versions are never reused, even after a delete
+	triggers    map[triggerSlot][]TriggerFunc // Guarded by dataLock
+	views       []*materializedView           // Views computed from this table, guarded by dataLock
+	view        *materializedView             // Set when this table is a materialized view
 }
 
 type Condition struct {
 	Attribute   string
+	Expr        Expr // Compared instead of Attribute when set
 	Operator    string
 	Value       interface{}
 	SecondValue interface{} // Used for BETWEEN
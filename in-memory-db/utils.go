@@ -1,25 +1,169 @@
 package inmemorydb
 
-func compareNumeric(value interface{}, conditionValue interface{}, comparator func(float64, float64) bool) bool {
-	valueFloat, ok1 := convertToFloat(value)
-	conditionFloat, ok2 := convertToFloat(conditionValue)
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// compareValues orders two values of compatible types. ok is false when the
+// values cannot be ordered against each other.
+func compareValues(a, b interface{}) (int, bool) {
+	switch x := a.(type) {
+	case time.Time:
+		y, ok := b.(time.Time)
+		return x.Compare(y), ok
+	case time.Duration:
+		y, ok := b.(time.Duration)
+		return cmp.Compare(x, y), ok
+	case string:
+		y, ok := b.(string)
+		return strings.Compare(x, y), ok
+	case Decimal:
+		if y, ok := b.(Decimal); ok {
+			return x.Cmp(y), true
+		}
+	}
+
+	aFloat, ok1 := convertToFloat(a)
+	bFloat, ok2 := convertToFloat(b)
 	if !ok1 || !ok2 {
-		return false
+		return 0, false
+	}
+	return cmp.Compare(aFloat, bFloat), true
+}
+
+func compareWith(value interface{}, conditionValue interface{}, accept func(int) bool) bool {
+	result, ok := compareValues(value, conditionValue)
+	return ok && accept(result)
+}
+
+// compareKeys is a total order over index keys. Values that compareValues
+// cannot order are grouped by type.
+func compareKeys(a, b interface{}) int {
+	if result, ok := compareValues(a, b); ok {
+		return result
 	}
-	return comparator(valueFloat, conditionFloat)
+	if result := strings.Compare(fmt.Sprintf("%T", a), fmt.Sprintf("%T", b)); result != 0 {
+		return result
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// valuesEqual tells whether two values are equal. Numbers of different
+// types, such as a Decimal and an int, are equal when their values are.
+func valuesEqual(a, b interface{}) bool {
+	if x, ok := a.(time.Time); ok {
+		y, ok := b.(time.Time)
+		return ok && x.Equal(y)
+	}
+	if reflect.TypeOf(a) == reflect.TypeOf(b) {
+		return a == b
+	}
+	_, aNumeric := convertToFloat(a)
+	_, bNumeric := convertToFloat(b)
+	return aNumeric && bNumeric && compareWith(a, b, func(c int) bool { return c == 0 })
+}
+
+// indexKey normalizes a value before it is used as a map key, so that equal
+// times in different locations share an index entry.
+func indexKey(value interface{}) interface{} {
+	if t, ok := value.(time.Time); ok {
+		return t.Round(0).UTC()
+	}
+	return value
 }
 
 func convertToFloat(value interface{}) (float64, bool) {
 	switch v := value.(type) {
 	case int:
 		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
 	case int64:
 		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
 	case float64:
 		return v, true
 	case float32:
 		return float64(v), true
+	case Decimal:
+		return v.Float64(), true
+	default:
+		return 0, false
+	}
+}
+
+// convertToDecimal converts Decimals and integers small enough to scale.
+func convertToDecimal(value interface{}) (Decimal, bool) {
+	if d, ok := value.(Decimal); ok {
+		return d, true
+	}
+	i, ok := convertToInt64(value)
+	if !ok {
+		return 0, false
+	}
+	d, err := NewDecimal(i, 0)
+	return d, err == nil
+}
+
+// convertToInt64 converts integers of any type that fit an int64. A
+// time.Duration is not an integer here.
+func convertToInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), v <= math.MaxInt64
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), v <= math.MaxInt64
 	default:
 		return 0, false
 	}
 }
+
+func cloneRecord(record Record) Record {
+	clone := make(Record, len(record))
+	for column, value := range record {
+		clone[column] = value
+	}
+	return clone
+}
+
+func cloneSchema(schema map[string]string) map[string]string {
+	clone := make(map[string]string, len(schema))
+	for column, dataType := range schema {
+		clone[column] = dataType
+	}
+	return clone
+}
@@ -0,0 +1,74 @@
+package inmemorydb
+
+import (
+	"context"
+	"time"
+)
+
+// InsertIfAbsent inserts record only if no row is stored under key and
+// returns the version of the new row.
+func (db *InMemoryDB) InsertIfAbsent(tableName string, key string, record Record) (uint64, error) {
+	return db.InsertIfAbsentContext(context.Background(), tableName, key, record)
+}
+
+func (db *InMemoryDB) InsertIfAbsentContext(ctx context.Context, tableName string, key string, record Record) (uint64, error) {
+	defer db.observe("insert_if_absent", time.Now())
+	var version uint64
+	err := db.write(ctx, tableName, func(s *writeScope, table *Table) error {
+		if current, exists := table.versions[key]; exists {
+			return &VersionConflictError{Table: tableName, Key: key, Expected: 0, Actual: current}
+		}
+		var err error
+		version, err = s.insert(table, key, record, 0)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// UpdateIfVersion merges updates into the row stored under key if it is still
+// at version, and returns the new version.
+func (db *InMemoryDB) UpdateIfVersion(tableName string, key string, updates Record, version uint64) (uint64, error) {
+	return db.UpdateIfVersionContext(context.Background(), tableName, key, updates, version)
+}
+
+func (db *InMemoryDB) UpdateIfVersionContext(ctx context.Context, tableName string, key string, updates Record, version uint64) (uint64, error) {
+	defer db.observe("update_if_version", time.Now())
+	var newVersion uint64
+	err := db.write(ctx, tableName, func(s *writeScope, table *Table) error {
+		current, exists := table.versions[key]
+		if !exists || current != version {
+			return &VersionConflictError{Table: tableName, Key: key, Expected: version, Actual: current}
+		}
+
+		record := cloneRecord(table.data[key])
+		for column, value := range updates {
+			record[column] = value
+		}
+		var err error
+		newVersion, err = s.insert(table, key, record, 0)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return newVersion, nil
+}
+
+// DeleteIfVersion deletes the row stored under key if it is still at version.
+func (db *InMemoryDB) DeleteIfVersion(tableName string, key string, version uint64) error {
+	return db.DeleteIfVersionContext(context.Background(), tableName, key, version)
+}
+
+func (db *InMemoryDB) DeleteIfVersionContext(ctx context.Context, tableName string, key string, version uint64) error {
+	defer db.observe("delete_if_version", time.Now())
+	return db.write(ctx, tableName, func(s *writeScope, table *Table) error {
+		current, exists := table.versions[key]
+		if !exists || current != version {
+			return &VersionConflictError{Table: tableName, Key: key, Expected: version, Actual: current}
+		}
+		return s.delete(table, key)
+	})
+}
@@ -0,0 +1,101 @@
+package inmemorydb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCompareAndSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		op      func(db Database, version uint64) (uint64, error)
+		want    uint64 // Version returned, 0 if the row is gone
+		wantErr bool
+	}{
+		{
+			name: "insert over an existing row",
+			op: func(db Database, version uint64) (uint64, error) {
+				return db.InsertIfAbsent("users", "1", Record{"name": "Bob"})
+			},
+			want:    1,
+			wantErr: true,
+		},
+		{
+			name: "insert a new row",
+			op: func(db Database, version uint64) (uint64, error) {
+				return db.InsertIfAbsent("users", "2", Record{"name": "Bob"})
+			},
+			want: 2, // Versions count up across the whole table
+		},
+		{
+			name: "update at the current version",
+			op: func(db Database, version uint64) (uint64, error) {
+				return db.UpdateIfVersion("users", "1", Record{"name": "Carol"}, version)
+			},
+			want: 2,
+		},
+		{
+			name: "update at a stale version",
+			op: func(db Database, version uint64) (uint64, error) {
+				return db.UpdateIfVersion("users", "1", Record{"name": "Carol"}, version+1)
+			},
+			want:    1,
+			wantErr: true,
+		},
+		{
+			name: "update a missing row",
+			op: func(db Database, version uint64) (uint64, error) {
+				return db.UpdateIfVersion("users", "9", Record{"name": "Carol"}, 1)
+			},
+			want:    1,
+			wantErr: true,
+		},
+		{
+			name: "delete at the current version",
+			op: func(db Database, version uint64) (uint64, error) {
+				return 0, db.DeleteIfVersion("users", "1", version)
+			},
+		},
+		{
+			name: "delete at a stale version",
+			op: func(db Database, version uint64) (uint64, error) {
+				return 0, db.DeleteIfVersion("users", "1", version+1)
+			},
+			want:    1,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewInMemoryDB()
+			mustSucceed(t, db.CreateTable("users", map[string]string{"name": "string"}))
+			mustSucceed(t, db.Insert("users", "1", Record{"name": "Alice"}))
+			_, version, err := db.Get("users", "1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := tt.op(db, version)
+			if tt.wantErr {
+				var conflict *VersionConflictError
+				if !errors.Is(err, ErrVersionConflict) || !errors.As(err, &conflict) {
+					t.Fatalf("error = %v, want a version conflict", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == 0 {
+				if _, _, err := db.Get("users", "1"); !errors.Is(err, ErrRecordNotFound) {
+					t.Errorf("Get() error = %v, want %v", err, ErrRecordNotFound)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("version = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,337 @@
+package inmemorydb
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Aggregate is a column of a materialized view computed over each group.
+// Supported functions are COUNT, SUM and AVG, which can all be maintained
+// incrementally as rows come and go.
+type Aggregate struct {
+	Function  string
+	Attribute string // Ignored for COUNT
+	Alias     string
+}
+
+// ViewQuery is the filtered aggregate query behind a materialized view. Rows
+// of Table matching the conditions are grouped by the GroupBy columns; without
+// GroupBy the view has a single row. Conditions may only use attributes, not
+// expressions, so the query can be replicated and backed up.
+type ViewQuery struct {
+	Table           string
+	Conditions      []Condition
+	LogicalOperator string
+	GroupBy         []string
+	Aggregates      []Aggregate
+}
+
+// materializedView keeps the running totals behind the rows of a view table.
+type materializedView struct {
+	query  ViewQuery
+	table  *Table
+	groups map[string]*viewGroup // Row key -> Group
+}
+
+// viewGroup holds the running totals of one group. Float sums are kept as
+// exact rationals, so that removing a row takes away exactly what adding it
+// put in and the sum does not drift however many rows come and go.
+type viewGroup struct {
+	values []interface{} // Values of the GroupBy columns
+	count  int
+	sums   []interface{} // Running sum per aggregate, nil for COUNT
+}
+
+// CreateMaterializedView stores the result of query as the read-only table
+// name. The view is computed once and then updated on every insert and delete
+// to the source table, inside the scope of that write.
+func (db *InMemoryDB) CreateMaterializedView(name string, query ViewQuery) error {
+	source, err := db.getTable(query.Table)
+	if err != nil {
+		return err
+	}
+	// The source lock is taken before dbLock, which is only safe while
+	// holding scopeLock.
+	db.scopeLock.Lock()
+	defer db.scopeLock.Unlock()
+	source.lockData()
+	defer source.dataLock.Unlock()
+	view, err := newMaterializedView(name, source, query)
+	if err != nil {
+		return err
+	}
+
+	db.dbLock.Lock()
+	defer db.dbLock.Unlock()
+	if _, exists := db.tables[name]; exists {
+		return tableExists(name)
+	}
+	db.tables[name] = view.table
+	source.views = append(source.views, view)
+	db.emit(Mutation{Type: MutationCreateView, Table: name, View: &view.query})
+	return nil
+}
+
+// newMaterializedView computes the view name over the rows of source. The
+// view is not attached to source yet. The caller must hold the source's
+// dataLock or own the table exclusively.
+func newMaterializedView(name string, source *Table, query ViewQuery) (*materializedView, error) {
+	if source.view != nil {
+		return nil, fmt.Errorf("%w: cannot create a view over %s", ErrReadOnlyTable, source.name)
+	}
+	if query.LogicalOperator == "" {
+		query.LogicalOperator = "AND"
+	}
+	schema, err := viewSchema(source, query)
+	if err != nil {
+		return nil, err
+	}
+	view := &materializedView{
+		query:  query,
+		groups: make(map[string]*viewGroup),
+		table: &Table{
+			name:     name,
+			schema:   schema,
+			data:     make(map[string]Record),
+			versions: make(map[string]uint64),
+			indexes:  make(map[string]*index),
+		},
+	}
+	view.table.view = view
+	if len(query.GroupBy) == 0 {
+		view.reset(view.group("", nil))
+	}
+	for _, record := range source.data {
+		matched, err := evaluateConditions(record, query.Conditions, query.LogicalOperator)
+		if err != nil {
+			return nil, err
+		}
+		if !matched {
+			continue
+		}
+		if err := view.apply(record, 1); err != nil {
+			return nil, err
+		}
+	}
+	return view, nil
+}
+
+func viewSchema(source *Table, query ViewQuery) (map[string]string, error) {
+	if len(query.Aggregates) == 0 {
+		return nil, fmt.Errorf("materialized view needs at least one aggregate")
+	}
+	for _, condition := range query.Conditions {
+		if condition.Expr != nil {
+			return nil, fmt.Errorf("materialized view conditions cannot use expressions")
+		}
+	}
+
+	schema := make(map[string]string)
+	for _, column := range query.GroupBy {
+		dataType, exists := source.schema[column]
+		if !exists {
+			return nil, &SchemaError{Table: source.name, Column: column}
+		}
+		schema[column] = dataType
+	}
+	for _, aggregate := range query.Aggregates {
+		if aggregate.Alias == "" {
+			return nil, fmt.Errorf("%s aggregate needs an alias", aggregate.Function)
+		}
+		if _, exists := schema[aggregate.Alias]; exists {
+			return nil, fmt.Errorf("duplicate view column %s", aggregate.Alias)
+		}
+		switch strings.ToUpper(aggregate.Function) {
+		case "COUNT":
+			schema[aggregate.Alias] = "int"
+		case "SUM", "AVG":
+			dataType := source.schema[aggregate.Attribute]
+			switch dataType {
+			case "int", "int64", "float64", TypeDecimal, TypeDuration:
+			default:
+				return nil, fmt.Errorf("cannot %s column %s of type %q", aggregate.Function, aggregate.Attribute, dataType)
+			}
+			if strings.EqualFold(aggregate.Function, "AVG") {
+				dataType = "float64"
+			}
+			schema[aggregate.Alias] = dataType
+		default:
+			return nil, fmt.Errorf("unsupported aggregate %s", aggregate.Function)
+		}
+	}
+	return schema, nil
+}
+
+// maintainViews updates the views of table for a row changing from old to
+// new. Either may be nil. The view changes are undone with the scope but not
+// emitted, since followers maintain their own views.
+func (s *writeScope) maintainViews(table *Table, old, new Record) error {
+	for _, view := range table.views {
+		if err := s.lock(view.table); err != nil {
+			return err
+		}
+		for _, change := range []struct {
+			record Record
+			sign   int
+		}{{old, -1}, {new, 1}} {
+			if change.record == nil {
+				continue
+			}
+			matched, err := evaluateConditions(change.record, view.query.Conditions, view.query.LogicalOperator)
+			if err != nil {
+				return err
+			}
+			if !matched {
+				continue
+			}
+			if err := view.apply(change.record, change.sign); err != nil {
+				return err
+			}
+			record, sign := change.record, change.sign
+			s.undo = append(s.undo, func() error { return view.apply(record, -sign) })
+		}
+	}
+	return nil
+}
+
+// apply adds (sign 1) or removes (sign -1) a source row that matches the query
+// and rewrites the affected view row. The caller must hold the view table's
+// dataLock.
+func (v *materializedView) apply(record Record, sign int) error {
+	values := make([]interface{}, len(v.query.GroupBy))
+	for i, column := range v.query.GroupBy {
+		values[i] = indexKey(record[column])
+	}
+	key := groupKey(values)
+
+	group := v.group(key, values)
+	group.count += sign
+	for i, aggregate := range v.query.Aggregates {
+		if strings.EqualFold(aggregate.Function, "COUNT") {
+			continue
+		}
+		sum, err := addSigned(group.sums[i], record[aggregate.Attribute], sign)
+		if err != nil {
+			return err
+		}
+		group.sums[i] = sum
+	}
+
+	if group.count <= 0 {
+		if len(v.query.GroupBy) == 0 {
+			v.reset(group)
+			return nil
+		}
+		delete(v.groups, key)
+		v.table.remove(key)
+		return nil
+	}
+	v.table.put(key, v.row(group), 0)
+	return nil
+}
+
+// groupKey returns the key of the view row of the group with the given
+// values. Each value is written with its type and prefixed with its length,
+// so that no two groups share a key: ("x|y", "z") differs from ("x", "y|z"),
+// and 1 from "1".
+func groupKey(values []interface{}) string {
+	var sb strings.Builder
+	for _, value := range values {
+		part := fmt.Sprintf("%T:%v", value, value)
+		fmt.Fprintf(&sb, "%d:%s", len(part), part)
+	}
+	return sb.String()
+}
+
+// group returns the group stored under key, creating it if needed.
+func (v *materializedView) group(key string, values []interface{}) *viewGroup {
+	group, exists := v.groups[key]
+	if !exists {
+		group = &viewGroup{values: values, sums: make([]interface{}, len(v.query.Aggregates))}
+		v.groups[key] = group
+	}
+	return group
+}
+
+// reset empties the single group of a view without GroupBy. Like SQL
+// aggregates over no rows, its row stays, with a COUNT of 0 and nil sums and
+// averages.
+func (v *materializedView) reset(group *viewGroup) {
+	group.count = 0
+	clear(group.sums)
+	v.table.put("", v.row(group), 0)
+}
+
+func (v *materializedView) row(group *viewGroup) Record {
+	row := make(Record, len(v.query.GroupBy)+len(v.query.Aggregates))
+	for i, column := range v.query.GroupBy {
+		row[column] = group.values[i]
+	}
+	for i, aggregate := range v.query.Aggregates {
+		switch strings.ToUpper(aggregate.Function) {
+		case "COUNT":
+			row[aggregate.Alias] = group.count
+		case "SUM":
+			row[aggregate.Alias] = group.sums[i]
+			if exact, ok := group.sums[i].(*big.Rat); ok {
+				row[aggregate.Alias], _ = exact.Float64()
+			}
+		case "AVG":
+			if group.count == 0 {
+				row[aggregate.Alias] = nil
+				continue
+			}
+			var sum float64
+			switch s := group.sums[i].(type) {
+			case *big.Rat:
+				sum, _ = s.Float64()
+			case time.Duration:
+				sum = float64(s)
+			default:
+				sum, _ = convertToFloat(s)
+			}
+			row[aggregate.Alias] = sum / float64(group.count)
+		}
+	}
+	return row
+}
+
+// addSigned returns sum + sign*value, keeping the type of value, except that
+// float64 values are summed exactly as a *big.Rat.
+func addSigned(sum, value interface{}, sign int) (interface{}, error) {
+	switch v := value.(type) {
+	case int:
+		s, _ := sum.(int)
+		return s + sign*v, nil
+	case int64:
+		s, _ := sum.(int64)
+		return s + int64(sign)*v, nil
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return nil, fmt.Errorf("cannot sum non-finite value %v", v)
+		}
+		s, _ := sum.(*big.Rat)
+		if s == nil {
+			s = new(big.Rat)
+		}
+		r := new(big.Rat).SetFloat64(v)
+		if sign < 0 {
+			return r.Sub(s, r), nil
+		}
+		return r.Add(s, r), nil
+	case Decimal:
+		s, _ := sum.(Decimal)
+		if sign < 0 {
+			return s.Sub(v)
+		}
+		return s.Add(v)
+	case time.Duration:
+		s, _ := sum.(time.Duration)
+		return s + time.Duration(sign)*v, nil
+	default:
+		return nil, fmt.Errorf("cannot sum value of type %T", value)
+	}
+}
@@ -0,0 +1,205 @@
+package inmemorydb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestMaterializedViewRows(t *testing.T) {
+	ungrouped := ViewQuery{
+		Table: "orders",
+		Aggregates: []Aggregate{
+			{Function: "COUNT", Alias: "orders"},
+			{Function: "SUM", Attribute: "amount", Alias: "total"},
+			{Function: "AVG", Attribute: "amount", Alias: "average"},
+		},
+	}
+	grouped := ungrouped
+	grouped.GroupBy = []string{"city"}
+	filtered := ungrouped
+	filtered.Conditions = []Condition{{Attribute: "amount", Operator: ">", Value: 15}}
+
+	tests := []struct {
+		name   string
+		query  ViewQuery
+		insert []string // Keys of orders inserted before the view is created
+		later  []string // Keys inserted after
+		delete []string // Keys deleted after
+		want   map[string]Record
+	}{
+		{
+			name:  "ungrouped over an empty table",
+			query: ungrouped,
+			want:  map[string]Record{"": {"orders": 0, "total": nil, "average": nil}},
+		},
+		{
+			name:   "ungrouped",
+			query:  ungrouped,
+			insert: []string{"1", "2", "3"},
+			want:   map[string]Record{"": {"orders": 3, "total": 60, "average": 20.0}},
+		},
+		{
+			name:   "ungrouped after deleting every row",
+			query:  ungrouped,
+			insert: []string{"1", "2", "3"},
+			delete: []string{"1", "2", "3"},
+			want:   map[string]Record{"": {"orders": 0, "total": nil, "average": nil}},
+		},
+		{
+			name:  "grouped over an empty table",
+			query: grouped,
+			want:  map[string]Record{},
+		},
+		{
+			name:   "grouped after deleting a group's rows",
+			query:  grouped,
+			insert: []string{"1", "2", "3"},
+			delete: []string{"1", "3"},
+			want:   map[string]Record{groupKey([]interface{}{"Mumbai"}): {"city": "Mumbai", "orders": 1, "total": 20, "average": 20.0}},
+		},
+		{
+			name:   "filtered rows inserted before and after",
+			query:  filtered,
+			insert: []string{"1", "2"},
+			later:  []string{"3"},
+			want:   map[string]Record{"": {"orders": 2, "total": 50, "average": 25.0}},
+		},
+	}
+
+	orders := map[string]Record{
+		"1": {"city": "Pune", "amount": 10},
+		"2": {"city": "Mumbai", "amount": 20},
+		"3": {"city": "Pune", "amount": 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewInMemoryDB().(*InMemoryDB)
+			mustSucceed(t, db.CreateTable("orders", map[string]string{"city": "string", "amount": "int"}))
+			for _, key := range tt.insert {
+				mustSucceed(t, db.Insert("orders", key, orders[key]))
+			}
+			mustSucceed(t, db.CreateMaterializedView("totals", tt.query))
+			for _, key := range tt.later {
+				mustSucceed(t, db.Insert("orders", key, orders[key]))
+			}
+			for _, key := range tt.delete {
+				mustSucceed(t, db.Delete("orders", key))
+			}
+
+			view, err := db.getTable("totals")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := fmt.Sprint(view.data), fmt.Sprint(tt.want); got != want {
+				t.Errorf("view rows = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestMaterializedViewGroupKeys(t *testing.T) {
+	tests := []struct {
+		name        string
+		left, right []interface{}
+	}{
+		{name: "separator inside a value", left: []interface{}{"x|y", "z"}, right: []interface{}{"x", "y|z"}},
+		{name: "length prefix inside a value", left: []interface{}{"8:string:", "a"}, right: []interface{}{"", "a"}},
+		{name: "int and string", left: []interface{}{1}, right: []interface{}{"1"}},
+		{name: "nil and string", left: []interface{}{nil}, right: []interface{}{"<nil>"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if groupKey(tt.left) == groupKey(tt.right) {
+				t.Errorf("%v and %v share the key %q", tt.left, tt.right, groupKey(tt.left))
+			}
+		})
+	}
+
+	t.Run("rows with the separator in their values", func(t *testing.T) {
+		db := NewInMemoryDB()
+		mustSucceed(t, db.CreateTable("orders", map[string]string{"from": "string", "to": "string"}))
+		mustSucceed(t, db.Insert("orders", "1", Record{"from": "x|y", "to": "z"}))
+		mustSucceed(t, db.Insert("orders", "2", Record{"from": "x", "to": "y|z"}))
+		mustSucceed(t, db.CreateMaterializedView("routes", ViewQuery{
+			Table:      "orders",
+			GroupBy:    []string{"from", "to"},
+			Aggregates: []Aggregate{{Function: "COUNT", Alias: "orders"}},
+		}))
+
+		rows, err := db.SelectWithConditions("routes", []string{"from", "to", "orders"}, nil, "AND")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 2 || rows[0]["orders"] != 1 || rows[1]["orders"] != 1 {
+			t.Errorf("rows = %v, want one row per route", rows)
+		}
+	})
+}
+
+func TestMaterializedViewFloatSums(t *testing.T) {
+	tests := []struct {
+		name    string
+		insert  []float64
+		delete  int // Rows deleted after, starting with the first
+		total   interface{}
+		average interface{}
+		wantErr bool
+	}{
+		{name: "small next to large", insert: []float64{1e16, 1}, delete: 1, total: 1.0, average: 1.0},
+		{name: "tenths", insert: []float64{0.1, 0.2, 0.3}, delete: 2, total: 0.3, average: 0.3},
+		{name: "everything deleted", insert: []float64{0.1, 0.7}, delete: 2, total: nil, average: nil},
+		{name: "infinity", insert: []float64{1, math.Inf(1)}, total: 1.0, average: 1.0, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := NewInMemoryDB()
+			mustSucceed(t, db.CreateTable("payments", map[string]string{"amount": "float64"}))
+			mustSucceed(t, db.CreateMaterializedView("totals", ViewQuery{
+				Table: "payments",
+				Aggregates: []Aggregate{
+					{Function: "SUM", Attribute: "amount", Alias: "total"},
+					{Function: "AVG", Attribute: "amount", Alias: "average"},
+				},
+			}))
+			var err error
+			for i, amount := range tt.insert {
+				if err = db.Insert("payments", fmt.Sprint(i), Record{"amount": amount}); err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Insert() error = %v, want an error: %t", err, tt.wantErr)
+			}
+			for i := 0; i < tt.delete; i++ {
+				mustSucceed(t, db.Delete("payments", fmt.Sprint(i)))
+			}
+
+			row, _, err := db.Get("totals", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if row["total"] != tt.total || row["average"] != tt.average {
+				t.Errorf("total, average = %v, %v, want %v, %v", row["total"], row["average"], tt.total, tt.average)
+			}
+		})
+	}
+}
+
+func TestRollbackErrors(t *testing.T) {
+	errWrite, errUndo := errors.New("write failed"), errors.New("undo failed")
+	db := NewInMemoryDB().(*InMemoryDB)
+	mustSucceed(t, db.CreateTable("orders", map[string]string{"amount": "int"}))
+
+	err := db.write(context.Background(), "orders", func(s *writeScope, table *Table) error {
+		s.undo = append(s.undo, func() error { return errUndo })
+		return errWrite
+	})
+	if !errors.Is(err, errWrite) || !errors.Is(err, errUndo) {
+		t.Errorf("error = %v, want both the write's and the rollback's error", err)
+	}
+}
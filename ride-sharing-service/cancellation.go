@@ -0,0 +1,288 @@
+package ridesharingservice
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Errors returned when cancelling rides
+var (
+	ErrInvalidReason  = errors.New("cancellation reason does not apply")
+	ErrNoShowTooEarly = errors.New("driver has not waited long enough to report a no-show")
+)
+
+// CancelledBy tells who cancelled a ride.
+type CancelledBy int
+
+const (
+	CancelledByPassenger CancelledBy = iota
+	CancelledByDriver
+)
+
+func (c CancelledBy) String() string {
+	if c == CancelledByDriver {
+		return "driver"
+	}
+	return "passenger"
+}
+
+// CancellationReason is the reason code given for a cancellation.
+type CancellationReason string
+
+// Reasons a passenger may give
+const (
+	ReasonChangeOfPlans   CancellationReason = "change_of_plans"
+	ReasonDriverTooFar    CancellationReason = "driver_too_far"
+	ReasonBookedByMistake CancellationReason = "booked_by_mistake"
+)
+
+// Reasons a driver may give
+const (
+	ReasonPassengerNoShow CancellationReason = "passenger_no_show"
+	ReasonVehicleProblem  CancellationReason = "vehicle_problem"
+	ReasonUnsafePickup    CancellationReason = "unsafe_pickup"
+)
+
+// ReasonOther may be given by either side.
+const ReasonOther CancellationReason = "other"
+
+var cancellationReasons = map[CancelledBy][]CancellationReason{
+	CancelledByPassenger: {ReasonChangeOfPlans, ReasonDriverTooFar, ReasonBookedByMistake, ReasonOther},
+	CancelledByDriver:    {ReasonPassengerNoShow, ReasonVehicleProblem, ReasonUnsafePickup, ReasonOther},
+}
+
+func (r CancellationReason) validFor(by CancelledBy) error {
+	for _, reason := range cancellationReasons[by] {
+		if reason == r {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q for the %s", ErrInvalidReason, r, by)
+}
+
+// CancellationPolicy sets what cancelling a ride costs. Fees charged to a
+// passenger are paid to the driver, for the trip to the pickup; penalties
+// charged to a driver go to the platform.
+type CancellationPolicy struct {
+	FreeWindow    time.Duration // Passengers cancel free this long after a driver accepts
+	LateFee       Money         // Charged to a passenger cancelling after the free window
+	NoShowWait    time.Duration // How long a driver waits at the pickup before reporting a no-show
+	NoShowFee     Money         // Charged to a passenger who does not show up
+	DriverPenalty Money         // Charged to a driver for each cancellation beyond the free ones
+
+	DriverFreeCancellations int           // Cancellations within PenaltyWindow a driver makes without penalty
+	PenaltyWindow           time.Duration // Older cancellations no longer count towards penalties
+}
+
+func DefaultCancellationPolicy() CancellationPolicy {
+	return CancellationPolicy{
+		FreeWindow:              2 * time.Minute,
+		LateFee:                 5_00,
+		NoShowWait:              5 * time.Minute,
+		NoShowFee:               10_00,
+		DriverPenalty:           5_00,
+		DriverFreeCancellations: 1,
+		PenaltyWindow:           24 * time.Hour,
+	}
+}
+
+// Cancellation records who cancelled a ride, why and what it cost.
+type Cancellation struct {
+	By      CancelledBy
+	Reason  CancellationReason
+	Fee     Money // Charged to the passenger
+	Penalty Money // Charged to the driver
+}
+
+// DriverCancellations sums up the rides a driver cancelled.
+type DriverCancellations struct {
+	Total     int   // Rides cancelled, no-shows excluded
+	Recent    int   // Of those, within the penalty window
+	Penalties Money // Penalties charged in total
+}
+
+// driverCancellations keeps the cancellations of one driver.
+type driverCancellations struct {
+	at        []time.Time // When the driver cancelled, oldest first
+	penalties Money
+}
+
+// PassengerCancelRide cancels a ride on behalf of its passenger, before it
+// starts. Cancelling is free until FreeWindow after a driver accepts; later,
+// the passenger pays the late fee.
+func (rc *RideCoordinator) PassengerCancelRide(passenger *Passenger, ride *Ride, reason CancellationReason) error {
+	return rc.cancelByPassenger(passenger, ride, reason, nil)
+}
+
+func (rc *RideCoordinator) cancelByPassenger(passenger *Passenger, ride *Ride, reason CancellationReason, check func() error) error {
+	if err := reason.validFor(CancelledByPassenger); err != nil {
+		return err
+	}
+
+	// Hold the scheduler and dispatch locks so the ride cannot be dispatched
+	// or accepted while it is cancelled
+	rc.scheduleMu.Lock()
+	rc.dispatchMu.Lock()
+	now := rc.Clock.Now()
+	var driver *Driver
+	err := rc.rideService.transition(ride, Cancelled, now, func() error {
+		if check != nil {
+			if err := check(); err != nil {
+				return err
+			}
+		}
+		if ride.Passenger != passenger {
+			return fmt.Errorf("%w: %s, ride %d", ErrWrongPassenger, passenger.Name, ride.ID)
+		}
+		cancellation := &Cancellation{By: CancelledByPassenger, Reason: reason}
+		if ride.Status == Accepted && now.Sub(ride.AcceptedAt) > rc.CancellationPolicy.FreeWindow {
+			cancellation.Fee = rc.CancellationPolicy.LateFee
+		}
+		ride.Cancellation = cancellation
+		driver = ride.Driver
+		return nil
+	})
+	if err == nil {
+		if entry := rc.scheduled[ride.ID]; entry != nil {
+			entry.timer.Stop()
+			delete(rc.scheduled, ride.ID)
+		}
+		rc.endDispatch(ride)
+	}
+	rc.dispatchMu.Unlock()
+	rc.scheduleMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	fee := ride.Cancellation.Fee
+	if driver == nil {
+		rc.notificationService.NotifyPassenger(passenger, fmt.Sprintf("Ride %d cancelled free of charge", ride.ID))
+		return nil
+	}
+	rc.releaseDriver(driver, ride)
+	rc.notificationService.NotifyDriver(driver, fmt.Sprintf("Ride %d was cancelled by the passenger", ride.ID))
+	if err := rc.paymentService.SettleCancellation(ride, driver, fee, "Late cancellation fee", now); err != nil {
+		rc.notifyPaymentFailed(ride, err)
+		return nil
+	}
+	if fee > 0 {
+		rc.notificationService.NotifyPassenger(passenger, fmt.Sprintf("Ride %d cancelled. Late cancellation fee: %s", ride.ID, fee))
+		rc.notificationService.NotifyDriver(driver, fmt.Sprintf("You receive the late cancellation fee of %s for ride %d", fee, ride.ID))
+	} else {
+		rc.notificationService.NotifyPassenger(passenger, fmt.Sprintf("Ride %d cancelled free of charge", ride.ID))
+	}
+	return nil
+}
+
+// endDispatch withdraws the offers of a cancelled ride. The caller must hold
+// dispatchMu.
+func (rc *RideCoordinator) endDispatch(ride *Ride) {
+	d, exists := rc.dispatches[ride.ID]
+	if !exists {
+		return
+	}
+	d.timer.Stop()
+	delete(rc.dispatches, ride.ID)
+	rc.resolveDemand(ride)
+	for _, driver := range d.pending {
+		rc.notificationService.NotifyDriver(driver, fmt.Sprintf("Ride %d was cancelled by the passenger", ride.ID))
+	}
+}
+
+// DriverCancelRide cancels a ride on behalf of its driver, before it starts.
+// A driver reporting a passenger no-show must have waited NoShowWait at the
+// pickup, and the passenger pays the no-show fee. For any other reason, the
+// driver pays a penalty once they have used up their free cancellations.
+func (rc *RideCoordinator) DriverCancelRide(driver *Driver, ride *Ride, reason CancellationReason) error {
+	if err := reason.validFor(CancelledByDriver); err != nil {
+		return err
+	}
+	now := rc.Clock.Now()
+	err := rc.rideService.transition(ride, Cancelled, now, func() error {
+		if ride.Driver != driver {
+			return fmt.Errorf("%w: %s, ride %d", ErrWrongDriver, driver.Name, ride.ID)
+		}
+		cancellation := &Cancellation{By: CancelledByDriver, Reason: reason}
+		if reason == ReasonPassengerNoShow {
+			if ride.ArrivedAt.IsZero() || now.Sub(ride.ArrivedAt) < rc.CancellationPolicy.NoShowWait {
+				return fmt.Errorf("%w: ride %d", ErrNoShowTooEarly, ride.ID)
+			}
+			cancellation.Fee = rc.CancellationPolicy.NoShowFee
+		} else {
+			cancellation.Penalty = rc.recordDriverCancellation(driver, now)
+		}
+		ride.Cancellation = cancellation
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	rc.releaseDriver(driver, ride)
+
+	cancellation := ride.Cancellation
+	if err := rc.paymentService.SettleCancellation(ride, driver, cancellation.Fee, "No-show fee", now); err != nil {
+		rc.notifyPaymentFailed(ride, err)
+		return nil
+	}
+	if cancellation.Fee > 0 {
+		rc.notificationService.NotifyPassenger(ride.Passenger, fmt.Sprintf("Driver %s waited at the pickup but you did not show up. No-show fee: %s", driver.Name, cancellation.Fee))
+		rc.notificationService.NotifyDriver(driver, fmt.Sprintf("No-show recorded for ride %d. You receive %s", ride.ID, cancellation.Fee))
+		return nil
+	}
+	rc.notificationService.NotifyPassenger(ride.Passenger, fmt.Sprintf("Your ride has been cancelled. Driver: %s", driver.Name))
+	if cancellation.Penalty > 0 {
+		if err := rc.paymentService.ChargeDriver(driver, ride.ID, cancellation.Penalty, "Cancellation penalty", now); err != nil {
+			rc.notificationService.NotifyDriver(driver, fmt.Sprintf("Penalty for ride %d could not be charged: %v", ride.ID, err))
+			return nil
+		}
+		rc.notificationService.NotifyDriver(driver, fmt.Sprintf("You cancelled ride %d. Cancellation penalty: %s", ride.ID, cancellation.Penalty))
+	}
+	return nil
+}
+
+// recordDriverCancellation counts a cancellation against a driver and returns
+// the penalty it costs them.
+func (rc *RideCoordinator) recordDriverCancellation(driver *Driver, at time.Time) Money {
+	rc.cancelMu.Lock()
+	defer rc.cancelMu.Unlock()
+	record := rc.driverCancellations[driver.ID]
+	if record == nil {
+		record = &driverCancellations{}
+		rc.driverCancellations[driver.ID] = record
+	}
+	record.at = append(record.at, at)
+	if record.recent(at, rc.CancellationPolicy.PenaltyWindow) <= rc.CancellationPolicy.DriverFreeCancellations {
+		return 0
+	}
+	record.penalties += rc.CancellationPolicy.DriverPenalty
+	return rc.CancellationPolicy.DriverPenalty
+}
+
+// recent counts the cancellations within window of now.
+func (dc *driverCancellations) recent(now time.Time, window time.Duration) int {
+	count := 0
+	for _, at := range dc.at {
+		if now.Sub(at) <= window {
+			count++
+		}
+	}
+	return count
+}
+
+// DriverCancellations returns how many rides a driver cancelled and what it
+// cost them.
+func (rc *RideCoordinator) DriverCancellations(driver *Driver) DriverCancellations {
+	rc.cancelMu.Lock()
+	defer rc.cancelMu.Unlock()
+	record := rc.driverCancellations[driver.ID]
+	if record == nil {
+		return DriverCancellations{}
+	}
+	return DriverCancellations{
+		Total:     len(record.at),
+		Recent:    record.recent(rc.Clock.Now(), rc.CancellationPolicy.PenaltyWindow),
+		Penalties: record.penalties,
+	}
+}
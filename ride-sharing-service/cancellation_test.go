@@ -0,0 +1,117 @@
+package ridesharingservice
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPassengerCancellationFees(t *testing.T) {
+	tests := []struct {
+		name     string
+		accepted bool
+		after    time.Duration // Time since the driver accepted
+		reason   CancellationReason
+		fee      Money
+		err      error
+	}{
+		{name: "before a driver accepts", reason: ReasonChangeOfPlans},
+		{name: "within the free window", accepted: true, after: time.Minute, reason: ReasonDriverTooFar},
+		{name: "after the free window", accepted: true, after: 3 * time.Minute, reason: ReasonChangeOfPlans, fee: 5_00},
+		{name: "driver's reason", accepted: true, reason: ReasonPassengerNoShow, err: ErrInvalidReason},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestFixture(t)
+			driver := f.driver(1)
+			passenger := f.passenger(t, 1, PaymentMethod{Type: PayByWallet}, 50_00)
+			var ride *Ride
+			if tt.accepted {
+				ride = f.accepted(t, passenger, driver)
+			} else {
+				var err error
+				if ride, err = f.rc.RequestRide(passenger, testPickup, testDropOff); err != nil {
+					t.Fatal(err)
+				}
+			}
+			f.clock.Advance(tt.after)
+
+			if err := f.rc.PassengerCancelRide(passenger, ride, tt.reason); !errors.Is(err, tt.err) {
+				t.Fatalf("PassengerCancelRide() error = %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if ride.Cancellation.Fee != tt.fee {
+				t.Errorf("fee = %s, want %s", ride.Cancellation.Fee, tt.fee)
+			}
+			if got, want := f.pay.PassengerWallet(passenger).Balance, 50_00-tt.fee; got != want {
+				t.Errorf("wallet = %s, want %s", got, want)
+			}
+			if got := f.pay.DriverWallet(driver).Balance; got != tt.fee {
+				t.Errorf("driver wallet = %s, want %s", got, tt.fee)
+			}
+			if f.dispatching(ride) {
+				t.Error("cancelled ride is still offered to drivers")
+			}
+		})
+	}
+}
+
+func TestDriverCancellations(t *testing.T) {
+	tests := []struct {
+		name      string
+		reason    CancellationReason
+		waited    time.Duration // Time at the pickup, if the driver arrived
+		previous  int           // Rides the driver cancelled before, an hour ago
+		fee       Money
+		penalty   Money
+		err       error
+		penalties Money // Charged to the driver in total
+	}{
+		{name: "first cancellation is free", reason: ReasonVehicleProblem},
+		{name: "second cancellation is penalized", reason: ReasonVehicleProblem, previous: 1, penalty: 5_00, penalties: 5_00},
+		{name: "third cancellation is penalized too", reason: ReasonOther, previous: 2, penalty: 5_00, penalties: 10_00},
+		{name: "no-show after waiting", reason: ReasonPassengerNoShow, waited: 6 * time.Minute, fee: 10_00},
+		{name: "no-show too early", reason: ReasonPassengerNoShow, waited: time.Minute, err: ErrNoShowTooEarly},
+		{name: "passenger's reason", reason: ReasonChangeOfPlans, err: ErrInvalidReason},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestFixture(t)
+			driver := f.driver(1)
+			passenger := f.passenger(t, 1, PaymentMethod{Type: PayByWallet}, 50_00)
+			for i := 0; i < tt.previous; i++ {
+				if err := f.rc.DriverCancelRide(driver, f.accepted(t, passenger, driver), ReasonOther); err != nil {
+					t.Fatal(err)
+				}
+			}
+			f.clock.Advance(time.Hour)
+
+			ride := f.accepted(t, passenger, driver)
+			if tt.waited > 0 {
+				if err := f.rc.DriverArrived(driver, ride); err != nil {
+					t.Fatal(err)
+				}
+				f.clock.Advance(tt.waited)
+			}
+			if err := f.rc.DriverCancelRide(driver, ride, tt.reason); !errors.Is(err, tt.err) {
+				t.Fatalf("DriverCancelRide() error = %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if ride.Cancellation.Fee != tt.fee || ride.Cancellation.Penalty != tt.penalty {
+				t.Errorf("fee, penalty = %s, %s, want %s, %s", ride.Cancellation.Fee, ride.Cancellation.Penalty, tt.fee, tt.penalty)
+			}
+			if got := f.rc.DriverCancellations(driver).Penalties; got != tt.penalties {
+				t.Errorf("penalties = %s, want %s", got, tt.penalties)
+			}
+			if got, want := f.pay.DriverWallet(driver).Balance, tt.fee-tt.penalties; got != want {
+				t.Errorf("driver wallet = %s, want %s", got, want)
+			}
+		})
+	}
+}
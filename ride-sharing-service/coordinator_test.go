@@ -0,0 +1,86 @@
+package ridesharingservice
+
+import (
+	"testing"
+	"time"
+)
+
+// testStart is a Wednesday noon in San Francisco, outside the peak and night
+// bands.
+var testStart = time.Date(2024, time.May, 15, 19, 0, 0, 0, time.UTC)
+
+// Places in San Francisco, about 1.4 km apart
+var (
+	testPickup  = &Location{Latitude: 37.77, Longitude: -122.42}
+	testDropOff = &Location{Latitude: 37.78, Longitude: -122.43}
+)
+
+// testFixture is a coordinator on a manual clock with the default rate cards
+// and no surge pricing.
+type testFixture struct {
+	rc      *RideCoordinator
+	clock   *ManualClock
+	ledger  *Ledger
+	pay     *PaymentService
+	drivers *DriverService
+	rides   *RideService
+}
+
+func newTestFixture(t *testing.T) *testFixture {
+	t.Helper()
+	rateCards, err := DefaultRateCards()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &testFixture{
+		clock:   NewManualClock(testStart),
+		ledger:  NewLedger(),
+		drivers: NewDriverService(),
+		rides:   NewRideService(),
+	}
+	f.pay = NewPaymentService(f.ledger, NewStubCardGateway())
+	f.rc = NewRideCoordinator(NewPassengerService(), f.drivers, f.rides, &NotificationService{}, rateCards, f.pay)
+	f.rc.Clock = f.clock
+	return f
+}
+
+// passenger adds a passenger paying by method with balance in their wallet.
+func (f *testFixture) passenger(t *testing.T, id int, method PaymentMethod, balance Money) *Passenger {
+	t.Helper()
+	passenger := &Passenger{ID: id, Name: "passenger", Location: testPickup, PaymentMethod: method}
+	f.rc.passengerService.AddPassenger(passenger)
+	if balance > 0 {
+		if err := f.pay.TopUp(passenger, "tok_visa", balance, f.clock.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return passenger
+}
+
+// driver adds an available driver at the pickup.
+func (f *testFixture) driver(id int) *Driver {
+	driver := &Driver{ID: id, Name: "driver", Location: testPickup, Status: Available}
+	f.drivers.AddDriver(driver)
+	return driver
+}
+
+// accepted requests a ride and has driver accept it.
+func (f *testFixture) accepted(t *testing.T, passenger *Passenger, driver *Driver) *Ride {
+	t.Helper()
+	ride, err := f.rc.RequestRide(passenger, testPickup, testDropOff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.rc.AcceptRide(driver, ride); err != nil {
+		t.Fatal(err)
+	}
+	return ride
+}
+
+// dispatching tells whether ride is being offered to drivers.
+func (f *testFixture) dispatching(ride *Ride) bool {
+	f.rc.dispatchMu.Lock()
+	defer f.rc.dispatchMu.Unlock()
+	_, exists := f.rc.dispatches[ride.ID]
+	return exists
+}
@@ -0,0 +1,160 @@
+package ridesharingservice
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErrNoOffer is returned when a driver answers a ride they hold no open offer
+// for, for example because another driver accepted it first or the offer
+// timed out.
+var ErrNoOffer = errors.New("no open offer for the ride")
+
+// Defaults for dispatching a ride to drivers
+const (
+	DefaultOfferTimeout  = 15 * time.Second
+	DefaultMaxOfferWaves = 3
+)
+
+// dispatch tracks the offers of a requested ride. Drivers are offered the
+// ride in waves of the nearest drivers not offered it before; a wave ends when
+// all of its drivers decline or its timeout fires, and the next wave starts.
+type dispatch struct {
+	ride    *Ride
+	wave    int
+	offered map[int]bool    // Driver IDs offered the ride in any wave
+	pending map[int]*Driver // Driver ID -> Driver yet to answer the current wave
+	timer   Timer
+}
+
+// startDispatch offers a newly requested ride to the first wave of drivers.
+// A ride cancelled since it was requested is not offered; it only stops
+// counting as demand.
+func (rc *RideCoordinator) startDispatch(ride *Ride) {
+	rc.dispatchMu.Lock()
+	defer rc.dispatchMu.Unlock()
+	if status, err := rc.rideService.GetRideStatus(ride.ID); err != nil || status != Requested {
+		rc.resolveDemand(ride)
+		return
+	}
+	d := &dispatch{ride: ride, offered: make(map[int]bool), pending: make(map[int]*Driver)}
+	rc.dispatches[ride.ID] = d
+	rc.nextWave(d)
+}
+
+// nextWave offers the ride to the next drivers, or gives up once the waves
+// are used up. If nobody is in range, the wave still waits for its timeout so
+// drivers who free up or move closer can be offered the ride later. The caller
+// must hold dispatchMu.
+func (rc *RideCoordinator) nextWave(d *dispatch) {
+	d.wave++
+	if d.wave > rc.MaxOfferWaves {
+		rc.giveUp(d)
+		return
+	}
+
+	// Pooled rides go to drivers whose route they fit before anyone else
+	source := d.ride.Source
+	candidates := rc.driverService.FindNearestDrivers(source, rc.MatchRadiusKm, 0)
+	if d.ride.Pool {
+		candidates = append(rc.poolCandidates(d.ride), candidates...)
+	}
+	for _, driver := range candidates {
+		if len(d.pending) == rc.MatchLimit {
+			break
+		}
+		if d.offered[driver.ID] || driver.VehicleClass.orEconomy() != d.ride.VehicleClass {
+			continue
+		}
+		d.offered[driver.ID] = true
+		d.pending[driver.ID] = driver
+		distance := source.DistanceTo(rc.driverService.location(driver))
+		rc.notificationService.NotifyDriver(driver, fmt.Sprintf("New ride request %d, pickup %.1f km away", d.ride.ID, distance))
+	}
+
+	rideID, wave := d.ride.ID, d.wave
+	d.timer = rc.Clock.AfterFunc(rc.OfferTimeout, func() { rc.offerTimedOut(rideID, wave) })
+}
+
+// offerTimedOut withdraws the unanswered offers of a wave and moves on.
+func (rc *RideCoordinator) offerTimedOut(rideID int, wave int) {
+	rc.dispatchMu.Lock()
+	defer rc.dispatchMu.Unlock()
+	d, exists := rc.dispatches[rideID]
+	if !exists || d.wave != wave {
+		return
+	}
+	for id, driver := range d.pending {
+		delete(d.pending, id)
+		rc.notificationService.NotifyDriver(driver, fmt.Sprintf("Offer for ride %d expired", rideID))
+	}
+	rc.nextWave(d)
+}
+
+// giveUp ends the dispatch of a ride that no driver accepted. The caller must
+// hold dispatchMu.
+func (rc *RideCoordinator) giveUp(d *dispatch) {
+	delete(rc.dispatches, d.ride.ID)
+	rc.resolveDemand(d.ride)
+	if err := rc.rideService.transition(d.ride, Unmatched, rc.Clock.Now(), nil); err != nil {
+		return
+	}
+	rc.notificationService.NotifyPassenger(d.ride.Passenger, "No drivers available, please try again later")
+}
+
+// claimOffer runs accept if driver holds an open offer for ride, and ends the
+// dispatch if it succeeds. Holding dispatchMu throughout makes the first
+// driver to accept win; everyone else finds the offer gone.
+func (rc *RideCoordinator) claimOffer(driver *Driver, ride *Ride, accept func() error) error {
+	rc.dispatchMu.Lock()
+	defer rc.dispatchMu.Unlock()
+	d, exists := rc.dispatches[ride.ID]
+	if !exists || d.pending[driver.ID] == nil {
+		return fmt.Errorf("%w: %s, ride %d", ErrNoOffer, driver.Name, ride.ID)
+	}
+	if err := accept(); err != nil {
+		return err
+	}
+	d.timer.Stop()
+	delete(rc.dispatches, ride.ID)
+	rc.resolveDemand(ride)
+	for id, other := range d.pending {
+		if id != driver.ID {
+			rc.notificationService.NotifyDriver(other, fmt.Sprintf("Ride %d was taken by another driver", ride.ID))
+		}
+	}
+	return nil
+}
+
+// DeclineRide turns down an offer. Once every driver of the wave has declined,
+// the ride is offered to the next wave without waiting for the timeout.
+func (rc *RideCoordinator) DeclineRide(driver *Driver, ride *Ride) error {
+	rc.dispatchMu.Lock()
+	defer rc.dispatchMu.Unlock()
+	d, exists := rc.dispatches[ride.ID]
+	if !exists || d.pending[driver.ID] == nil {
+		return fmt.Errorf("%w: %s, ride %d", ErrNoOffer, driver.Name, ride.ID)
+	}
+	delete(d.pending, driver.ID)
+	if len(d.pending) == 0 {
+		d.timer.Stop()
+		rc.nextWave(d)
+	}
+	return nil
+}
+
+// PendingOffers returns the rides a driver has been offered and not answered.
+func (rc *RideCoordinator) PendingOffers(driver *Driver) []*Ride {
+	rc.dispatchMu.Lock()
+	defer rc.dispatchMu.Unlock()
+	var rides []*Ride
+	for _, d := range rc.dispatches {
+		if d.pending[driver.ID] != nil {
+			rides = append(rides, d.ride)
+		}
+	}
+	sort.Slice(rides, func(i, j int) bool { return rides[i].ID < rides[j].ID })
+	return rides
+}
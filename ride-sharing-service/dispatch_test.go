@@ -0,0 +1,134 @@
+package ridesharingservice
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestDispatchWaves(t *testing.T) {
+	tests := []struct {
+		name    string
+		events  func(t *testing.T, f *testFixture, drivers []*Driver, ride *Ride) error // The last event's error is checked
+		err     error
+		offered []int // IDs of the drivers holding an offer afterwards
+		status  RideStatus
+	}{
+		{
+			name:    "first wave goes to the nearest driver",
+			events:  func(t *testing.T, f *testFixture, drivers []*Driver, ride *Ride) error { return nil },
+			offered: []int{1},
+			status:  Requested,
+		},
+		{
+			name: "timeout moves on to the next driver",
+			events: func(t *testing.T, f *testFixture, drivers []*Driver, ride *Ride) error {
+				f.clock.Advance(f.rc.OfferTimeout)
+				return nil
+			},
+			offered: []int{2},
+			status:  Requested,
+		},
+		{
+			name: "decline moves on without waiting",
+			events: func(t *testing.T, f *testFixture, drivers []*Driver, ride *Ride) error {
+				return f.rc.DeclineRide(drivers[0], ride)
+			},
+			offered: []int{2},
+			status:  Requested,
+		},
+		{
+			name: "decline an offer twice",
+			events: func(t *testing.T, f *testFixture, drivers []*Driver, ride *Ride) error {
+				mustStep(t, f.rc.DeclineRide(drivers[0], ride))
+				return f.rc.DeclineRide(drivers[0], ride)
+			},
+			err:     ErrNoOffer,
+			offered: []int{2},
+			status:  Requested,
+		},
+		{
+			name: "accept after the offer expired",
+			events: func(t *testing.T, f *testFixture, drivers []*Driver, ride *Ride) error {
+				f.clock.Advance(f.rc.OfferTimeout)
+				return f.rc.AcceptRide(drivers[0], ride)
+			},
+			err:     ErrNoOffer,
+			offered: []int{2},
+			status:  Requested,
+		},
+		{
+			name: "accept in a later wave",
+			events: func(t *testing.T, f *testFixture, drivers []*Driver, ride *Ride) error {
+				mustStep(t, f.rc.DeclineRide(drivers[0], ride))
+				return f.rc.AcceptRide(drivers[1], ride)
+			},
+			status: Accepted,
+		},
+		{
+			name: "waves used up",
+			events: func(t *testing.T, f *testFixture, drivers []*Driver, ride *Ride) error {
+				mustStep(t, f.rc.DeclineRide(drivers[0], ride))
+				f.clock.Advance(f.rc.OfferTimeout)
+				return f.rc.DeclineRide(drivers[2], ride)
+			},
+			status: Unmatched,
+		},
+		{
+			name: "driver who moved into range joins a later wave",
+			events: func(t *testing.T, f *testFixture, drivers []*Driver, ride *Ride) error {
+				f.rc.UpdateDriverLocation(drivers[3], north(testPickup, 0.1))
+				return f.rc.DeclineRide(drivers[0], ride)
+			},
+			offered: []int{4},
+			status:  Requested,
+		},
+		{
+			name: "nobody left in range",
+			events: func(t *testing.T, f *testFixture, drivers []*Driver, ride *Ride) error {
+				for _, driver := range drivers[1:3] {
+					f.rc.UpdateDriverLocation(driver, north(testPickup, 50))
+				}
+				return f.rc.DeclineRide(drivers[0], ride)
+			},
+			status: Requested, // Waits for the wave's timeout in case someone comes
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestFixture(t)
+			f.rc.MatchLimit = 1
+			var drivers []*Driver
+			for i, km := range []float64{0.1, 0.5, 1, 20} {
+				driver := f.driver(i + 1)
+				f.drivers.UpdateDriverLocation(driver, north(testPickup, km))
+				drivers = append(drivers, driver)
+			}
+			passenger := f.passenger(t, 1, PaymentMethod{Type: PayByCash}, 0)
+			ride, err := f.rc.RequestRide(passenger, testPickup, testDropOff)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := tt.events(t, f, drivers, ride); !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			var offered []int
+			for _, driver := range drivers {
+				if len(f.rc.PendingOffers(driver)) > 0 {
+					offered = append(offered, driver.ID)
+				}
+			}
+			if fmt.Sprint(offered) != fmt.Sprint(tt.offered) {
+				t.Errorf("offered to %v, want %v", offered, tt.offered)
+			}
+			if status, _ := f.rides.GetRideStatus(ride.ID); status != tt.status {
+				t.Errorf("status = %s, want %s", status, tt.status)
+			}
+			if dispatching := f.dispatching(ride); dispatching != (tt.status == Requested) {
+				t.Errorf("dispatching = %t, want %t", dispatching, tt.status == Requested)
+			}
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package ridesharingservice
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+	_ "time/tzdata" // Cities' time zones load on hosts without a zoneinfo database
+)
+
+// VehicleClass is the kind of car a passenger asks for and a driver drives.
+type VehicleClass string
+
+const (
+	Economy VehicleClass = "economy"
+	Premium VehicleClass = "premium"
+	XL      VehicleClass = "xl"
+)
+
+// orEconomy treats an unset class as Economy.
+func (c VehicleClass) orEconomy() VehicleClass {
+	if c == "" {
+		return Economy
+	}
+	return c
+}
+
+// ErrNoRateCard is returned when no rate card prices a trip.
+var ErrNoRateCard = errors.New("no rate card")
+
+// Trip describes what a fare is charged for: either the estimate of a
+// requested ride or the ride as driven.
+type Trip struct {
+	Pickup          *Location
+	VehicleClass    VehicleClass
+	Pool            bool      // Distance and Duration are the passenger's share of a pooled trip
+	Distance        float64   // Kilometres
+	Duration        float64   // Minutes
+	WaitingTime     float64   // Minutes the driver waited at the pickup
+	Tolls           float64   // Tolls paid on the way
+	StartTime       time.Time // Selects time-of-day rates
+	SurgeMultiplier float64
+}
+
+// FareItem is one line of an itemized fare.
+type FareItem struct {
+	Description string
+	Amount      float64
+}
+
+// FareBreakdown is a fare with the items that add up to it.
+type FareBreakdown struct {
+	City         string
+	VehicleClass VehicleClass
+	Items        []FareItem
+	Total        float64
+}
+
+func (fb *FareBreakdown) add(description string, amount float64) {
+	if math.Abs(amount) >= 0.005 {
+		fb.Items = append(fb.Items, FareItem{Description: description, Amount: amount})
+	}
+}
+
+// subtotal sums the items so far.
+func (fb *FareBreakdown) subtotal() float64 {
+	total := 0.0
+	for _, item := range fb.Items {
+		total += item.Amount
+	}
+	return total
+}
+
+func (fb *FareBreakdown) String() string {
+	var sb strings.Builder
+	for _, item := range fb.Items {
+		fmt.Fprintf(&sb, "  %-32s $%6.2f\n", item.Description, item.Amount)
+	}
+	fmt.Fprintf(&sb, "  %-32s $%6.2f", "Total", fb.Total)
+	return sb.String()
+}
+
+// FareStrategy prices trips.
+type FareStrategy interface {
+	Calculate(trip Trip) (*FareBreakdown, error)
+}
+
+// Calculate prices a trip with the flat rates of CalculateFare, so
+// FareCalculator can be used as a FareStrategy.
+func (fc *FareCalculator) Calculate(trip Trip) (*FareBreakdown, error) {
+	breakdown := &FareBreakdown{VehicleClass: trip.VehicleClass.orEconomy()}
+	breakdown.add("Fare", fc.CalculateFare(trip.Distance, trip.Duration))
+	applySurge(breakdown, trip.SurgeMultiplier)
+	breakdown.add("Tolls", trip.Tolls)
+	breakdown.Total = roundCents(breakdown.subtotal())
+	return breakdown, nil
+}
+
+// applySurge adds the surge on top of the items so far.
+func applySurge(breakdown *FareBreakdown, multiplier float64) {
+	if multiplier > 1 {
+		breakdown.add(fmt.Sprintf("Surge %.1fx", multiplier), breakdown.subtotal()*(multiplier-1))
+	}
+}
+
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// RateCard holds the rates of one vehicle class in one city.
+type RateCard struct {
+	City               string       `json:"city"`
+	VehicleClass       VehicleClass `json:"vehicle_class"`
+	BaseFare           float64      `json:"base_fare"`
+	PerKm              float64      `json:"per_km"`
+	PerMinute          float64      `json:"per_minute"`
+	MinimumFare        float64      `json:"minimum_fare"`
+	BookingFee         float64      `json:"booking_fee"`
+	WaitingPerMinute   float64      `json:"waiting_per_minute"`
+	FreeWaitingMinutes float64      `json:"free_waiting_minutes"`
+	TimeBands          []TimeBand   `json:"time_bands"`
+}
+
+// TimeBand scales the base, distance and time charges of trips starting
+// between Start and End, given as "15:04" local time in the pickup's city. A
+// band whose End is before its Start runs past midnight, like a night rate.
+type TimeBand struct {
+	Name       string  `json:"name"`
+	Start      string  `json:"start"`
+	End        string  `json:"end"`
+	Multiplier float64 `json:"multiplier"`
+
+	start, end int // Minutes since midnight
+}
+
+func (tb *TimeBand) parse() error {
+	for _, bound := range []struct {
+		value  string
+		minute *int
+	}{{tb.Start, &tb.start}, {tb.End, &tb.end}} {
+		t, err := time.Parse("15:04", bound.value)
+		if err != nil {
+			return fmt.Errorf("time band %s: %w", tb.Name, err)
+		}
+		*bound.minute = t.Hour()*60 + t.Minute()
+	}
+	return nil
+}
+
+func (tb *TimeBand) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if tb.start <= tb.end {
+		return minute >= tb.start && minute < tb.end
+	}
+	return minute >= tb.start || minute < tb.end
+}
+
+// City is an area with its own rate cards: every pickup within RadiusKm of
+// Center. TimeZone is an IANA name such as "America/New_York"; time bands
+// apply in the city's local time, or in UTC if it is empty.
+type City struct {
+	Name     string   `json:"name"`
+	Center   Location `json:"center"`
+	RadiusKm float64  `json:"radius_km"`
+	TimeZone string   `json:"time_zone"`
+
+	location *time.Location
+}
+
+// DefaultCity names the rate cards used for pickups outside every city. Their
+// time bands apply in UTC.
+const DefaultCity = "default"
+
+// RateCardStrategy prices trips with the rate card of the pickup's city and
+// the vehicle class, falling back to the cards of DefaultCity.
+type RateCardStrategy struct {
+	cities []City
+	cards  map[string]map[VehicleClass]*RateCard // City -> Vehicle class -> Rate card
+}
+
+type rateCardConfig struct {
+	Cities    []City     `json:"cities"`
+	RateCards []RateCard `json:"rate_cards"`
+}
+
+//go:embed ratecards.json
+var defaultRateCards []byte
+
+// DefaultRateCards returns the strategy of the rate cards shipped with the
+// service.
+func DefaultRateCards() (*RateCardStrategy, error) {
+	return ParseRateCards(defaultRateCards)
+}
+
+// LoadRateCards reads rate cards from a JSON file laid out like
+// ratecards.json.
+func LoadRateCards(path string) (*RateCardStrategy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRateCards(data)
+}
+
+func ParseRateCards(data []byte) (*RateCardStrategy, error) {
+	var config rateCardConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("rate cards: %w", err)
+	}
+	for i := range config.Cities {
+		city := &config.Cities[i]
+		location, err := time.LoadLocation(city.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("rate cards: %s: %w", city.Name, err)
+		}
+		city.location = location
+	}
+	strategy := &RateCardStrategy{cities: config.Cities, cards: make(map[string]map[VehicleClass]*RateCard)}
+	for i := range config.RateCards {
+		card := &config.RateCards[i]
+		switch card.VehicleClass {
+		case Economy, Premium, XL:
+		default:
+			return nil, fmt.Errorf("rate cards: unknown vehicle class %q", card.VehicleClass)
+		}
+		for j := range card.TimeBands {
+			if err := card.TimeBands[j].parse(); err != nil {
+				return nil, fmt.Errorf("rate cards: %s %s: %w", card.City, card.VehicleClass, err)
+			}
+		}
+		if strategy.cards[card.City] == nil {
+			strategy.cards[card.City] = make(map[VehicleClass]*RateCard)
+		}
+		if strategy.cards[card.City][card.VehicleClass] != nil {
+			return nil, fmt.Errorf("rate cards: duplicate card for %s %s", card.City, card.VehicleClass)
+		}
+		strategy.cards[card.City][card.VehicleClass] = card
+	}
+	return strategy, nil
+}
+
+// CityOf returns the city a location is in, or DefaultCity.
+func (s *RateCardStrategy) CityOf(location *Location) string {
+	if city := s.cityOf(location); city != nil {
+		return city.Name
+	}
+	return DefaultCity
+}
+
+func (s *RateCardStrategy) cityOf(location *Location) *City {
+	for i := range s.cities {
+		if location.DistanceTo(&s.cities[i].Center) <= s.cities[i].RadiusKm {
+			return &s.cities[i]
+		}
+	}
+	return nil
+}
+
+// localTime converts t to the local time of the city a location is in.
+func (s *RateCardStrategy) localTime(location *Location, t time.Time) time.Time {
+	if city := s.cityOf(location); city != nil {
+		return t.In(city.location)
+	}
+	return t.UTC()
+}
+
+// RateCard returns the card a trip from pickup in a vehicle class is priced
+// with.
+func (s *RateCardStrategy) RateCard(pickup *Location, class VehicleClass) (*RateCard, error) {
+	class = class.orEconomy()
+	if card := s.cards[s.CityOf(pickup)][class]; card != nil {
+		return card, nil
+	}
+	if card := s.cards[DefaultCity][class]; card != nil {
+		return card, nil
+	}
+	return nil, fmt.Errorf("%w for %s in %s", ErrNoRateCard, class, s.CityOf(pickup))
+}
+
+func (s *RateCardStrategy) Calculate(trip Trip) (*FareBreakdown, error) {
+	card, err := s.RateCard(trip.Pickup, trip.VehicleClass)
+	if err != nil {
+		return nil, err
+	}
+	breakdown := &FareBreakdown{City: card.City, VehicleClass: card.VehicleClass}
+	breakdown.add("Base fare", card.BaseFare)
+	shared := ""
+	if trip.Pool {
+		shared = ", shared"
+	}
+	breakdown.add(fmt.Sprintf("Distance (%.1f km%s)", trip.Distance, shared), trip.Distance*card.PerKm)
+	breakdown.add(fmt.Sprintf("Time (%.0f min%s)", trip.Duration, shared), trip.Duration*card.PerMinute)
+	start := s.localTime(trip.Pickup, trip.StartTime)
+	for _, band := range card.TimeBands {
+		if band.contains(start) {
+			breakdown.add(fmt.Sprintf("%s rate %.2fx", band.Name, band.Multiplier), breakdown.subtotal()*(band.Multiplier-1))
+			break
+		}
+	}
+	applySurge(breakdown, trip.SurgeMultiplier)
+	if waiting := trip.WaitingTime - card.FreeWaitingMinutes; waiting > 0 {
+		breakdown.add(fmt.Sprintf("Waiting (%.0f min)", waiting), waiting*card.WaitingPerMinute)
+	}
+	breakdown.add("Booking fee", card.BookingFee)
+	if subtotal := breakdown.subtotal(); subtotal < card.MinimumFare {
+		breakdown.add("Minimum fare adjustment", card.MinimumFare-subtotal)
+	}
+	breakdown.add("Tolls", trip.Tolls)
+	breakdown.Total = roundCents(breakdown.subtotal())
+	return breakdown, nil
+}
@@ -0,0 +1,69 @@
+package ridesharingservice
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateCardTimeBands(t *testing.T) {
+	newYork := &Location{Latitude: 40.71, Longitude: -74.0}
+	nowhere := &Location{Latitude: 0, Longitude: 0}
+	tests := []struct {
+		name   string
+		pickup *Location
+		start  time.Time
+		band   string // Empty if no band applies
+	}{
+		{name: "San Francisco morning", pickup: testPickup, start: time.Date(2024, time.May, 15, 15, 30, 0, 0, time.UTC), band: "Morning peak rate 1.20x"},
+		{name: "San Francisco noon", pickup: testPickup, start: time.Date(2024, time.May, 15, 19, 0, 0, 0, time.UTC)},
+		{name: "San Francisco night, next day in UTC", pickup: testPickup, start: time.Date(2024, time.May, 16, 6, 0, 0, 0, time.UTC), band: "Night rate 1.25x"},
+		{name: "New York morning", pickup: newYork, start: time.Date(2024, time.May, 15, 12, 30, 0, 0, time.UTC), band: "Morning peak rate 1.20x"},
+		{name: "New York morning in winter", pickup: newYork, start: time.Date(2024, time.January, 15, 13, 30, 0, 0, time.UTC), band: "Morning peak rate 1.20x"},
+		{name: "New York evening, given in another zone", pickup: newYork, start: time.Date(2024, time.May, 15, 14, 0, 0, 0, time.FixedZone("PDT", -7*60*60)), band: "Evening peak rate 1.20x"},
+		{name: "outside every city uses UTC", pickup: nowhere, start: time.Date(2024, time.May, 15, 8, 0, 0, 0, time.UTC), band: "Morning peak rate 1.20x"},
+	}
+
+	rateCards, err := DefaultRateCards()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breakdown, err := rateCards.Calculate(Trip{Pickup: tt.pickup, Distance: 5, Duration: 10, StartTime: tt.start})
+			if err != nil {
+				t.Fatal(err)
+			}
+			band := ""
+			for _, item := range breakdown.Items {
+				if strings.Contains(item.Description, " rate ") {
+					band = item.Description
+				}
+			}
+			if band != tt.band {
+				t.Errorf("band = %q, want %q\n%s", band, tt.band, breakdown)
+			}
+		})
+	}
+}
+
+func TestParseRateCardsTimeZone(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeZone string
+		wantErr  bool
+	}{
+		{name: "IANA name", timeZone: "Europe/Paris"},
+		{name: "empty is UTC", timeZone: ""},
+		{name: "unknown", timeZone: "Mars/Olympus_Mons", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := `{"cities": [{"name": "Paris", "center": {"latitude": 48.86, "longitude": 2.35}, "radius_km": 30, "time_zone": "` + tt.timeZone + `"}]}`
+			if _, err := ParseRateCards([]byte(config)); (err != nil) != tt.wantErr {
+				t.Errorf("ParseRateCards() error = %v, want error: %t", err, tt.wantErr)
+			}
+		})
+	}
+}
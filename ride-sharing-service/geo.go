@@ -0,0 +1,173 @@
+package ridesharingservice
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+const earthRadiusKm = 6371.0
+
+// DistanceTo returns the great-circle distance to other in kilometres, using
+// the haversine formula.
+func (l *Location) DistanceTo(other *Location) float64 {
+	lat1, lat2 := radians(l.Latitude), radians(other.Latitude)
+	dLat := lat2 - lat1
+	dLng := radians(other.Longitude - l.Longitude)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// geohash encodes a location as a geohash of the given number of characters.
+// Locations sharing a geohash lie in the same grid cell.
+func geohash(location *Location, precision int) string {
+	latRange := [2]float64{-90, 90}
+	lngRange := [2]float64{-180, 180}
+	var sb strings.Builder
+	bit, ch, even := 0, 0, true
+	for sb.Len() < precision {
+		value, bounds := location.Longitude, &lngRange
+		if !even {
+			value, bounds = location.Latitude, &latRange
+		}
+		mid := (bounds[0] + bounds[1]) / 2
+		ch <<= 1
+		if value >= mid {
+			ch |= 1
+			bounds[0] = mid
+		} else {
+			bounds[1] = mid
+		}
+		even = !even
+		if bit++; bit == 5 {
+			sb.WriteByte(geohashAlphabet[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return sb.String()
+}
+
+// geohashCellSize returns the height and width in degrees of the cells of a
+// geohash with the given number of characters.
+func geohashCellSize(precision int) (float64, float64) {
+	bits := 5 * precision
+	lngBits := (bits + 1) / 2
+	latBits := bits / 2
+	return 180 / math.Pow(2, float64(latBits)), 360 / math.Pow(2, float64(lngBits))
+}
+
+// geoIndex buckets drivers into geohash cells so that a radius search only
+// looks at the drivers in the cells around the centre.
+type geoIndex struct {
+	precision int
+	cells     map[string]map[int]*Driver // Geohash -> Driver ID -> Driver
+	cellOf    map[int]string             // Driver ID -> Geohash
+}
+
+func newGeoIndex(precision int) *geoIndex {
+	return &geoIndex{precision: precision, cells: make(map[string]map[int]*Driver), cellOf: make(map[int]string)}
+}
+
+// update moves driver to the cell of its current location.
+func (g *geoIndex) update(driver *Driver) {
+	cell := geohash(driver.Location, g.precision)
+	if old, exists := g.cellOf[driver.ID]; exists {
+		if old == cell {
+			return
+		}
+		delete(g.cells[old], driver.ID)
+		if len(g.cells[old]) == 0 {
+			delete(g.cells, old)
+		}
+	}
+	if g.cells[cell] == nil {
+		g.cells[cell] = make(map[int]*Driver)
+	}
+	g.cells[cell][driver.ID] = driver
+	g.cellOf[driver.ID] = cell
+}
+
+// nearest returns up to limit drivers within radiusKm of center that pass
+// keep, closest first. A limit of zero or less means no limit.
+func (g *geoIndex) nearest(center *Location, radiusKm float64, limit int, keep func(*Driver) bool) []*Driver {
+	type candidate struct {
+		driver   *Driver
+		distance float64
+	}
+	var candidates []candidate
+	for _, cell := range g.coveringCells(center, radiusKm) {
+		for _, driver := range g.cells[cell] {
+			if !keep(driver) {
+				continue
+			}
+			if distance := center.DistanceTo(driver.Location); distance <= radiusKm {
+				candidates = append(candidates, candidate{driver, distance})
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].driver.ID < candidates[j].driver.ID
+	})
+	if limit > 0 && len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	drivers := make([]*Driver, len(candidates))
+	for i, c := range candidates {
+		drivers[i] = c.driver
+	}
+	return drivers
+}
+
+// coveringCells returns the geohash cells that intersect the bounding box of
+// the circle around center.
+func (g *geoIndex) coveringCells(center *Location, radiusKm float64) []string {
+	const kmPerDegree = math.Pi * earthRadiusKm / 180
+	dLat := radiusKm / kmPerDegree
+	minLat, maxLat := math.Max(-90, center.Latitude-dLat), math.Min(90, center.Latitude+dLat)
+	minLng, maxLng := -180.0, 180.0
+	if cos := math.Cos(radians(math.Max(math.Abs(minLat), math.Abs(maxLat)))); cos > 0 {
+		if dLng := radiusKm / (kmPerDegree * cos); dLng < 180 {
+			minLng, maxLng = center.Longitude-dLng, center.Longitude+dLng
+		}
+	}
+
+	// Sampling the box one cell apart, plus its far edges, hits every cell.
+	height, width := geohashCellSize(g.precision)
+	seen := make(map[string]bool)
+	var cells []string
+	for lat := minLat; ; lat = math.Min(lat+height, maxLat) {
+		for lng := minLng; ; lng = math.Min(lng+width, maxLng) {
+			cell := geohash(&Location{Latitude: lat, Longitude: wrapLongitude(lng)}, g.precision)
+			if !seen[cell] {
+				seen[cell] = true
+				cells = append(cells, cell)
+			}
+			if lng >= maxLng {
+				break
+			}
+		}
+		if lat >= maxLat {
+			break
+		}
+	}
+	return cells
+}
+
+func wrapLongitude(lng float64) float64 {
+	for lng < -180 {
+		lng += 360
+	}
+	for lng >= 180 {
+		lng -= 360
+	}
+	return lng
+}
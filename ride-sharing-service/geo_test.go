@@ -0,0 +1,111 @@
+package ridesharingservice
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestDistanceTo(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to *Location
+		want     float64 // Kilometres
+	}{
+		{name: "same place", from: testPickup, to: testPickup, want: 0},
+		{name: "one degree of latitude", from: &Location{Latitude: 0, Longitude: 0}, to: &Location{Latitude: 1, Longitude: 0}, want: 111.19},
+		{name: "San Francisco to Los Angeles", from: &Location{Latitude: 37.7749, Longitude: -122.4194}, to: &Location{Latitude: 34.0522, Longitude: -118.2437}, want: 559.12},
+		{name: "across the antimeridian", from: &Location{Latitude: 0, Longitude: 179.5}, to: &Location{Latitude: 0, Longitude: -179.5}, want: 111.19},
+		{name: "antipodes", from: &Location{Latitude: 10, Longitude: 20}, to: &Location{Latitude: -10, Longitude: -160}, want: 20015.09},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.from.DistanceTo(tt.to); math.Abs(got-tt.want) > 0.01 {
+				t.Errorf("DistanceTo() = %.2f km, want %.2f km", got, tt.want)
+			}
+		})
+	}
+}
+
+// north returns the location km kilometres north of from.
+func north(from *Location, km float64) *Location {
+	return &Location{Latitude: from.Latitude + km/(math.Pi*earthRadiusKm/180), Longitude: from.Longitude}
+}
+
+func TestFindNearestDrivers(t *testing.T) {
+	tests := []struct {
+		name     string
+		center   *Location
+		radiusKm float64
+		limit    int
+		want     []int // Driver IDs, closest first
+	}{
+		{name: "within the default radius", center: testPickup, radiusKm: DefaultMatchRadiusKm, want: []int{1, 2, 3}},
+		{name: "small radius", center: testPickup, radiusKm: 1.5, want: []int{1, 2}},
+		{name: "limit", center: testPickup, radiusKm: DefaultMatchRadiusKm, limit: 2, want: []int{1, 2}},
+		{name: "large radius", center: testPickup, radiusKm: 50, want: []int{1, 2, 3, 5}},
+		{name: "centre moved north", center: north(testPickup, 4), radiusKm: 2, want: []int{3}},
+		{name: "nobody around", center: &Location{Latitude: 0, Longitude: 0}, radiusKm: DefaultMatchRadiusKm},
+		{name: "across the antimeridian", center: &Location{Latitude: 0, Longitude: 179.99}, radiusKm: DefaultMatchRadiusKm, want: []int{6}},
+	}
+
+	ds := NewDriverService()
+	for _, driver := range []*Driver{
+		{ID: 1, Location: north(testPickup, 0.5)},
+		{ID: 2, Location: north(testPickup, 1)},
+		{ID: 3, Location: north(testPickup, 4.5)},
+		{ID: 4, Location: north(testPickup, 0.2), Status: Busy},
+		{ID: 5, Location: north(testPickup, 30)},
+		{ID: 6, Location: &Location{Latitude: 0, Longitude: -179.99}},
+		{ID: 7, Location: north(testPickup, 0.1)},
+	} {
+		ds.AddDriver(driver)
+	}
+	// Moving a driver takes them out of their old cell.
+	ds.UpdateDriverLocation(ds.drivers[7], north(testPickup, 100))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			for _, driver := range ds.FindNearestDrivers(tt.center, tt.radiusKm, tt.limit) {
+				got = append(got, driver.ID)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("FindNearestDrivers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDispatchMatchRadius(t *testing.T) {
+	tests := []struct {
+		name     string
+		radiusKm float64
+		offered  bool
+	}{
+		{name: "driver inside the radius", radiusKm: DefaultMatchRadiusKm, offered: true},
+		{name: "driver outside the radius", radiusKm: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestFixture(t)
+			f.rc.MatchRadiusKm = tt.radiusKm
+			driver := f.driver(1)
+			f.drivers.UpdateDriverLocation(driver, north(testPickup, 3))
+			passenger := f.passenger(t, 1, PaymentMethod{Type: PayByWallet}, 50_00)
+
+			ride, err := f.rc.RequestRide(passenger, testPickup, testDropOff)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.rc.dispatchMu.Lock()
+			_, offered := f.rc.dispatches[ride.ID].pending[driver.ID]
+			f.rc.dispatchMu.Unlock()
+			if offered != tt.offered {
+				t.Errorf("offered to the driver 3 km away: %t, want %t", offered, tt.offered)
+			}
+		})
+	}
+}
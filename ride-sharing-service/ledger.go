@@ -0,0 +1,174 @@
+package ridesharingservice
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrUnbalanced is returned for a transaction whose debits and credits differ.
+var ErrUnbalanced = errors.New("transaction does not balance")
+
+// Money is an amount in minor units: cents.
+type Money int64
+
+// toMoney converts an amount in dollars to Money, to the nearest cent.
+func toMoney(amount float64) Money {
+	return Money(math.Round(amount * 100))
+}
+
+func (m Money) String() string {
+	sign := ""
+	if m < 0 {
+		sign, m = "-", -m
+	}
+	return fmt.Sprintf("%s$%d.%02d", sign, m/100, m%100)
+}
+
+// Account names an account of the ledger.
+type Account string
+
+// Accounts outside the passengers' and drivers' own
+const (
+	CardAccount       Account = "external:card"       // Money owed by the card network
+	BankAccount       Account = "external:bank"       // Payouts sent to drivers' banks
+	CommissionAccount Account = "platform:commission" // The platform's share of fares
+	PenaltyAccount    Account = "platform:penalties"  // Penalties charged to drivers
+)
+
+// PassengerWalletAccount holds the money a passenger keeps with the platform.
+func PassengerWalletAccount(passenger *Passenger) Account {
+	return Account(fmt.Sprintf("passenger:%d:wallet", passenger.ID))
+}
+
+// PassengerHoldAccount holds wallet money authorized for rides not yet paid.
+func PassengerHoldAccount(passenger *Passenger) Account {
+	return Account(fmt.Sprintf("passenger:%d:hold", passenger.ID))
+}
+
+// DriverEarningsAccount holds what the platform owes a driver.
+func DriverEarningsAccount(driver *Driver) Account {
+	return Account(fmt.Sprintf("driver:%d:earnings", driver.ID))
+}
+
+// DriverCashAccount holds the cash fares a driver collected on behalf of the
+// platform.
+func DriverCashAccount(driver *Driver) Account {
+	return Account(fmt.Sprintf("driver:%d:cash", driver.ID))
+}
+
+// Posting debits an account by a positive amount, or credits it by a
+// negative one.
+type Posting struct {
+	Account Account
+	Amount  Money
+}
+
+func debit(account Account, amount Money) Posting {
+	return Posting{Account: account, Amount: amount}
+}
+
+func credit(account Account, amount Money) Posting {
+	return Posting{Account: account, Amount: -amount}
+}
+
+// Transaction is a set of postings whose debits equal their credits.
+type Transaction struct {
+	ID          int
+	At          time.Time
+	RideID      int // 0 if the transaction is not about a ride
+	Description string
+	Postings    []Posting
+}
+
+// Ledger is an append-only double-entry record of every movement of money.
+type Ledger struct {
+	transactions []Transaction
+	balances     map[Account]Money
+	mu           sync.Mutex
+}
+
+func NewLedger() *Ledger {
+	return &Ledger{balances: make(map[Account]Money)}
+}
+
+// Post records a transaction. Zero postings are dropped; the rest must add
+// up to zero.
+func (l *Ledger) Post(at time.Time, rideID int, description string, postings ...Posting) error {
+	var kept []Posting
+	sum := Money(0)
+	for _, posting := range postings {
+		if posting.Amount != 0 {
+			kept = append(kept, posting)
+			sum += posting.Amount
+		}
+	}
+	if sum != 0 {
+		return fmt.Errorf("%w: %s is off by %s", ErrUnbalanced, description, sum)
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.transactions = append(l.transactions, Transaction{
+		ID:          len(l.transactions) + 1,
+		At:          at,
+		RideID:      rideID,
+		Description: description,
+		Postings:    kept,
+	})
+	for _, posting := range kept {
+		l.balances[posting.Account] += posting.Amount
+	}
+	return nil
+}
+
+// Balance returns the debits minus the credits of an account. Accounts
+// holding money for someone, like wallets and earnings, have credit balances,
+// so theirs are negative.
+func (l *Ledger) Balance(account Account) Money {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.balances[account]
+}
+
+// Transactions returns every transaction, oldest first.
+func (l *Ledger) Transactions() []Transaction {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Transaction(nil), l.transactions...)
+}
+
+// TrialBalance sums the balances of all accounts, which is zero as long as
+// every transaction balanced.
+func (l *Ledger) TrialBalance() Money {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	total := Money(0)
+	for _, balance := range l.balances {
+		total += balance
+	}
+	return total
+}
+
+// entries returns the postings to the given accounts as wallet entries, in
+// favour of the accounts' owner: credits count up, debits down.
+func (l *Ledger) entries(accounts ...Account) []WalletEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var entries []WalletEntry
+	for _, tx := range l.transactions {
+		for _, posting := range tx.Postings {
+			for _, account := range accounts {
+				if posting.Account == account {
+					entries = append(entries, WalletEntry{At: tx.At, RideID: tx.RideID, Description: tx.Description, Amount: -posting.Amount})
+				}
+			}
+		}
+	}
+	return entries
+}
@@ -0,0 +1,149 @@
+package ridesharingservice
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Errors returned by the ride lifecycle
+var (
+	ErrInvalidTransition = errors.New("invalid ride status transition")
+	ErrDriverUnavailable = errors.New("driver is not available")
+	ErrWrongDriver       = errors.New("driver is not assigned to the ride")
+	ErrRideNotFound      = errors.New("ride not found")
+	ErrWrongRideStatus   = errors.New("ride is not in the required status")
+)
+
+// rideTransitions lists the statuses a ride may move to from each status.
+// Completed, Cancelled and Unmatched are final.
+var rideTransitions = map[RideStatus][]RideStatus{
+	Scheduled:  {Requested, Cancelled},
+	Requested:  {Accepted, Cancelled, Unmatched},
+	Accepted:   {InProgress, Cancelled},
+	InProgress: {Completed},
+}
+
+// CanTransitionTo reports whether a ride may move from s to next.
+func (s RideStatus) CanTransitionTo(next RideStatus) bool {
+	for _, allowed := range rideTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+func (s RideStatus) String() string {
+	switch s {
+	case Requested:
+		return "Requested"
+	case Accepted:
+		return "Accepted"
+	case InProgress:
+		return "InProgress"
+	case Completed:
+		return "Completed"
+	case Cancelled:
+		return "Cancelled"
+	case Unmatched:
+		return "Unmatched"
+	case Scheduled:
+		return "Scheduled"
+	}
+	return fmt.Sprintf("RideStatus(%d)", int(s))
+}
+
+func (s DriverStatus) String() string {
+	switch s {
+	case Available:
+		return "Available"
+	case Busy:
+		return "Busy"
+	}
+	return fmt.Sprintf("DriverStatus(%d)", int(s))
+}
+
+// TransitionError reports a ride status change that the lifecycle does not
+// allow. It matches ErrInvalidTransition.
+type TransitionError struct {
+	RideID int
+	From   RideStatus
+	To     RideStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("ride %d cannot move from %s to %s", e.RideID, e.From, e.To)
+}
+
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
+// setTimestamp records when ride entered status.
+func (ride *Ride) setTimestamp(status RideStatus, at time.Time) {
+	switch status {
+	case Requested:
+		ride.RequestedAt = at
+	case Accepted:
+		ride.AcceptedAt = at
+	case InProgress:
+		ride.StartedAt = at
+	case Completed:
+		ride.CompletedAt = at
+	case Cancelled:
+		ride.CancelledAt = at
+	case Unmatched:
+		ride.UnmatchedAt = at
+	case Scheduled:
+		ride.ScheduledAt = at
+	}
+}
+
+// transition moves ride to status at the given time. apply runs first, under
+// the same lock, to check preconditions or update the ride along with its
+// status; the ride is left unchanged if it returns an error.
+func (rs *RideService) transition(ride *Ride, status RideStatus, at time.Time, apply func() error) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if !ride.Status.CanTransitionTo(status) {
+		return &TransitionError{RideID: ride.ID, From: ride.Status, To: status}
+	}
+	if apply != nil {
+		if err := apply(); err != nil {
+			return err
+		}
+	}
+	ride.Status = status
+	ride.setTimestamp(status, at)
+	return nil
+}
+
+// update changes ride under the lock, as long as it is in the given status.
+func (rs *RideService) update(ride *Ride, status RideStatus, apply func() error) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if ride.Status != status {
+		return fmt.Errorf("%w: ride %d is %s, not %s", ErrWrongRideStatus, ride.ID, ride.Status, status)
+	}
+	return apply()
+}
+
+// reserve marks an available driver busy. It fails if the driver is busy
+// already, so a driver can only take one ride at a time.
+func (ds *DriverService) reserve(driver *Driver) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if driver.Status != Available {
+		return fmt.Errorf("%w: %s is %s", ErrDriverUnavailable, driver.Name, driver.Status)
+	}
+	driver.Status = Busy
+	return nil
+}
+
+// release makes a driver available again.
+func (ds *DriverService) release(driver *Driver) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	driver.Status = Available
+}
@@ -0,0 +1,175 @@
+package ridesharingservice
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCanTransitionTo(t *testing.T) {
+	tests := []struct {
+		from, to RideStatus
+		want     bool
+	}{
+		{Scheduled, Requested, true},
+		{Scheduled, Cancelled, true},
+		{Scheduled, Accepted, false},
+		{Requested, Accepted, true},
+		{Requested, Unmatched, true},
+		{Requested, InProgress, false},
+		{Accepted, InProgress, true},
+		{Accepted, Cancelled, true},
+		{Accepted, Requested, false},
+		{InProgress, Completed, true},
+		{InProgress, Cancelled, false},
+		{Completed, Cancelled, false},
+		{Cancelled, Requested, false},
+		{Unmatched, Requested, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from.String()+"->"+tt.to.String(), func(t *testing.T) {
+			if got := tt.from.CanTransitionTo(tt.to); got != tt.want {
+				t.Errorf("CanTransitionTo() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRideLifecycle(t *testing.T) {
+	tests := []struct {
+		name   string
+		steps  func(t *testing.T, f *testFixture, driver, other *Driver, ride *Ride) error // The last step's error is checked
+		err    error
+		status RideStatus
+		busy   bool // Whether driver is busy afterwards
+	}{
+		{
+			name: "accept",
+			steps: func(t *testing.T, f *testFixture, driver, other *Driver, ride *Ride) error {
+				return f.rc.AcceptRide(driver, ride)
+			},
+			status: Accepted,
+			busy:   true,
+		},
+		{
+			name: "complete",
+			steps: func(t *testing.T, f *testFixture, driver, other *Driver, ride *Ride) error {
+				mustStep(t, f.rc.AcceptRide(driver, ride))
+				mustStep(t, f.rc.StartRide(driver, ride))
+				return f.rc.CompleteRide(ride)
+			},
+			status: Completed,
+		},
+		{
+			name: "start before accepting",
+			steps: func(t *testing.T, f *testFixture, driver, other *Driver, ride *Ride) error {
+				return f.rc.StartRide(driver, ride)
+			},
+			err:    &TransitionError{From: Requested, To: InProgress},
+			status: Requested,
+		},
+		{
+			name: "complete before starting",
+			steps: func(t *testing.T, f *testFixture, driver, other *Driver, ride *Ride) error {
+				mustStep(t, f.rc.AcceptRide(driver, ride))
+				return f.rc.CompleteRide(ride)
+			},
+			err:    &TransitionError{From: Accepted, To: Completed},
+			status: Accepted,
+			busy:   true,
+		},
+		{
+			name: "second driver accepts",
+			steps: func(t *testing.T, f *testFixture, driver, other *Driver, ride *Ride) error {
+				mustStep(t, f.rc.AcceptRide(driver, ride))
+				return f.rc.AcceptRide(other, ride)
+			},
+			err:    ErrNoOffer,
+			status: Accepted,
+			busy:   true,
+		},
+		{
+			name: "another driver starts",
+			steps: func(t *testing.T, f *testFixture, driver, other *Driver, ride *Ride) error {
+				mustStep(t, f.rc.AcceptRide(driver, ride))
+				return f.rc.StartRide(other, ride)
+			},
+			err:    ErrWrongDriver,
+			status: Accepted,
+			busy:   true,
+		},
+		{
+			name: "arrive after starting",
+			steps: func(t *testing.T, f *testFixture, driver, other *Driver, ride *Ride) error {
+				mustStep(t, f.rc.AcceptRide(driver, ride))
+				mustStep(t, f.rc.StartRide(driver, ride))
+				return f.rc.DriverArrived(driver, ride)
+			},
+			err:    ErrWrongRideStatus,
+			status: InProgress,
+			busy:   true,
+		},
+		{
+			name: "cancel a completed ride",
+			steps: func(t *testing.T, f *testFixture, driver, other *Driver, ride *Ride) error {
+				mustStep(t, f.rc.AcceptRide(driver, ride))
+				mustStep(t, f.rc.StartRide(driver, ride))
+				mustStep(t, f.rc.CompleteRide(ride))
+				return f.rc.CancelRide(driver, ride)
+			},
+			err:    &TransitionError{From: Completed, To: Cancelled},
+			status: Completed,
+		},
+		{
+			name: "driver cancels",
+			steps: func(t *testing.T, f *testFixture, driver, other *Driver, ride *Ride) error {
+				mustStep(t, f.rc.AcceptRide(driver, ride))
+				return f.rc.CancelRide(driver, ride)
+			},
+			status: Cancelled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestFixture(t)
+			driver, other := f.driver(1), f.driver(2)
+			passenger := f.passenger(t, 1, PaymentMethod{Type: PayByCash}, 0)
+			ride, err := f.rc.RequestRide(passenger, testPickup, testDropOff)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = tt.steps(t, f, driver, other, ride)
+			var want *TransitionError
+			switch {
+			case errors.As(tt.err, &want):
+				var got *TransitionError
+				if !errors.As(err, &got) || !errors.Is(err, ErrInvalidTransition) || got.From != want.From || got.To != want.To || got.RideID != ride.ID {
+					t.Fatalf("error = %v, want a transition from %s to %s", err, want.From, want.To)
+				}
+			case !errors.Is(err, tt.err):
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if status, _ := f.rides.GetRideStatus(ride.ID); status != tt.status {
+				t.Errorf("status = %s, want %s", status, tt.status)
+			}
+			if busy := driver.Status == Busy; busy != tt.busy {
+				t.Errorf("driver status = %s, want busy: %t", driver.Status, tt.busy)
+			}
+		})
+	}
+
+	t.Run("unknown ride", func(t *testing.T) {
+		if err := NewRideService().UpdateRideStatus(42, Accepted); !errors.Is(err, ErrRideNotFound) {
+			t.Errorf("UpdateRideStatus() error = %v, want %v", err, ErrRideNotFound)
+		}
+	})
+}
+
+func mustStep(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
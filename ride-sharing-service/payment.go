@@ -0,0 +1,361 @@
+package ridesharingservice
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Errors returned by payments
+var (
+	ErrCardDeclined       = errors.New("card declined")
+	ErrInsufficientFunds  = errors.New("insufficient wallet balance")
+	ErrPaymentNotOpen     = errors.New("payment is not authorized")
+	ErrRefundExceedsTotal = errors.New("refund exceeds the amount paid")
+	ErrNothingToPayOut    = errors.New("nothing to pay out")
+	ErrInvalidAmount      = errors.New("amount must be positive")
+)
+
+// PaymentType is how a passenger pays for rides.
+type PaymentType int
+
+const (
+	PayByCash PaymentType = iota
+	PayByWallet
+	PayByCard
+)
+
+func (t PaymentType) String() string {
+	switch t {
+	case PayByCash:
+		return "cash"
+	case PayByWallet:
+		return "wallet"
+	case PayByCard:
+		return "card"
+	}
+	return fmt.Sprintf("PaymentType(%d)", int(t))
+}
+
+// PaymentMethod is a payment type with what it needs to charge.
+type PaymentMethod struct {
+	Type      PaymentType
+	CardToken string // Card to charge, for PayByCard
+}
+
+// PaymentStatus is how far the payment of a ride has got.
+type PaymentStatus int
+
+const (
+	PaymentAuthorized PaymentStatus = iota
+	PaymentCaptured
+	PaymentVoided
+)
+
+// Payment is the payment of a ride, from authorization on accept to capture
+// on completion, or to void or a fee on cancellation.
+type Payment struct {
+	Method          PaymentMethod
+	Status          PaymentStatus
+	AuthorizationID string // Card authorization at the gateway
+	Authorized      Money
+	Captured        Money // Charged to the passenger
+	Commission      Money // The platform's share of Captured
+	Refunded        Money
+}
+
+// CardGateway charges cards. Money authorized is only held; it moves when
+// captured.
+type CardGateway interface {
+	Authorize(cardToken string, amount Money) (string, error)
+	Capture(authorizationID string, amount Money) error
+	Void(authorizationID string) error
+	Refund(authorizationID string, amount Money) error
+}
+
+// DeclinedCardToken is a card StubCardGateway always declines.
+const DeclinedCardToken = "tok_declined"
+
+// StubCardGateway approves every card but DeclinedCardToken, without
+// charging anything, and keeps track of the authorizations it gave.
+type StubCardGateway struct {
+	authorizations map[string]*stubAuthorization
+	mu             sync.Mutex
+}
+
+type stubAuthorization struct {
+	captured Money
+	refunded Money
+	voided   bool
+}
+
+func NewStubCardGateway() *StubCardGateway {
+	return &StubCardGateway{authorizations: make(map[string]*stubAuthorization)}
+}
+
+func (g *StubCardGateway) Authorize(cardToken string, amount Money) (string, error) {
+	if cardToken == "" || cardToken == DeclinedCardToken {
+		return "", fmt.Errorf("%w: %q", ErrCardDeclined, cardToken)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	id := fmt.Sprintf("auth_%d", len(g.authorizations)+1)
+	g.authorizations[id] = &stubAuthorization{}
+	return id, nil
+}
+
+// Capture charges an authorization. Capturing more than was authorized is
+// approved too, as for a fare that ends up higher than estimated.
+func (g *StubCardGateway) Capture(authorizationID string, amount Money) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	auth := g.authorizations[authorizationID]
+	if auth == nil || auth.voided {
+		return fmt.Errorf("%w: no open authorization %s", ErrCardDeclined, authorizationID)
+	}
+	auth.captured += amount
+	return nil
+}
+
+func (g *StubCardGateway) Void(authorizationID string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if auth := g.authorizations[authorizationID]; auth != nil {
+		auth.voided = true
+	}
+	return nil
+}
+
+func (g *StubCardGateway) Refund(authorizationID string, amount Money) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	auth := g.authorizations[authorizationID]
+	if auth == nil || auth.refunded+amount > auth.captured {
+		return fmt.Errorf("%w: authorization %s", ErrRefundExceedsTotal, authorizationID)
+	}
+	auth.refunded += amount
+	return nil
+}
+
+// Defaults for payments
+const (
+	DefaultCommissionRate      = 0.20
+	DefaultAuthorizationMargin = 0.25
+)
+
+// PaymentService charges passengers, pays drivers and records every movement
+// of money in the ledger.
+type PaymentService struct {
+	CommissionRate      float64 // The platform's share of every fare
+	AuthorizationMargin float64 // Held on top of the estimate, for a fare that ends up higher
+
+	ledger  *Ledger
+	gateway CardGateway
+	mu      sync.Mutex
+}
+
+func NewPaymentService(ledger *Ledger, gateway CardGateway) *PaymentService {
+	return &PaymentService{
+		CommissionRate:      DefaultCommissionRate,
+		AuthorizationMargin: DefaultAuthorizationMargin,
+		ledger:              ledger,
+		gateway:             gateway,
+	}
+}
+
+// Authorize holds the estimated fare of a ride, plus the margin, on the
+// passenger's payment method: wallet money moves to a hold account, a card is
+// authorized at the gateway, and cash needs nothing.
+func (pay *PaymentService) Authorize(ride *Ride, at time.Time) error {
+	pay.mu.Lock()
+	defer pay.mu.Unlock()
+	method := ride.Passenger.PaymentMethod
+	payment := &Payment{Method: method, Authorized: toMoney(ride.EstimatedFare * (1 + pay.AuthorizationMargin))}
+	switch method.Type {
+	case PayByWallet:
+		wallet := PassengerWalletAccount(ride.Passenger)
+		if err := pay.covers(wallet, 0, payment.Authorized); err != nil {
+			return err
+		}
+		err := pay.ledger.Post(at, ride.ID, "Fare authorized", debit(wallet, payment.Authorized), credit(PassengerHoldAccount(ride.Passenger), payment.Authorized))
+		if err != nil {
+			return err
+		}
+	case PayByCard:
+		id, err := pay.gateway.Authorize(method.CardToken, payment.Authorized)
+		if err != nil {
+			return err
+		}
+		payment.AuthorizationID = id
+	}
+	ride.Payment = payment
+	return nil
+}
+
+// Capture charges the fare of a completed ride. The driver earns the fare
+// less the platform's commission; a driver paid in cash keeps the fare and
+// owes the commission.
+func (pay *PaymentService) Capture(ride *Ride, at time.Time) error {
+	pay.mu.Lock()
+	defer pay.mu.Unlock()
+	payment, err := openPayment(ride)
+	if err != nil {
+		return err
+	}
+	fare := toMoney(ride.Fare)
+	commission := Money(math.Round(float64(fare) * pay.CommissionRate))
+	source, err := pay.collect(ride, payment, fare, DriverCashAccount(ride.Driver), at)
+	if err != nil {
+		return err
+	}
+	err = pay.ledger.Post(at, ride.ID, "Fare",
+		debit(source, fare),
+		credit(DriverEarningsAccount(ride.Driver), fare-commission),
+		credit(CommissionAccount, commission))
+	if err != nil {
+		return err
+	}
+	payment.Status, payment.Captured, payment.Commission = PaymentCaptured, fare, commission
+	return nil
+}
+
+// SettleCancellation ends the payment of a cancelled ride. The authorization
+// is released and the fee, if any, charged to the passenger and paid to the
+// driver in full. Passengers paying cash are charged fees to their wallet. If
+// the fee cannot be charged, the payment stays authorized.
+func (pay *PaymentService) SettleCancellation(ride *Ride, driver *Driver, fee Money, description string, at time.Time) error {
+	pay.mu.Lock()
+	defer pay.mu.Unlock()
+	payment := ride.Payment
+	if payment != nil && payment.Status != PaymentAuthorized {
+		return fmt.Errorf("%w: ride %d", ErrPaymentNotOpen, ride.ID)
+	}
+	if payment == nil {
+		// Cancelled before a driver accepted, so nothing was authorized
+		payment = &Payment{Method: PaymentMethod{Type: PayByWallet}}
+		if fee == 0 {
+			return nil
+		}
+		ride.Payment = payment
+	}
+	if payment.Method.Type == PayByCash {
+		if err := pay.covers(PassengerWalletAccount(ride.Passenger), 0, fee); err != nil {
+			return err
+		}
+	}
+	source, err := pay.collect(ride, payment, fee, PassengerWalletAccount(ride.Passenger), at)
+	if err != nil {
+		return err
+	}
+	if err := pay.ledger.Post(at, ride.ID, description, debit(source, fee), credit(DriverEarningsAccount(driver), fee)); err != nil {
+		return err
+	}
+	if fee == 0 {
+		payment.Status = PaymentVoided
+	} else {
+		payment.Status, payment.Captured = PaymentCaptured, fee
+	}
+	return nil
+}
+
+// collect releases the authorization of a payment and takes amount from the
+// payment method, returning the account to debit for it. Cash is debited to
+// the cash account given. A wallet that cannot pay amount, even with the
+// authorization released, is left as it was. The caller must hold pay.mu.
+func (pay *PaymentService) collect(ride *Ride, payment *Payment, amount Money, cash Account, at time.Time) (Account, error) {
+	switch payment.Method.Type {
+	case PayByWallet:
+		hold := PassengerHoldAccount(ride.Passenger)
+		wallet := PassengerWalletAccount(ride.Passenger)
+		if err := pay.covers(wallet, payment.Authorized, amount); err != nil {
+			return "", err
+		}
+		if err := pay.ledger.Post(at, ride.ID, "Authorization released", debit(hold, payment.Authorized), credit(wallet, payment.Authorized)); err != nil {
+			return "", err
+		}
+		return wallet, nil
+	case PayByCard:
+		if amount == 0 {
+			return CardAccount, pay.gateway.Void(payment.AuthorizationID)
+		}
+		return CardAccount, pay.gateway.Capture(payment.AuthorizationID, amount)
+	}
+	return cash, nil
+}
+
+// covers fails unless a wallet holds amount, counting the held money about to
+// be released into it.
+func (pay *PaymentService) covers(wallet Account, held, amount Money) error {
+	if available := held - pay.ledger.Balance(wallet); available < amount {
+		return fmt.Errorf("%w: %s available, %s needed", ErrInsufficientFunds, available, amount)
+	}
+	return nil
+}
+
+func openPayment(ride *Ride) (*Payment, error) {
+	if ride.Payment == nil || ride.Payment.Status != PaymentAuthorized {
+		return nil, fmt.Errorf("%w: ride %d", ErrPaymentNotOpen, ride.ID)
+	}
+	return ride.Payment, nil
+}
+
+// ChargeDriver charges a driver a penalty, deducted from their earnings.
+func (pay *PaymentService) ChargeDriver(driver *Driver, rideID int, amount Money, description string, at time.Time) error {
+	return pay.ledger.Post(at, rideID, description, debit(DriverEarningsAccount(driver), amount), credit(PenaltyAccount, amount))
+}
+
+// Refund gives part or all of what a passenger paid for a ride back, taken
+// from the driver and the platform in proportion to their shares. Card
+// payments are refunded to the card, the others to the passenger's wallet.
+func (pay *PaymentService) Refund(ride *Ride, amount Money, description string, at time.Time) error {
+	pay.mu.Lock()
+	defer pay.mu.Unlock()
+	payment := ride.Payment
+	if payment == nil || payment.Status != PaymentCaptured {
+		return fmt.Errorf("%w: ride %d was not charged", ErrRefundExceedsTotal, ride.ID)
+	}
+	if refundable := payment.Captured - payment.Refunded; amount <= 0 || amount > refundable {
+		return fmt.Errorf("%w: %s requested, %s refundable", ErrRefundExceedsTotal, amount, refundable)
+	}
+	commission := Money(math.Round(float64(amount) * float64(payment.Commission) / float64(payment.Captured)))
+	destination := PassengerWalletAccount(ride.Passenger)
+	if payment.Method.Type == PayByCard {
+		if err := pay.gateway.Refund(payment.AuthorizationID, amount); err != nil {
+			return err
+		}
+		destination = CardAccount
+	}
+	err := pay.ledger.Post(at, ride.ID, description,
+		debit(DriverEarningsAccount(ride.Driver), amount-commission),
+		debit(CommissionAccount, commission),
+		credit(destination, amount))
+	if err != nil {
+		return err
+	}
+	payment.Refunded += amount
+	return nil
+}
+
+// PayOut sends a driver what the platform owes them, settling the cash
+// fares they collected against their earnings. It fails if the driver owes
+// the platform instead.
+func (pay *PaymentService) PayOut(driver *Driver, at time.Time) (Money, error) {
+	pay.mu.Lock()
+	defer pay.mu.Unlock()
+	earnings := pay.ledger.Balance(DriverEarningsAccount(driver))
+	cash := pay.ledger.Balance(DriverCashAccount(driver))
+	payout := -(earnings + cash)
+	if payout <= 0 {
+		return 0, fmt.Errorf("%w: %s has a balance of %s", ErrNothingToPayOut, driver.Name, payout)
+	}
+	err := pay.ledger.Post(at, 0, "Payout",
+		debit(DriverEarningsAccount(driver), -earnings),
+		credit(DriverCashAccount(driver), cash),
+		credit(BankAccount, payout))
+	if err != nil {
+		return 0, err
+	}
+	return payout, nil
+}
@@ -0,0 +1,129 @@
+package ridesharingservice
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTopUp(t *testing.T) {
+	tests := []struct {
+		name    string
+		card    string
+		amount  Money
+		balance Money
+		err     error
+	}{
+		{name: "card", card: "tok_visa", amount: 20_00, balance: 20_00},
+		{name: "declined card", card: DeclinedCardToken, amount: 20_00, err: ErrCardDeclined},
+		{name: "zero", card: "tok_visa", amount: 0, err: ErrInvalidAmount},
+		{name: "negative", card: "tok_visa", amount: -5_00, err: ErrInvalidAmount},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pay := NewPaymentService(NewLedger(), NewStubCardGateway())
+			passenger := &Passenger{ID: 1}
+			if err := pay.TopUp(passenger, tt.card, tt.amount, testStart); !errors.Is(err, tt.err) {
+				t.Fatalf("TopUp() error = %v, want %v", err, tt.err)
+			}
+			if got := pay.PassengerWallet(passenger).Balance; got != tt.balance {
+				t.Errorf("balance = %s, want %s", got, tt.balance)
+			}
+		})
+	}
+}
+
+func TestCaptureFromWallet(t *testing.T) {
+	tests := []struct {
+		name    string
+		balance Money   // Wallet balance before the ride
+		fare    float64 // Fare as driven, for an estimate of $8.00
+		err     error
+		left    Money // Wallet balance after the capture
+	}{
+		{name: "fare within hold", balance: 20_00, fare: 9.00, left: 11_00},
+		{name: "fare above hold, wallet covers", balance: 20_00, fare: 15.00, left: 5_00},
+		{name: "fare above wallet", balance: 10_00, fare: 15.00, err: ErrInsufficientFunds, left: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ledger := NewLedger()
+			pay := NewPaymentService(ledger, NewStubCardGateway())
+			passenger := &Passenger{ID: 1, PaymentMethod: PaymentMethod{Type: PayByWallet}}
+			if err := pay.TopUp(passenger, "tok_visa", tt.balance, testStart); err != nil {
+				t.Fatal(err)
+			}
+			ride := &Ride{ID: 1, Passenger: passenger, Driver: &Driver{ID: 1}, EstimatedFare: 8.00}
+			if err := pay.Authorize(ride, testStart); err != nil {
+				t.Fatal(err)
+			}
+
+			ride.Fare = tt.fare
+			if err := pay.Capture(ride, testStart); !errors.Is(err, tt.err) {
+				t.Fatalf("Capture() error = %v, want %v", err, tt.err)
+			}
+			if got := pay.PassengerWallet(passenger).Balance; got != tt.left {
+				t.Errorf("wallet = %s, want %s", got, tt.left)
+			}
+			if tt.err != nil && ride.Payment.Status != PaymentAuthorized {
+				t.Errorf("payment status = %d, want it to stay authorized", ride.Payment.Status)
+			}
+			if got := ledger.TrialBalance(); got != 0 {
+				t.Errorf("trial balance = %s, want $0.00", got)
+			}
+		})
+	}
+}
+
+func TestSettlePaymentAfterTopUp(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  PaymentMethod
+		balance Money // Wallet balance before the ride
+	}{
+		{name: "cash", method: PaymentMethod{Type: PayByCash}},
+		{name: "wallet", method: PaymentMethod{Type: PayByWallet}, balance: 20_00},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestFixture(t)
+			fee := Money(50_00) // More than the wallet and the hold
+			f.rc.CancellationPolicy.LateFee = fee
+			driver := f.driver(1)
+			passenger := f.passenger(t, 1, tt.method, tt.balance)
+			ride := f.accepted(t, passenger, driver)
+			f.clock.Advance(f.rc.CancellationPolicy.FreeWindow + time.Minute)
+			if err := f.rc.PassengerCancelRide(passenger, ride, ReasonChangeOfPlans); err != nil {
+				t.Fatal(err)
+			}
+			if ride.Payment.Status != PaymentAuthorized {
+				t.Fatalf("payment status = %d, want it to stay authorized", ride.Payment.Status)
+			}
+			if err := f.rc.SettlePayment(ride); !errors.Is(err, ErrInsufficientFunds) {
+				t.Fatalf("SettlePayment() error = %v, want %v", err, ErrInsufficientFunds)
+			}
+
+			if err := f.pay.TopUp(passenger, "tok_visa", 60_00, f.clock.Now()); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.rc.SettlePayment(ride); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := f.pay.PassengerWallet(passenger).Balance, tt.balance+60_00-fee; got != want {
+				t.Errorf("wallet = %s, want %s", got, want)
+			}
+			if got := f.pay.DriverWallet(driver).Balance; got != fee {
+				t.Errorf("driver wallet = %s, want %s", got, fee)
+			}
+			if err := f.rc.SettlePayment(ride); !errors.Is(err, ErrPaymentNotOpen) {
+				t.Errorf("second SettlePayment() error = %v, want %v", err, ErrPaymentNotOpen)
+			}
+			if got := f.ledger.TrialBalance(); got != 0 {
+				t.Errorf("trial balance = %s, want $0.00", got)
+			}
+		})
+	}
+}
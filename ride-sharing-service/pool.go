@@ -0,0 +1,255 @@
+package ridesharingservice
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ErrPoolMismatch is returned when a pooled ride does not fit the route or
+// the free seats of a driver's car.
+var ErrPoolMismatch = errors.New("ride does not fit the driver's pool")
+
+// Defaults for pooled rides
+const (
+	DefaultSeatCapacity    = 4
+	DefaultPoolMaxDetourKm = 2.0
+)
+
+func (driver *Driver) seatCapacity() int {
+	if driver.Capacity > 0 {
+		return driver.Capacity
+	}
+	return DefaultSeatCapacity
+}
+
+// poolStop is a pickup or a dropoff on the route of a pool driver.
+type poolStop struct {
+	ride     *Ride
+	pickup   bool
+	location *Location
+}
+
+// pool is the route of a driver carrying pooled rides: the pickups and
+// dropoffs still ahead, in the order the driver makes them.
+type pool struct {
+	driver *Driver
+	stops  []poolStop
+	rides  map[int]*Ride // Ride ID -> Accepted or in progress ride
+}
+
+// riderDistances returns how far the driver travels until the dropoff of
+// every ride among stops, driving from their location through the stops in
+// order. Counting from the driver's location rather than the pickup makes a
+// longer wait for the pickup a detour as well.
+func riderDistances(from *Location, stops []poolStop) (map[int]float64, float64) {
+	distances := make(map[int]float64)
+	total, previous := 0.0, from
+	for _, stop := range stops {
+		total += previous.DistanceTo(stop.location)
+		previous = stop.location
+		if !stop.pickup {
+			distances[stop.ride.ID] = total
+		}
+	}
+	return distances, total
+}
+
+// plan inserts the pickup and dropoff of ride into the route where they
+// lengthen it the least. Every insertion must leave each rider's dropoff, the
+// new one's included, at most maxDetourKm further along the route than
+// without the detour, and never need more seats than the car has. ok is false
+// when nothing fits.
+func (p *pool) plan(from *Location, ride *Ride, maxDetourKm float64) ([]poolStop, bool) {
+	current, _ := riderDistances(from, p.stops)
+	current[ride.ID] = from.DistanceTo(ride.Source) + ride.Source.DistanceTo(ride.Destination)
+
+	// Rides whose pickup is behind the driver are aboard
+	aboard := 0
+	waiting := make(map[int]bool)
+	for _, stop := range p.stops {
+		if stop.pickup {
+			waiting[stop.ride.ID] = true
+		}
+	}
+	for id, r := range p.rides {
+		if !waiting[id] {
+			aboard += r.Seats
+		}
+	}
+
+	var best []poolStop
+	bestLength := math.Inf(1)
+	pickup := poolStop{ride: ride, pickup: true, location: ride.Source}
+	dropoff := poolStop{ride: ride, location: ride.Destination}
+	for i := 0; i <= len(p.stops); i++ {
+		for j := i; j <= len(p.stops); j++ {
+			stops := make([]poolStop, 0, len(p.stops)+2)
+			stops = append(stops, p.stops[:i]...)
+			stops = append(stops, pickup)
+			stops = append(stops, p.stops[i:j]...)
+			stops = append(stops, dropoff)
+			stops = append(stops, p.stops[j:]...)
+			if !fitsSeats(stops, aboard, p.driver.seatCapacity()) {
+				continue
+			}
+			distances, length := riderDistances(from, stops)
+			if length >= bestLength {
+				continue
+			}
+			fits := true
+			for id, distance := range distances {
+				if distance-current[id] > maxDetourKm {
+					fits = false
+					break
+				}
+			}
+			if fits {
+				best, bestLength = stops, length
+			}
+		}
+	}
+	return best, best != nil
+}
+
+// fitsSeats reports whether the riders aboard at every point of the route fit
+// the car.
+func fitsSeats(stops []poolStop, aboard, capacity int) bool {
+	for _, stop := range stops {
+		if stop.pickup {
+			aboard += stop.ride.Seats
+		} else {
+			aboard -= stop.ride.Seats
+		}
+		if aboard > capacity {
+			return false
+		}
+	}
+	return true
+}
+
+// poolCandidates returns the pool drivers within the match radius whose
+// route the ride fits, closest first.
+func (rc *RideCoordinator) poolCandidates(ride *Ride) []*Driver {
+	rc.poolMu.Lock()
+	var drivers []*Driver
+	for _, p := range rc.pools {
+		if p.driver.VehicleClass.orEconomy() == ride.VehicleClass {
+			drivers = append(drivers, p.driver)
+		}
+	}
+	rc.poolMu.Unlock()
+
+	locations := make(map[int]*Location, len(drivers))
+	candidates := drivers[:0]
+	for _, driver := range drivers {
+		location := rc.driverService.location(driver)
+		if ride.Source.DistanceTo(location) > rc.MatchRadiusKm {
+			continue
+		}
+		rc.poolMu.Lock()
+		p := rc.pools[driver.ID]
+		fits := false
+		if p != nil {
+			_, fits = p.plan(location, ride, rc.PoolMaxDetourKm)
+		}
+		rc.poolMu.Unlock()
+		if fits {
+			locations[driver.ID] = location
+			candidates = append(candidates, driver)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return ride.Source.DistanceTo(locations[candidates[i].ID]) < ride.Source.DistanceTo(locations[candidates[j].ID])
+	})
+	return candidates
+}
+
+// joinPool adds a pooled ride to the driver's route. An available driver
+// starts a new pool and becomes busy; a pool driver takes the ride only if it
+// still fits their route.
+func (rc *RideCoordinator) joinPool(driver *Driver, ride *Ride) error {
+	from := rc.driverService.location(driver)
+	rc.poolMu.Lock()
+	defer rc.poolMu.Unlock()
+	p := rc.pools[driver.ID]
+	if p == nil {
+		if err := rc.driverService.reserve(driver); err != nil {
+			return err
+		}
+		p = &pool{driver: driver, rides: make(map[int]*Ride)}
+		rc.pools[driver.ID] = p
+	}
+	stops, ok := p.plan(from, ride, rc.PoolMaxDetourKm)
+	if !ok {
+		if len(p.rides) == 0 {
+			delete(rc.pools, driver.ID)
+			rc.driverService.release(driver)
+		}
+		return fmt.Errorf("%w: %s, ride %d", ErrPoolMismatch, driver.Name, ride.ID)
+	}
+	p.stops = stops
+	p.rides[ride.ID] = ride
+	return nil
+}
+
+// leavePool removes a ride from the driver's route once it is completed or
+// cancelled. The driver is available again when their pool is empty.
+func (rc *RideCoordinator) leavePool(driver *Driver, ride *Ride) {
+	rc.poolMu.Lock()
+	defer rc.poolMu.Unlock()
+	p := rc.pools[driver.ID]
+	if p == nil {
+		return
+	}
+	p.removeStops(ride, func(poolStop) bool { return true })
+	delete(p.rides, ride.ID)
+	if len(p.rides) == 0 {
+		delete(rc.pools, driver.ID)
+		rc.driverService.release(driver)
+	}
+}
+
+// pickedUp removes the pickup of a ride from the driver's route.
+func (rc *RideCoordinator) pickedUp(driver *Driver, ride *Ride) {
+	rc.poolMu.Lock()
+	defer rc.poolMu.Unlock()
+	if p := rc.pools[driver.ID]; p != nil {
+		p.removeStops(ride, func(stop poolStop) bool { return stop.pickup })
+	}
+}
+
+func (p *pool) removeStops(ride *Ride, match func(poolStop) bool) {
+	stops := p.stops[:0]
+	for _, stop := range p.stops {
+		if stop.ride != ride || !match(stop) {
+			stops = append(stops, stop)
+		}
+	}
+	p.stops = stops
+}
+
+// releaseDriver frees the driver of a ride that is completed or cancelled.
+func (rc *RideCoordinator) releaseDriver(driver *Driver, ride *Ride) {
+	if ride.Pool {
+		rc.leavePool(driver, ride)
+		return
+	}
+	rc.driverService.release(driver)
+}
+
+// PoolRoute returns the stops ahead of a pool driver, in order.
+func (rc *RideCoordinator) PoolRoute(driver *Driver) []*Location {
+	rc.poolMu.Lock()
+	defer rc.poolMu.Unlock()
+	p := rc.pools[driver.ID]
+	if p == nil {
+		return nil
+	}
+	route := make([]*Location, len(p.stops))
+	for i, stop := range p.stops {
+		route[i] = stop.location
+	}
+	return route
+}
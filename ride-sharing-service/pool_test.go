@@ -0,0 +1,139 @@
+package ridesharingservice
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+// east returns the location km kilometres east of from.
+func east(from *Location, km float64) *Location {
+	kmPerDegree := math.Pi * earthRadiusKm / 180 * math.Cos(radians(from.Latitude))
+	return &Location{Latitude: from.Latitude, Longitude: from.Longitude + km/kmPerDegree}
+}
+
+func TestPoolPlan(t *testing.T) {
+	// Ride a waits to be picked up at the driver's location and goes 5 km north.
+	tests := []struct {
+		name     string
+		capacity int
+		seats    int // Seats ride a takes
+		source   *Location
+		dest     *Location
+		route    string // Stops of the plan, "" if the ride does not fit
+	}{
+		{name: "on the way", source: north(testPickup, 1), dest: north(testPickup, 4), route: "a+ b+ b- a-"},
+		{name: "beyond the dropoff", source: north(testPickup, 4), dest: north(testPickup, 6), route: "a+ b+ a- b-"},
+		{name: "further along after the dropoff", source: north(testPickup, 7), dest: north(testPickup, 9), route: "a+ a- b+ b-"},
+		{name: "back along after the dropoff", source: north(testPickup, 1.5), dest: testPickup},
+		{name: "too far off the route", source: north(testPickup, 1), dest: east(north(testPickup, 1), 5)},
+		{name: "detour for the first rider", source: east(testPickup, 2.5), dest: east(north(testPickup, 5), 2.5)},
+		{name: "no seat left on the way", capacity: 2, seats: 2, source: north(testPickup, 1), dest: north(testPickup, 4)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &Ride{ID: 1, Source: testPickup, Destination: north(testPickup, 5), Seats: max(tt.seats, 1)}
+			b := &Ride{ID: 2, Source: tt.source, Destination: tt.dest, Seats: 1}
+			p := &pool{
+				driver: &Driver{ID: 1, Capacity: tt.capacity},
+				stops:  []poolStop{{ride: a, pickup: true, location: a.Source}, {ride: a, location: a.Destination}},
+				rides:  map[int]*Ride{a.ID: a},
+			}
+
+			stops, ok := p.plan(testPickup, b, DefaultPoolMaxDetourKm)
+			var route []string
+			for _, stop := range stops {
+				name := map[*Ride]string{a: "a", b: "b"}[stop.ride]
+				if stop.pickup {
+					route = append(route, name+"+")
+				} else {
+					route = append(route, name+"-")
+				}
+			}
+			if got := strings.Join(route, " "); got != tt.route || ok != (tt.route != "") {
+				t.Errorf("plan() = %q, %t, want %q", got, ok, tt.route)
+			}
+		})
+	}
+}
+
+func TestPooledRides(t *testing.T) {
+	tests := []struct {
+		name           string
+		source, dest   float64 // Kilometres north of the pickup of the second ride
+		first, second  float64 // Kilometres each rider pays for
+		sharedFirstLeg bool
+	}{
+		{name: "same route", source: 0, dest: 4, first: 2, second: 2},
+		{name: "on the way", source: 1, dest: 3, first: 3, second: 1},
+		{name: "beyond the first dropoff", source: 2, dest: 5, first: 3, second: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestFixture(t)
+			driver := f.driver(1)
+			pool := RideOptions{Pool: true}
+			first, err := f.rc.RequestRideWithOptions(f.passenger(t, 1, PaymentMethod{Type: PayByCash}, 0), testPickup, north(testPickup, 4), pool)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mustStep(t, f.rc.AcceptRide(driver, first))
+			second, err := f.rc.RequestRideWithOptions(f.passenger(t, 2, PaymentMethod{Type: PayByCash}, 0), north(testPickup, tt.source), north(testPickup, tt.dest), pool)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mustStep(t, f.rc.AcceptRide(driver, second))
+			if route := f.rc.PoolRoute(driver); len(route) != 4 {
+				t.Fatalf("route has %d stops, want 4", len(route))
+			}
+
+			// Drive north, picking up and dropping off on the way.
+			mustStep(t, f.rc.StartRide(driver, first))
+			f.rc.UpdateDriverLocation(driver, second.Source)
+			mustStep(t, f.rc.StartRide(driver, second))
+			rides := []*Ride{first, second}
+			if tt.dest > 4 {
+				rides = []*Ride{second, first}
+			}
+			for i := len(rides) - 1; i >= 0; i-- {
+				ride := rides[i]
+				f.rc.UpdateDriverLocation(driver, ride.Destination)
+				mustStep(t, f.rc.CompleteRide(ride))
+				if busy := driver.Status == Busy; busy != (i > 0) {
+					t.Errorf("driver status after dropping off %d of 2 = %s", 2-i, driver.Status)
+				}
+			}
+
+			for _, ride := range []struct {
+				name string
+				ride *Ride
+				km   float64
+			}{{"first", first, tt.first}, {"second", second, tt.second}} {
+				if !near(ride.ride.SplitDistance, ride.km) {
+					t.Errorf("%s rider pays for %.2f km, want %.2f km", ride.name, ride.ride.SplitDistance, ride.km)
+				}
+			}
+		})
+	}
+
+	t.Run("too far off the route", func(t *testing.T) {
+		f := newTestFixture(t)
+		driver := f.driver(1)
+		pool := RideOptions{Pool: true}
+		first, err := f.rc.RequestRideWithOptions(f.passenger(t, 1, PaymentMethod{Type: PayByCash}, 0), testPickup, north(testPickup, 4), pool)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mustStep(t, f.rc.AcceptRide(driver, first))
+		second, err := f.rc.RequestRideWithOptions(f.passenger(t, 2, PaymentMethod{Type: PayByCash}, 0), testPickup, east(testPickup, 4), pool)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.rc.AcceptRide(driver, second); !errors.Is(err, ErrNoOffer) {
+			t.Errorf("AcceptRide() error = %v, want %v", err, ErrNoOffer)
+		}
+	})
+}
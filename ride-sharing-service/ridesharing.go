@@ -1,8 +1,8 @@
 package ridesharingservice
 
 import (
+	"errors"
 	"fmt"
-	"math/rand"
 	"sync"
 	"time"
 )
@@ -28,14 +28,17 @@ const (
 	InProgress
 	Completed
 	Cancelled
+	Unmatched // No driver accepted the ride
+	Scheduled // Booked for later, not yet offered to drivers
 )
 
 // Domain Models
 type Passenger struct {
-	ID       int
-	Name     string
-	Contact  string
-	Location *Location
+	ID            int
+	Name          string
+	Contact       string
+	Location      *Location
+	PaymentMethod PaymentMethod // Cash if unset
 }
 
 type Driver struct {
@@ -43,6 +46,8 @@ type Driver struct {
 	Name         string
 	Contact      string
 	LicensePlate string
+	VehicleClass VehicleClass // Economy if unset
+	Capacity     int          // Passenger seats, DefaultSeatCapacity if unset
 	Location     *Location
 	Status       DriverStatus
 }
@@ -55,6 +60,43 @@ type Ride struct {
 	Destination *Location
 	Status      RideStatus
 	Fare        float64
+	PickupAt    time.Time // When a scheduled ride picks the passenger up
+
+	Cancellation *Cancellation // Who cancelled the ride and what it cost, once cancelled
+	Payment      *Payment      // Authorized once a driver accepts
+
+	VehicleClass    VehicleClass
+	Pool            bool // Shared with other passengers going the same way
+	Seats           int
+	SurgeMultiplier float64        // Applied to the fare, fixed when the ride is requested
+	FareBreakdown   *FareBreakdown // Items of Fare, once the ride is completed
+
+	// Upfront estimate, made when the ride is requested
+	EstimatedDistance float64 // Kilometres
+	EstimatedDuration float64 // Minutes
+	EstimatedFare     float64
+
+	// Trip as driven
+	Path      []*Location // Driver locations from start to completion
+	Distance  float64     // Kilometres
+	Duration  float64     // Minutes
+	Tolls     float64     // Tolls paid on the way
+	ArrivedAt time.Time   // When the driver reached the pickup
+
+	// The passenger's share of the trip: every leg is split evenly among
+	// the riders aboard, so a ride alone pays for all of it
+	SplitDistance float64
+	SplitDuration float64
+	splitAt       time.Time // When SplitDuration was last brought up to date
+
+	// When the ride entered each status
+	ScheduledAt time.Time
+	RequestedAt time.Time
+	AcceptedAt  time.Time
+	StartedAt   time.Time
+	CompletedAt time.Time
+	CancelledAt time.Time
+	UnmatchedAt time.Time
 }
 
 // PassengerService
@@ -76,17 +118,52 @@ func (ps *PassengerService) AddPassenger(passenger *Passenger) {
 // DriverService
 type DriverService struct {
 	drivers map[int]*Driver
+	index   *geoIndex // Drivers by the geohash cell of their location
 	mu      sync.Mutex
 }
 
+// driverCellPrecision is the geohash length of the cells drivers are indexed
+// by; cells of 5 characters are roughly 5km across.
+const driverCellPrecision = 5
+
 func NewDriverService() *DriverService {
-	return &DriverService{drivers: make(map[int]*Driver)}
+	return &DriverService{drivers: make(map[int]*Driver), index: newGeoIndex(driverCellPrecision)}
 }
 
 func (ds *DriverService) AddDriver(driver *Driver) {
 	ds.mu.Lock()
 	defer ds.mu.Unlock()
 	ds.drivers[driver.ID] = driver
+	if driver.Location != nil {
+		ds.index.update(driver)
+	}
+}
+
+// UpdateDriverLocation moves a driver and keeps the spatial index current.
+func (ds *DriverService) UpdateDriverLocation(driver *Driver, location *Location) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	driver.Location = location
+	if _, exists := ds.drivers[driver.ID]; exists {
+		ds.index.update(driver)
+	}
+}
+
+// location returns where a driver last reported to be.
+func (ds *DriverService) location(driver *Driver) *Location {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return driver.Location
+}
+
+// FindNearestDrivers returns up to limit available drivers within radiusKm of
+// location, closest first.
+func (ds *DriverService) FindNearestDrivers(location *Location, radiusKm float64, limit int) []*Driver {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.index.nearest(location, radiusKm, limit, func(driver *Driver) bool {
+		return driver.Status == Available
+	})
 }
 
 func (ds *DriverService) GetAvailableDrivers() []*Driver {
@@ -104,11 +181,12 @@ func (ds *DriverService) GetAvailableDrivers() []*Driver {
 // RideService
 type RideService struct {
 	rides map[int]*Ride
+	trips map[int][]*Ride // Driver ID -> Rides in progress, more than one when pooling
 	mu    sync.Mutex
 }
 
 func NewRideService() *RideService {
-	return &RideService{rides: make(map[int]*Ride)}
+	return &RideService{rides: make(map[int]*Ride), trips: make(map[int][]*Ride)}
 }
 
 func (rs *RideService) AddRide(ride *Ride) {
@@ -117,12 +195,110 @@ func (rs *RideService) AddRide(ride *Ride) {
 	rs.rides[ride.ID] = ride
 }
 
-func (rs *RideService) UpdateRideStatus(rideID int, status RideStatus) {
+func (rs *RideService) GetRide(rideID int) (*Ride, error) {
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
-	if ride, exists := rs.rides[rideID]; exists {
-		ride.Status = status
+	ride, exists := rs.rides[rideID]
+	if !exists {
+		return nil, fmt.Errorf("%w: %d", ErrRideNotFound, rideID)
+	}
+	return ride, nil
+}
+
+func (rs *RideService) GetRideStatus(rideID int) (RideStatus, error) {
+	ride, err := rs.GetRide(rideID)
+	if err != nil {
+		return 0, err
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return ride.Status, nil
+}
+
+func (rs *RideService) UpdateRideStatus(rideID int, status RideStatus) error {
+	ride, err := rs.GetRide(rideID)
+	if err != nil {
+		return err
+	}
+	return rs.transition(ride, status, time.Now(), nil)
+}
+
+// startTrip begins recording the path of ride from where it starts. The
+// caller must hold rs.mu.
+func (rs *RideService) startTrip(ride *Ride, start *Location, at time.Time) {
+	rs.splitTime(ride.Driver.ID, at)
+	ride.Path = []*Location{start}
+	ride.splitAt = at
+	rs.trips[ride.Driver.ID] = append(rs.trips[ride.Driver.ID], ride)
+}
+
+// splitTime splits the time since the last split among the riders aboard.
+// The caller must hold rs.mu.
+func (rs *RideService) splitTime(driverID int, at time.Time) {
+	rides := rs.trips[driverID]
+	for _, ride := range rides {
+		ride.SplitDuration += at.Sub(ride.splitAt).Minutes() / float64(len(rides))
+		ride.splitAt = at
+	}
+}
+
+// recordLocation adds a location to the paths of the driver's rides in
+// progress, if any, and splits the leg driven among them.
+func (rs *RideService) recordLocation(driver *Driver, location *Location, at time.Time) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rides := rs.trips[driver.ID]
+	rs.splitTime(driver.ID, at)
+	for _, ride := range rides {
+		ride.SplitDistance += ride.Path[len(ride.Path)-1].DistanceTo(location) / float64(len(rides))
+		ride.Path = append(ride.Path, location)
+	}
+}
+
+// endTrip stops recording the path of ride and measures the trip. The caller
+// must hold rs.mu.
+func (rs *RideService) endTrip(ride *Ride, at time.Time) {
+	rs.splitTime(ride.Driver.ID, at)
+	rides := rs.trips[ride.Driver.ID]
+	for i, r := range rides {
+		if r == ride {
+			rides = append(rides[:i], rides[i+1:]...)
+			break
+		}
+	}
+	if len(rides) == 0 {
+		delete(rs.trips, ride.Driver.ID)
+	} else {
+		rs.trips[ride.Driver.ID] = rides
+	}
+	ride.Distance, ride.Duration = tripDistance(ride), at.Sub(ride.StartedAt).Minutes()
+}
+
+// billableTrip returns the distance and duration ride is charged for if it
+// ends at the given time: its share of the trip. The caller must hold rs.mu.
+func (rs *RideService) billableTrip(ride *Ride, at time.Time) (float64, float64) {
+	distance := ride.SplitDistance
+	if len(ride.Path) < 2 {
+		distance = tripDistance(ride)
+	}
+	duration := ride.SplitDuration
+	if riders := len(rs.trips[ride.Driver.ID]); riders > 0 {
+		duration += at.Sub(ride.splitAt).Minutes() / float64(riders)
+	}
+	return distance, duration
+}
+
+// tripDistance is the length of the recorded path, or the straight-line
+// distance between source and destination when too little was recorded.
+func tripDistance(ride *Ride) float64 {
+	if len(ride.Path) < 2 {
+		return ride.Source.DistanceTo(ride.Destination)
+	}
+	distance := 0.0
+	for i := 1; i < len(ride.Path); i++ {
+		distance += ride.Path[i-1].DistanceTo(ride.Path[i])
 	}
+	return distance
 }
 
 // NotificationService
@@ -146,74 +322,350 @@ func (fc *FareCalculator) CalculateFare(distance, duration float64) float64 {
 	return baseFare + (distance * perKmFare) + (duration * perMinuteFare)
 }
 
+// Defaults for matching riders with drivers
+const (
+	DefaultMatchRadiusKm = 5.0
+	DefaultMatchLimit    = 3
+)
+
 // RideCoordinator
 type RideCoordinator struct {
+	MatchRadiusKm float64       // Drivers farther than this from the pickup are not offered a ride
+	MatchLimit    int           // Number of nearest drivers a ride is offered to at a time
+	OfferTimeout  time.Duration // How long a wave of drivers has to accept
+	MaxOfferWaves int           // Waves of offers before the ride is Unmatched
+	Surge         *SurgeEngine  // Prices demand per zone; nil disables surge pricing
+
+	PoolMaxDetourKm  float64       // How much longer a pooled ride may get to pick up others
+	ScheduleLeadTime time.Duration // How long before pickup a scheduled ride is offered to drivers
+	Clock            Clock         // Source of timestamps and timers
+
+	CancellationPolicy CancellationPolicy
+
 	passengerService    *PassengerService
 	driverService       *DriverService
 	rideService         *RideService
 	notificationService *NotificationService
-	fareCalculator      *FareCalculator
-	requestedRides      chan *Ride
+	fareStrategy        FareStrategy
+	paymentService      *PaymentService
+
+	dispatches map[int]*dispatch // Ride ID -> Dispatch of a ride waiting for a driver
+	dispatchMu sync.Mutex
+
+	pools  map[int]*pool // Driver ID -> Route of a driver with pooled rides
+	poolMu sync.Mutex
+
+	scheduled  map[int]*scheduledRide // Ride ID -> Scheduled ride waiting for its dispatch time
+	scheduleMu sync.Mutex
+
+	driverCancellations map[int]*driverCancellations // Driver ID -> Rides the driver cancelled
+	cancelMu            sync.Mutex
 }
 
-func NewRideCoordinator(ps *PassengerService, ds *DriverService, rs *RideService, ns *NotificationService, fc *FareCalculator) *RideCoordinator {
+func NewRideCoordinator(ps *PassengerService, ds *DriverService, rs *RideService, ns *NotificationService, fs FareStrategy, pay *PaymentService) *RideCoordinator {
 	return &RideCoordinator{
+		MatchRadiusKm:       DefaultMatchRadiusKm,
+		MatchLimit:          DefaultMatchLimit,
+		OfferTimeout:        DefaultOfferTimeout,
+		MaxOfferWaves:       DefaultMaxOfferWaves,
+		PoolMaxDetourKm:     DefaultPoolMaxDetourKm,
+		ScheduleLeadTime:    DefaultScheduleLeadTime,
+		Clock:               SystemClock{},
+		CancellationPolicy:  DefaultCancellationPolicy(),
 		passengerService:    ps,
 		driverService:       ds,
 		rideService:         rs,
 		notificationService: ns,
-		fareCalculator:      fc,
-		requestedRides:      make(chan *Ride, 10),
+		fareStrategy:        fs,
+		paymentService:      pay,
+		dispatches:          make(map[int]*dispatch),
+		pools:               make(map[int]*pool),
+		scheduled:           make(map[int]*scheduledRide),
+		driverCancellations: make(map[int]*driverCancellations),
 	}
 }
 
-func (rc *RideCoordinator) RequestRide(passenger *Passenger, source, destination *Location) {
-	ride := &Ride{
-		ID:          int(time.Now().UnixNano()),
-		Passenger:   passenger,
-		Source:      source,
-		Destination: destination,
-		Status:      Requested,
+// RideOptions are the choices a passenger makes when requesting a ride.
+type RideOptions struct {
+	VehicleClass VehicleClass // Economy if unset
+	Pool         bool         // Share the car with other passengers for a split fare
+	Seats        int          // Seats needed in a pooled ride, 1 if unset
+}
+
+// averageSpeedKmh is the speed used to estimate trip durations.
+const averageSpeedKmh = 30.0
+
+// RequestRide creates an economy ride and starts offering it to nearby
+// drivers.
+func (rc *RideCoordinator) RequestRide(passenger *Passenger, source, destination *Location) (*Ride, error) {
+	return rc.RequestRideWithOptions(passenger, source, destination, RideOptions{})
+}
+
+// RequestRideWithOptions creates a ride and starts offering it to nearby
+// drivers of the requested vehicle class.
+func (rc *RideCoordinator) RequestRideWithOptions(passenger *Passenger, source, destination *Location, options RideOptions) (*Ride, error) {
+	ride := rc.newRide(passenger, source, destination, options)
+	ride.Status = Requested
+	ride.RequestedAt = rc.Clock.Now()
+	if err := rc.quote(ride); err != nil {
+		return nil, err
 	}
 	rc.rideService.AddRide(ride)
-	rc.requestedRides <- ride
-	// Notify available drivers
-	for _, driver := range rc.driverService.GetAvailableDrivers() {
-		fmt.Printf("Notifying driver %s about ride request: %d\n", driver.Name, ride.ID)
+	rc.notifyQuote(ride)
+	rc.startDispatch(ride)
+	return ride, nil
+}
+
+func (rc *RideCoordinator) newRide(passenger *Passenger, source, destination *Location, options RideOptions) *Ride {
+	return &Ride{
+		ID:              int(time.Now().UnixNano()),
+		Passenger:       passenger,
+		Source:          source,
+		Destination:     destination,
+		VehicleClass:    options.VehicleClass.orEconomy(),
+		Pool:            options.Pool,
+		Seats:           max(options.Seats, 1),
+		SurgeMultiplier: 1,
 	}
 }
 
-func (rc *RideCoordinator) AcceptRide(driver *Driver, ride *Ride) {
-	driver.Status = Busy
-	ride.Driver = driver
-	ride.Status = Accepted
+// quote prices a ride about to be offered to drivers, counting it as demand
+// for surge pricing.
+func (rc *RideCoordinator) quote(ride *Ride) error {
+	if rc.Surge != nil {
+		rc.Surge.RecordRequest(ride)
+		ride.SurgeMultiplier = rc.Surge.Multiplier(ride.Source, ride.RequestedAt)
+	}
+	if err := rc.estimate(ride, ride.RequestedAt); err != nil {
+		rc.resolveDemand(ride)
+		return err
+	}
+	return nil
+}
+
+// estimate quotes the straight-line trip of a ride starting at the given
+// time, driven at an average city speed.
+func (rc *RideCoordinator) estimate(ride *Ride, start time.Time) error {
+	distance := ride.Source.DistanceTo(ride.Destination)
+	duration := distance / averageSpeedKmh * 60
+	estimate, err := rc.fareStrategy.Calculate(Trip{
+		Pickup:          ride.Source,
+		VehicleClass:    ride.VehicleClass,
+		Distance:        distance,
+		Duration:        duration,
+		StartTime:       start,
+		SurgeMultiplier: ride.SurgeMultiplier,
+	})
+	if err != nil {
+		return err
+	}
+	ride.EstimatedDistance, ride.EstimatedDuration, ride.EstimatedFare = distance, duration, estimate.Total
+	return nil
+}
+
+func (rc *RideCoordinator) notifyQuote(ride *Ride) {
+	quote := fmt.Sprintf("Estimated fare: $%.2f for %.1f km, about %.0f min", ride.EstimatedFare, ride.EstimatedDistance, ride.EstimatedDuration)
+	if ride.SurgeMultiplier > 1 {
+		quote = fmt.Sprintf("Surge pricing %.1fx in effect. %s", ride.SurgeMultiplier, quote)
+	}
+	rc.notificationService.NotifyPassenger(ride.Passenger, quote)
+}
+
+// AcceptRide assigns a requested ride to a driver who was offered it and
+// authorizes its fare. The driver is busy until the ride is completed or
+// cancelled. If the passenger's payment is declined, the ride stays open for
+// other drivers.
+func (rc *RideCoordinator) AcceptRide(driver *Driver, ride *Ride) error {
+	err := rc.claimOffer(driver, ride, func() error {
+		reserve := rc.driverService.reserve
+		if ride.Pool {
+			reserve = func(driver *Driver) error { return rc.joinPool(driver, ride) }
+		}
+		if err := reserve(driver); err != nil {
+			return err
+		}
+		now := rc.Clock.Now()
+		err := rc.rideService.transition(ride, Accepted, now, func() error {
+			if err := rc.paymentService.Authorize(ride, now); err != nil {
+				return err
+			}
+			ride.Driver = driver
+			return nil
+		})
+		if err != nil {
+			rc.releaseDriver(driver, ride)
+		}
+		return err
+	})
+	if errors.Is(err, ErrCardDeclined) || errors.Is(err, ErrInsufficientFunds) {
+		rc.notificationService.NotifyPassenger(ride.Passenger, fmt.Sprintf("Payment for ride %d was declined, please check your payment method: %v", ride.ID, err))
+	}
+	if err != nil {
+		return err
+	}
 	rc.notificationService.NotifyPassenger(ride.Passenger, fmt.Sprintf("Your ride has been accepted by driver: %s", driver.Name))
+	return nil
 }
 
-func (rc *RideCoordinator) StartRide(driver *Driver, ride *Ride) {
-	driver.Status = Busy
-	ride.Driver = driver
-	ride.Status = InProgress
+func (rc *RideCoordinator) StartRide(driver *Driver, ride *Ride) error {
+	start := rc.driverService.location(driver)
+	now := rc.Clock.Now()
+	err := rc.rideService.transition(ride, InProgress, now, func() error {
+		if ride.Driver != driver {
+			return fmt.Errorf("%w: %s, ride %d", ErrWrongDriver, driver.Name, ride.ID)
+		}
+		rc.rideService.startTrip(ride, start, now)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if ride.Pool {
+		rc.pickedUp(driver, ride)
+	}
 	rc.notificationService.NotifyPassenger(ride.Passenger, fmt.Sprintf("Your ride has been started by driver: %s", driver.Name))
+	return nil
 }
 
-func (rc *RideCoordinator) CancelRide(driver *Driver, ride *Ride) {
-	driver.Status = Busy
-	ride.Driver = driver
-	ride.Status = Cancelled
-	rc.notificationService.NotifyPassenger(ride.Passenger, fmt.Sprintf("Your ride has been cancelled. Driver: %s", driver.Name))
+// CancelRide lets the assigned driver cancel a ride before it starts, without
+// giving a reason. The driver becomes available again.
+func (rc *RideCoordinator) CancelRide(driver *Driver, ride *Ride) error {
+	return rc.DriverCancelRide(driver, ride, ReasonOther)
 }
 
-func (rc *RideCoordinator) CompleteRide(ride *Ride) {
-	distance := rand.Float64() * 10 // Mocked distance
-	duration := distance / 30 * 60  // Mocked duration
-	fare := rc.fareCalculator.CalculateFare(distance, duration)
-	ride.Status = Completed
-	ride.Fare = fare
-	ride.Driver.Status = Available
+// DriverArrived records that the assigned driver reached the pickup. Waiting
+// time is charged from then until the ride starts.
+func (rc *RideCoordinator) DriverArrived(driver *Driver, ride *Ride) error {
+	err := rc.rideService.update(ride, Accepted, func() error {
+		if ride.Driver != driver {
+			return fmt.Errorf("%w: %s, ride %d", ErrWrongDriver, driver.Name, ride.ID)
+		}
+		ride.ArrivedAt = rc.Clock.Now()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	rc.notificationService.NotifyPassenger(ride.Passenger, fmt.Sprintf("Your driver %s has arrived", driver.Name))
+	return nil
+}
 
-	rc.notificationService.NotifyPassenger(ride.Passenger, fmt.Sprintf("Your ride is completed. Fare: $%.2f", fare))
-	rc.notificationService.NotifyDriver(ride.Driver, fmt.Sprintf("Ride completed. Fare: $%.2f", fare))
+// AddToll adds a toll the driver paid during the ride to its fare.
+func (rc *RideCoordinator) AddToll(driver *Driver, ride *Ride, amount float64) error {
+	return rc.rideService.update(ride, InProgress, func() error {
+		if ride.Driver != driver {
+			return fmt.Errorf("%w: %s, ride %d", ErrWrongDriver, driver.Name, ride.ID)
+		}
+		ride.Tolls += amount
+		return nil
+	})
+}
+
+// UpdateDriverLocation moves a driver and, while they are on a trip, adds the
+// location to the ride's path.
+func (rc *RideCoordinator) UpdateDriverLocation(driver *Driver, location *Location) {
+	rc.driverService.UpdateDriverLocation(driver, location)
+	rc.rideService.recordLocation(driver, location, rc.Clock.Now())
+}
+
+// CompleteRide ends a ride in progress, captures the fare and makes the driver
+// available again.
+func (rc *RideCoordinator) CompleteRide(ride *Ride) error {
+	now := rc.Clock.Now()
+	var breakdown *FareBreakdown
+	err := rc.rideService.transition(ride, Completed, now, func() error {
+		trip := Trip{
+			Pickup:          ride.Source,
+			VehicleClass:    ride.VehicleClass,
+			Pool:            ride.Pool,
+			Tolls:           ride.Tolls,
+			StartTime:       ride.StartedAt,
+			SurgeMultiplier: ride.SurgeMultiplier,
+		}
+		trip.Distance, trip.Duration = rc.rideService.billableTrip(ride, now)
+		if !ride.ArrivedAt.IsZero() {
+			trip.WaitingTime = ride.StartedAt.Sub(ride.ArrivedAt).Minutes()
+		}
+		var err error
+		if breakdown, err = rc.fareStrategy.Calculate(trip); err != nil {
+			return err
+		}
+		rc.rideService.endTrip(ride, now)
+		ride.Fare = breakdown.Total
+		ride.FareBreakdown = breakdown
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	rc.releaseDriver(ride.Driver, ride)
+
+	rc.notificationService.NotifyPassenger(ride.Passenger, fmt.Sprintf("Your ride is completed. Distance: %.1f km, Fare: $%.2f\n%s", ride.Distance, breakdown.Total, breakdown))
+	rc.notificationService.NotifyDriver(ride.Driver, fmt.Sprintf("Ride completed. Fare: $%.2f", breakdown.Total))
+	if err := rc.paymentService.Capture(ride, now); err != nil {
+		rc.notifyPaymentFailed(ride, err)
+		return nil
+	}
+	if ride.Payment.Method.Type == PayByCash {
+		rc.notificationService.NotifyPassenger(ride.Passenger, fmt.Sprintf("Please pay %s in cash to your driver", ride.Payment.Captured))
+	} else {
+		rc.notificationService.NotifyPassenger(ride.Passenger, fmt.Sprintf("%s charged to your %s", ride.Payment.Captured, ride.Payment.Method.Type))
+	}
+	return nil
+}
+
+// notifyPaymentFailed tells the passenger a charge for their ride failed. The
+// ride itself stands; the payment stays open until SettlePayment charges it.
+func (rc *RideCoordinator) notifyPaymentFailed(ride *Ride, err error) {
+	rc.notificationService.NotifyPassenger(ride.Passenger, fmt.Sprintf("Payment for ride %d failed: %v", ride.ID, err))
+}
+
+// SettlePayment charges a completed or cancelled ride whose payment failed
+// before, for instance once the passenger has topped up their wallet.
+func (rc *RideCoordinator) SettlePayment(ride *Ride) error {
+	status, err := rc.rideService.GetRideStatus(ride.ID)
+	if err != nil {
+		return err
+	}
+	now := rc.Clock.Now()
+	switch {
+	case status == Completed:
+		err = rc.paymentService.Capture(ride, now)
+	case status == Cancelled && ride.Cancellation != nil && ride.Driver != nil:
+		description := "Late cancellation fee"
+		if ride.Cancellation.Reason == ReasonPassengerNoShow {
+			description = "No-show fee"
+		}
+		err = rc.paymentService.SettleCancellation(ride, ride.Driver, ride.Cancellation.Fee, description, now)
+	default:
+		return fmt.Errorf("%w: ride %d is %s", ErrPaymentNotOpen, ride.ID, status)
+	}
+	if err != nil {
+		return err
+	}
+	rc.notificationService.NotifyPassenger(ride.Passenger, fmt.Sprintf("Payment for ride %d settled: %s charged", ride.ID, ride.Payment.Captured))
+	return nil
+}
+
+// RefundRide gives a passenger back part or all of what they paid for a
+// ride.
+func (rc *RideCoordinator) RefundRide(ride *Ride, amount Money, reason string) error {
+	if err := rc.paymentService.Refund(ride, amount, "Refund: "+reason, rc.Clock.Now()); err != nil {
+		return err
+	}
+	rc.notificationService.NotifyPassenger(ride.Passenger, fmt.Sprintf("%s refunded for ride %d: %s", amount, ride.ID, reason))
+	rc.notificationService.NotifyDriver(ride.Driver, fmt.Sprintf("Ride %d was refunded %s: %s", ride.ID, amount, reason))
+	return nil
+}
+
+// PayOutDriver sends a driver what they are owed.
+func (rc *RideCoordinator) PayOutDriver(driver *Driver) (Money, error) {
+	payout, err := rc.paymentService.PayOut(driver, rc.Clock.Now())
+	if err != nil {
+		return 0, err
+	}
+	rc.notificationService.NotifyDriver(driver, fmt.Sprintf("Payout of %s sent to your bank", payout))
+	return payout, nil
 }
 
 // Main function
@@ -222,25 +674,308 @@ func Run() {
 	driverService := NewDriverService()
 	rideService := NewRideService()
 	notificationService := &NotificationService{}
-	fareCalculator := &FareCalculator{}
+	rateCards, err := DefaultRateCards()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
 
-	rideCoordinator := NewRideCoordinator(passengerService, driverService, rideService, notificationService, fareCalculator)
+	ledger := NewLedger()
+	paymentService := NewPaymentService(ledger, NewStubCardGateway())
+	rideCoordinator := NewRideCoordinator(passengerService, driverService, rideService, notificationService, rateCards, paymentService)
+	rideCoordinator.Surge, err = NewSurgeEngine(driverService, DefaultSurgeConfig())
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	// The demo moves its own clock, so offers time out without waiting
+	clock := NewManualClock(time.Now())
+	rideCoordinator.Clock = clock
 
 	// Add passengers and drivers
-	passenger := &Passenger{ID: 1, Name: "John", Contact: "12345", Location: &Location{Latitude: 37.77, Longitude: -122.42}}
+	passenger := &Passenger{ID: 1, Name: "John", Contact: "12345", Location: &Location{Latitude: 37.77, Longitude: -122.42}, PaymentMethod: PaymentMethod{Type: PayByWallet}}
 	driver := &Driver{ID: 1, Name: "Alice", Contact: "67890", LicensePlate: "XYZ123", Location: &Location{Latitude: 37.77, Longitude: -122.42}, Status: Available}
+	nearbyDriver := &Driver{ID: 2, Name: "Bob", Contact: "67891", LicensePlate: "XYZ124", Location: &Location{Latitude: 37.80, Longitude: -122.45}, Status: Available}
+	farDriver := &Driver{ID: 3, Name: "Carol", Contact: "67892", LicensePlate: "XYZ125", Location: &Location{Latitude: 37.34, Longitude: -121.89}, Status: Available}
 
 	passengerService.AddPassenger(passenger)
+	if err := paymentService.TopUp(passenger, "tok_visa", 100_00, clock.Now()); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
 	driverService.AddDriver(driver)
+	driverService.AddDriver(nearbyDriver)
+	driverService.AddDriver(farDriver)
+
+	// Carol drives into the city and becomes a candidate too
+	driverService.UpdateDriverLocation(farDriver, &Location{Latitude: 37.76, Longitude: -122.41})
 
 	// Request and complete a ride
-	rideCoordinator.RequestRide(passenger, passenger.Location, &Location{Latitude: 37.78, Longitude: -122.43})
+	ride, err := rideCoordinator.RequestRide(passenger, passenger.Location, &Location{Latitude: 37.78, Longitude: -122.43})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
 
-	ride := <-rideCoordinator.requestedRides
+	// A ride cannot start before a driver accepts it
+	if err := rideCoordinator.StartRide(driver, ride); err != nil {
+		fmt.Println("Error:", err)
+	}
 
-	rideCoordinator.AcceptRide(driver, ride)
+	if err := rideCoordinator.AcceptRide(driver, ride); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
 
-	rideCoordinator.StartRide(driver, ride)
+	// The first driver to accept wins the ride
+	if err := rideCoordinator.AcceptRide(farDriver, ride); err != nil {
+		fmt.Println("Error:", err)
+	}
 
-	rideCoordinator.CompleteRide(ride)
+	if err := rideCoordinator.DriverArrived(driver, ride); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	if err := rideCoordinator.StartRide(driver, ride); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	// Alice reports her position along the way and pays a toll
+	rideCoordinator.UpdateDriverLocation(driver, &Location{Latitude: 37.775, Longitude: -122.42})
+	rideCoordinator.UpdateDriverLocation(driver, &Location{Latitude: 37.78, Longitude: -122.425})
+	rideCoordinator.UpdateDriverLocation(driver, &Location{Latitude: 37.78, Longitude: -122.43})
+	if err := rideCoordinator.AddToll(driver, ride, 2.50); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	if err := rideCoordinator.CompleteRide(ride); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	// Bob accepts a second ride and cancels it, which frees him for the next
+	// one; the cancelled ride can no longer be completed. His first
+	// cancellation of the day is free
+	secondRide, err := rideCoordinator.RequestRide(passenger, passenger.Location, &Location{Latitude: 37.79, Longitude: -122.41})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if err := rideCoordinator.AcceptRide(nearbyDriver, secondRide); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if err := rideCoordinator.DriverCancelRide(nearbyDriver, secondRide, ReasonVehicleProblem); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if err := rideCoordinator.CompleteRide(secondRide); err != nil {
+		fmt.Println("Error:", err)
+	}
+	fmt.Printf("Driver %s is %s\n", nearbyDriver.Name, nearbyDriver.Status)
+
+	// Offer a third ride to one driver at a time: Alice declines, Carol lets
+	// the offer time out, and the ride ends up without a driver
+	rideCoordinator.MatchLimit = 1
+	rideCoordinator.OfferTimeout = 50 * time.Millisecond
+	rideCoordinator.MaxOfferWaves = 2
+	thirdRide, err := rideCoordinator.RequestRide(passenger, passenger.Location, &Location{Latitude: 37.76, Longitude: -122.40})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if err := rideCoordinator.DeclineRide(driver, thirdRide); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Driver %s has %d pending offer(s)\n", farDriver.Name, len(rideCoordinator.PendingOffers(farDriver)))
+	clock.Advance(2 * rideCoordinator.OfferTimeout)
+	status, err := rideService.GetRideStatus(thirdRide.ID)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Ride %d is %s\n", thirdRide.ID, status)
+
+	// John books a ride for the evening, pushes it back an hour and cancels a
+	// second booking. Drivers hear of the ride shortly before pickup
+	rideCoordinator.MaxOfferWaves = DefaultMaxOfferWaves
+	booked, err := rideCoordinator.ScheduleRide(passenger, passenger.Location, &Location{Latitude: 37.79, Longitude: -122.40}, clock.Now().Add(time.Hour), RideOptions{})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if err := rideCoordinator.AmendScheduledRide(passenger, booked, RideAmendment{PickupAt: booked.PickupAt.Add(time.Hour)}); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	spare, err := rideCoordinator.ScheduleRide(passenger, passenger.Location, &Location{Latitude: 37.79, Longitude: -122.40}, clock.Now().Add(3*time.Hour), RideOptions{})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if err := rideCoordinator.CancelScheduledRide(passenger, spare); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	clock.Advance(time.Hour)
+	if status, err := rideService.GetRideStatus(booked.ID); err == nil {
+		fmt.Printf("Ride %d is %s an hour later\n", booked.ID, status)
+	}
+	clock.Advance(time.Hour - rideCoordinator.ScheduleLeadTime)
+	if err := rideCoordinator.AcceptRide(driver, booked); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if err := rideCoordinator.CancelScheduledRide(passenger, booked); err != nil {
+		fmt.Println("Error:", err)
+	}
+
+	// John cancels after the free window, so Alice gets a late cancellation fee
+	clock.Advance(rideCoordinator.CancellationPolicy.FreeWindow + time.Minute)
+	if err := rideCoordinator.PassengerCancelRide(passenger, booked, ReasonChangeOfPlans); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	// Bob waits at the pickup of another ride, but John never shows up. Bob
+	// cancels the next ride as well, which costs him a penalty this time
+	rideCoordinator.MatchLimit = DefaultMatchLimit
+	noShowRide, err := rideCoordinator.RequestRide(passenger, passenger.Location, &Location{Latitude: 37.79, Longitude: -122.40})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	for _, step := range []func(*Driver, *Ride) error{rideCoordinator.AcceptRide, rideCoordinator.DriverArrived} {
+		if err := step(nearbyDriver, noShowRide); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+	if err := rideCoordinator.DriverCancelRide(nearbyDriver, noShowRide, ReasonPassengerNoShow); err != nil {
+		fmt.Println("Error:", err)
+	}
+	clock.Advance(rideCoordinator.CancellationPolicy.NoShowWait)
+	if err := rideCoordinator.DriverCancelRide(nearbyDriver, noShowRide, ReasonPassengerNoShow); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	penalizedRide, err := rideCoordinator.RequestRide(passenger, passenger.Location, &Location{Latitude: 37.79, Longitude: -122.40})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if err := rideCoordinator.AcceptRide(nearbyDriver, penalizedRide); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if err := rideCoordinator.DriverCancelRide(nearbyDriver, penalizedRide, ReasonUnsafePickup); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Wallets: %s %s, %s %s, %s %s\n",
+		passenger.Name, paymentService.PassengerWallet(passenger).Balance,
+		driver.Name, paymentService.DriverWallet(driver).Balance,
+		nearbyDriver.Name, paymentService.DriverWallet(nearbyDriver).Balance)
+	cancellations := rideCoordinator.DriverCancellations(nearbyDriver)
+	fmt.Printf("Driver %s cancelled %d ride(s), penalties %s\n", nearbyDriver.Name, cancellations.Total, cancellations.Penalties)
+
+	// Rush hour at the airport: requests pile up faster than the one driver
+	// there can take them, and the surge multiplier climbs
+	rideCoordinator.OfferTimeout = DefaultOfferTimeout
+	airport := &Location{Latitude: 37.62, Longitude: -122.38}
+	driverService.AddDriver(&Driver{ID: 4, Name: "Dave", Contact: "67893", LicensePlate: "XYZ126", Location: airport, Status: Available})
+	for i := 0; i < 4; i++ {
+		rider := &Passenger{ID: 10 + i, Name: fmt.Sprintf("Traveller %d", i+1), Contact: "555", Location: airport}
+		passengerService.AddPassenger(rider)
+		if _, err := rideCoordinator.RequestRide(rider, airport, passenger.Location); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+
+	// A premium ride is only offered to premium drivers, and priced with the
+	// premium rate card of the city
+	premiumDriver := &Driver{ID: 5, Name: "Erin", Contact: "67894", LicensePlate: "LUX001", VehicleClass: Premium, Location: &Location{Latitude: 37.78, Longitude: -122.41}, Status: Available}
+	driverService.AddDriver(premiumDriver)
+	premiumRide, err := rideCoordinator.RequestRideWithOptions(passenger, passenger.Location, &Location{Latitude: 37.80, Longitude: -122.44}, RideOptions{VehicleClass: Premium})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	for _, step := range []func(*Driver, *Ride) error{rideCoordinator.AcceptRide, rideCoordinator.StartRide} {
+		if err := step(premiumDriver, premiumRide); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+	if err := rideCoordinator.CompleteRide(premiumRide); err != nil {
+		fmt.Println("Error:", err)
+	}
+
+	// Grace and Heidi share Frank's car across the bay: Heidi's ride is on
+	// the way, so Frank is offered it while Grace is aboard, and each of them
+	// pays for their share of the legs they rode together
+	poolDriver := &Driver{ID: 6, Name: "Frank", Contact: "67895", LicensePlate: "POOL01", Location: &Location{Latitude: 37.80, Longitude: -122.27}, Status: Available}
+	driverService.AddDriver(poolDriver)
+	grace := &Passenger{ID: 20, Name: "Grace", Contact: "556", Location: &Location{Latitude: 37.80, Longitude: -122.27}, PaymentMethod: PaymentMethod{Type: PayByCard, CardToken: "tok_mastercard"}}
+	heidi := &Passenger{ID: 21, Name: "Heidi", Contact: "557", Location: &Location{Latitude: 37.825, Longitude: -122.268}}
+	passengerService.AddPassenger(grace)
+	passengerService.AddPassenger(heidi)
+	graceRide, err := rideCoordinator.RequestRideWithOptions(grace, grace.Location, &Location{Latitude: 37.87, Longitude: -122.27}, RideOptions{Pool: true})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	for _, step := range []func(*Driver, *Ride) error{rideCoordinator.AcceptRide, rideCoordinator.StartRide} {
+		if err := step(poolDriver, graceRide); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+	rideCoordinator.UpdateDriverLocation(poolDriver, &Location{Latitude: 37.82, Longitude: -122.27})
+	heidiRide, err := rideCoordinator.RequestRideWithOptions(heidi, heidi.Location, &Location{Latitude: 37.86, Longitude: -122.27}, RideOptions{Pool: true})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if err := rideCoordinator.AcceptRide(poolDriver, heidiRide); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	rideCoordinator.UpdateDriverLocation(poolDriver, heidi.Location)
+	if err := rideCoordinator.StartRide(poolDriver, heidiRide); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Driver %s has %d stop(s) ahead\n", poolDriver.Name, len(rideCoordinator.PoolRoute(poolDriver)))
+	rideCoordinator.UpdateDriverLocation(poolDriver, heidiRide.Destination)
+	if err := rideCoordinator.CompleteRide(heidiRide); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	rideCoordinator.UpdateDriverLocation(poolDriver, graceRide.Destination)
+	if err := rideCoordinator.CompleteRide(graceRide); err != nil {
+		fmt.Println("Error:", err)
+	}
+	fmt.Printf("Driver %s is %s\n", poolDriver.Name, poolDriver.Status)
+
+	// John was driven the long way round on his first ride and gets part of
+	// the fare back. Then the drivers are paid out: Bob paid his penalty out
+	// of the fees he earned, and Frank settles Heidi's cash fare against
+	// Grace's card fare
+	if err := rideCoordinator.RefundRide(ride, 2_00, "longer route than needed"); err != nil {
+		fmt.Println("Error:", err)
+	}
+	for _, d := range []*Driver{driver, nearbyDriver, poolDriver} {
+		if _, err := rideCoordinator.PayOutDriver(d); err != nil {
+			fmt.Println("Error:", err)
+		}
+	}
+	fmt.Printf("Wallet of %s: %s\n", passenger.Name, paymentService.PassengerWallet(passenger).Balance)
+	fmt.Printf("Platform commission %s, penalties %s, ledger balances to %s\n", -ledger.Balance(CommissionAccount), -ledger.Balance(PenaltyAccount), ledger.TrialBalance())
 }
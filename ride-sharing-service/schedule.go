@@ -0,0 +1,146 @@
+package ridesharingservice
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Errors returned when booking rides ahead
+var (
+	ErrPickupTimeInPast = errors.New("pickup time is not in the future")
+	ErrWrongPassenger   = errors.New("passenger did not book the ride")
+)
+
+// DefaultScheduleLeadTime is how long before pickup a scheduled ride starts
+// looking for a driver.
+const DefaultScheduleLeadTime = 15 * time.Minute
+
+// pickupTimeLayout formats pickup times in notifications.
+const pickupTimeLayout = "Jan 2 15:04"
+
+// scheduledRide is a booked ride waiting for its dispatch time.
+type scheduledRide struct {
+	ride  *Ride
+	timer Timer
+}
+
+// RideAmendment changes a scheduled ride. Zero fields are left as they are.
+type RideAmendment struct {
+	PickupAt    time.Time
+	Source      *Location
+	Destination *Location
+}
+
+// ScheduleRide books a ride for pickup at a later time. The passenger gets an
+// estimate now; the ride is requested and offered to drivers ScheduleLeadTime
+// before pickup, or right away if that is already past.
+func (rc *RideCoordinator) ScheduleRide(passenger *Passenger, source, destination *Location, pickupAt time.Time, options RideOptions) (*Ride, error) {
+	now := rc.Clock.Now()
+	if !pickupAt.After(now) {
+		return nil, fmt.Errorf("%w: %s", ErrPickupTimeInPast, pickupAt.Format(pickupTimeLayout))
+	}
+	ride := rc.newRide(passenger, source, destination, options)
+	ride.Status = Scheduled
+	ride.ScheduledAt = now
+	ride.PickupAt = pickupAt
+	if err := rc.estimate(ride, pickupAt); err != nil {
+		return nil, err
+	}
+	rc.rideService.AddRide(ride)
+
+	rc.scheduleMu.Lock()
+	rc.armSchedule(ride)
+	rc.scheduleMu.Unlock()
+	rc.notificationService.NotifyPassenger(passenger, fmt.Sprintf("Ride %d scheduled for pickup at %s. Estimated fare: $%.2f", ride.ID, pickupAt.Format(pickupTimeLayout), ride.EstimatedFare))
+	return ride, nil
+}
+
+// armSchedule sets the timer that dispatches a scheduled ride. The caller
+// must hold scheduleMu.
+func (rc *RideCoordinator) armSchedule(ride *Ride) {
+	entry := &scheduledRide{ride: ride}
+	delay := max(ride.PickupAt.Add(-rc.ScheduleLeadTime).Sub(rc.Clock.Now()), 0)
+	entry.timer = rc.Clock.AfterFunc(delay, func() { rc.dispatchScheduled(entry) })
+	rc.scheduled[ride.ID] = entry
+}
+
+// dispatchScheduled requests a scheduled ride once its dispatch time comes,
+// unless it was amended or cancelled in the meantime.
+func (rc *RideCoordinator) dispatchScheduled(entry *scheduledRide) {
+	ride := entry.ride
+	rc.scheduleMu.Lock()
+	if rc.scheduled[ride.ID] != entry {
+		rc.scheduleMu.Unlock()
+		return
+	}
+	delete(rc.scheduled, ride.ID)
+	err := rc.rideService.transition(ride, Requested, rc.Clock.Now(), nil)
+	rc.scheduleMu.Unlock()
+	if err != nil {
+		return
+	}
+
+	if err := rc.quote(ride); err != nil {
+		if rc.rideService.transition(ride, Unmatched, rc.Clock.Now(), nil) == nil {
+			rc.notificationService.NotifyPassenger(ride.Passenger, fmt.Sprintf("Your scheduled ride %d could not be priced: %v", ride.ID, err))
+		}
+		return
+	}
+	rc.notifyQuote(ride)
+	rc.startDispatch(ride)
+}
+
+// AmendScheduledRide changes the pickup time or the places of a ride that has
+// not started looking for a driver yet, and quotes it again.
+func (rc *RideCoordinator) AmendScheduledRide(passenger *Passenger, ride *Ride, amendment RideAmendment) error {
+	rc.scheduleMu.Lock()
+	defer rc.scheduleMu.Unlock()
+	now := rc.Clock.Now()
+	err := rc.rideService.update(ride, Scheduled, func() error {
+		if ride.Passenger != passenger {
+			return fmt.Errorf("%w: %s, ride %d", ErrWrongPassenger, passenger.Name, ride.ID)
+		}
+		if !amendment.PickupAt.IsZero() && !amendment.PickupAt.After(now) {
+			return fmt.Errorf("%w: %s", ErrPickupTimeInPast, amendment.PickupAt.Format(pickupTimeLayout))
+		}
+
+		// Keep the booking as it was if the amended ride cannot be priced
+		pickupAt, source, destination := ride.PickupAt, ride.Source, ride.Destination
+		if !amendment.PickupAt.IsZero() {
+			ride.PickupAt = amendment.PickupAt
+		}
+		if amendment.Source != nil {
+			ride.Source = amendment.Source
+		}
+		if amendment.Destination != nil {
+			ride.Destination = amendment.Destination
+		}
+		if err := rc.estimate(ride, ride.PickupAt); err != nil {
+			ride.PickupAt, ride.Source, ride.Destination = pickupAt, source, destination
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if entry := rc.scheduled[ride.ID]; entry != nil {
+		entry.timer.Stop()
+	}
+	rc.armSchedule(ride)
+	rc.notificationService.NotifyPassenger(passenger, fmt.Sprintf("Ride %d rescheduled for pickup at %s. Estimated fare: $%.2f", ride.ID, ride.PickupAt.Format(pickupTimeLayout), ride.EstimatedFare))
+	return nil
+}
+
+// CancelScheduledRide cancels a ride that has not started looking for a
+// driver yet.
+func (rc *RideCoordinator) CancelScheduledRide(passenger *Passenger, ride *Ride) error {
+	return rc.cancelByPassenger(passenger, ride, ReasonChangeOfPlans, func() error {
+		if ride.Status != Scheduled {
+			return fmt.Errorf("%w: ride %d is %s, not %s", ErrWrongRideStatus, ride.ID, ride.Status, Scheduled)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,56 @@
+package ridesharingservice
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduledRideDispatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		cancel      bool          // Passenger cancels after the ride is requested, before it is offered
+		advance     time.Duration // Clock moves on this long after booking
+		status      RideStatus
+		dispatching bool
+	}{
+		{name: "before dispatch time", advance: 10 * time.Minute, status: Scheduled},
+		{name: "at dispatch time", advance: 45 * time.Minute, status: Requested, dispatching: true},
+		{name: "cancelled between request and dispatch", cancel: true, status: Cancelled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestFixture(t)
+			f.driver(1)
+			passenger := f.passenger(t, 1, PaymentMethod{Type: PayByCash}, 0)
+			ride, err := f.rc.ScheduleRide(passenger, testPickup, testDropOff, testStart.Add(time.Hour), RideOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.cancel {
+				// Stand in for dispatchScheduled up to the point it lets go of
+				// scheduleMu, then cancel before the ride is offered
+				f.rc.scheduleMu.Lock()
+				f.rc.scheduled[ride.ID].timer.Stop()
+				delete(f.rc.scheduled, ride.ID)
+				if err := f.rides.transition(ride, Requested, f.clock.Now(), nil); err != nil {
+					t.Fatal(err)
+				}
+				f.rc.scheduleMu.Unlock()
+				if err := f.rc.PassengerCancelRide(passenger, ride, ReasonChangeOfPlans); err != nil {
+					t.Fatal(err)
+				}
+				f.rc.startDispatch(ride)
+			} else {
+				f.clock.Advance(tt.advance)
+			}
+
+			if status, _ := f.rides.GetRideStatus(ride.ID); status != tt.status {
+				t.Errorf("status = %s, want %s", status, tt.status)
+			}
+			if got := f.dispatching(ride); got != tt.dispatching {
+				t.Errorf("dispatching = %t, want %t", got, tt.dispatching)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package ridesharingservice
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidSurgeConfig = errors.New("invalid surge configuration")
+
+// SurgeConfig tunes how demand in a zone raises fares.
+type SurgeConfig struct {
+	ZonePrecision int           // Geohash length of a zone, at most 5 (cells roughly 5km across)
+	Window        time.Duration // Requests older than this no longer count as demand
+	Sensitivity   float64       // Multiplier added for each open request per available driver beyond one
+	MaxMultiplier float64       // Cap on the multiplier
+	Smoothing     float64       // Weight of a new reading against the previous multiplier, in (0, 1]
+}
+
+func DefaultSurgeConfig() SurgeConfig {
+	return SurgeConfig{
+		ZonePrecision: 5,
+		Window:        10 * time.Minute,
+		Sensitivity:   0.5,
+		MaxMultiplier: 3.0,
+		Smoothing:     0.5,
+	}
+}
+
+// SurgeEngine prices demand in zones of the map. A zone's multiplier grows
+// with the ratio of open requests made there within the window to the drivers
+// available there, and is smoothed so that it moves gradually.
+type SurgeEngine struct {
+	config        SurgeConfig
+	driverService *DriverService
+	requests      map[string][]surgeRequest // Zone -> Requests in the window, oldest first
+	open          map[int]bool              // Ride IDs still waiting for a driver
+	multipliers   map[string]float64        // Zone -> Last smoothed multiplier
+	mu            sync.Mutex
+}
+
+type surgeRequest struct {
+	rideID int
+	at     time.Time
+}
+
+func NewSurgeEngine(ds *DriverService, config SurgeConfig) (*SurgeEngine, error) {
+	if config.Sensitivity < 0 || math.IsNaN(config.Sensitivity) {
+		return nil, fmt.Errorf("%w: sensitivity %v is negative", ErrInvalidSurgeConfig, config.Sensitivity)
+	}
+	if !(config.Smoothing > 0 && config.Smoothing <= 1) {
+		return nil, fmt.Errorf("%w: smoothing %v is not in (0, 1]", ErrInvalidSurgeConfig, config.Smoothing)
+	}
+	config.ZonePrecision = min(max(config.ZonePrecision, 1), driverCellPrecision)
+	return &SurgeEngine{
+		config:        config,
+		driverService: ds,
+		requests:      make(map[string][]surgeRequest),
+		open:          make(map[int]bool),
+		multipliers:   make(map[string]float64),
+	}, nil
+}
+
+// Zone returns the zone a location belongs to.
+func (se *SurgeEngine) Zone(location *Location) string {
+	return geohash(location, se.config.ZonePrecision)
+}
+
+// RecordRequest counts a requested ride as open demand in its pickup zone.
+func (se *SurgeEngine) RecordRequest(ride *Ride) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	zone := se.Zone(ride.Source)
+	se.requests[zone] = append(se.prune(zone, ride.RequestedAt), surgeRequest{rideID: ride.ID, at: ride.RequestedAt})
+	se.open[ride.ID] = true
+}
+
+// ResolveRequest stops counting a ride as open demand, once it is accepted or
+// will never be.
+func (se *SurgeEngine) ResolveRequest(ride *Ride) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	delete(se.open, ride.ID)
+}
+
+// Multiplier takes a new reading of the zone of location at time now and
+// returns its smoothed multiplier, rounded to one decimal.
+func (se *SurgeEngine) Multiplier(location *Location, now time.Time) float64 {
+	zone := se.Zone(location)
+	available := se.driverService.countAvailableDrivers(zone)
+
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	openRequests := 0
+	for _, request := range se.prune(zone, now) {
+		if se.open[request.rideID] {
+			openRequests++
+		}
+	}
+
+	ratio := float64(openRequests) / float64(max(available, 1))
+	reading := math.Min(1+se.config.Sensitivity*math.Max(0, ratio-1), se.config.MaxMultiplier)
+	previous, exists := se.multipliers[zone]
+	if !exists {
+		previous = 1
+	}
+	multiplier := previous + se.config.Smoothing*(reading-previous)
+	se.multipliers[zone] = multiplier
+	return math.Max(1, math.Round(multiplier*10)/10)
+}
+
+// prune drops the requests of zone that are out of the window at time now
+// and returns those left. The caller must hold se.mu.
+func (se *SurgeEngine) prune(zone string, now time.Time) []surgeRequest {
+	requests := se.requests[zone]
+	for len(requests) > 0 && now.Sub(requests[0].at) > se.config.Window {
+		delete(se.open, requests[0].rideID)
+		requests = requests[1:]
+	}
+	if len(requests) == 0 {
+		delete(se.requests, zone)
+	} else {
+		se.requests[zone] = requests
+	}
+	return requests
+}
+
+// countAvailableDrivers counts the available drivers in a zone, given as a
+// geohash no longer than the cells of the index.
+func (ds *DriverService) countAvailableDrivers(zone string) int {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	count := 0
+	for cell, drivers := range ds.index.cells {
+		if !strings.HasPrefix(cell, zone) {
+			continue
+		}
+		for _, driver := range drivers {
+			if driver.Status == Available {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// resolveDemand tells the surge engine, if any, that a ride no longer waits
+// for a driver.
+func (rc *RideCoordinator) resolveDemand(ride *Ride) {
+	if rc.Surge != nil {
+		rc.Surge.ResolveRequest(ride)
+	}
+}
@@ -0,0 +1,186 @@
+package ridesharingservice
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSurgeMultiplier(t *testing.T) {
+	far := north(testPickup, 50)
+	tests := []struct {
+		name      string
+		drivers   int
+		requests  int       // Open requests made at the pickup just now
+		resolved  int       // Of those, accepted since
+		stale     int       // Requests made at the pickup before the window
+		elsewhere int       // Requests made in another zone
+		smoothing float64   // Overrides the smoothing of 1 if set
+		readings  []float64 // Multipliers of successive readings
+	}{
+		{name: "no demand", drivers: 1, readings: []float64{1}},
+		{name: "as many requests as drivers", drivers: 2, requests: 2, readings: []float64{1}},
+		{name: "two requests per driver", drivers: 1, requests: 2, readings: []float64{1.5}},
+		{name: "four requests per driver", drivers: 1, requests: 4, readings: []float64{2.5}},
+		{name: "capped", drivers: 1, requests: 10, readings: []float64{3}},
+		{name: "no drivers count as one", requests: 3, readings: []float64{2}},
+		{name: "accepted requests no longer count", drivers: 1, requests: 4, resolved: 1, readings: []float64{2}},
+		{name: "requests outside the window no longer count", drivers: 1, requests: 2, stale: 2, readings: []float64{1.5}},
+		{name: "demand in another zone", drivers: 1, elsewhere: 4, readings: []float64{1}},
+		{name: "smoothed", drivers: 1, requests: 4, smoothing: 0.5, readings: []float64{1.8, 2.1, 2.3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := NewDriverService()
+			for i := 0; i < tt.drivers; i++ {
+				ds.AddDriver(&Driver{ID: i + 1, Location: testPickup, Status: Available})
+			}
+			// A busy driver does not take demand off the zone.
+			ds.AddDriver(&Driver{ID: 100, Location: testPickup, Status: Busy})
+			config := DefaultSurgeConfig()
+			config.Smoothing = 1
+			if tt.smoothing > 0 {
+				config.Smoothing = tt.smoothing
+			}
+			se, err := NewSurgeEngine(ds, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			id := 0
+			request := func(location *Location, at time.Time) *Ride {
+				id++
+				ride := &Ride{ID: id, Source: location, RequestedAt: at}
+				se.RecordRequest(ride)
+				return ride
+			}
+			for i := 0; i < tt.stale; i++ {
+				request(testPickup, testStart.Add(-config.Window-time.Minute))
+			}
+			for i := 0; i < tt.elsewhere; i++ {
+				request(far, testStart)
+			}
+			for i := 0; i < tt.requests; i++ {
+				ride := request(testPickup, testStart)
+				if i < tt.resolved {
+					se.ResolveRequest(ride)
+				}
+			}
+
+			for i, want := range tt.readings {
+				if got := se.Multiplier(testPickup, testStart); got != want {
+					t.Errorf("reading %d = %.1f, want %.1f", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewSurgeEngineConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		sensitivity float64
+		smoothing   float64
+		wantErr     bool
+	}{
+		{name: "defaults", sensitivity: 0.5, smoothing: 0.5},
+		{name: "no sensitivity", sensitivity: 0, smoothing: 0.5},
+		{name: "no smoothing", sensitivity: 0.5, smoothing: 1},
+		{name: "negative sensitivity", sensitivity: -0.5, smoothing: 0.5, wantErr: true},
+		{name: "zero smoothing", sensitivity: 0.5, smoothing: 0, wantErr: true},
+		{name: "negative smoothing", sensitivity: 0.5, smoothing: -0.5, wantErr: true},
+		{name: "smoothing above one", sensitivity: 0.5, smoothing: 1.5, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultSurgeConfig()
+			config.Sensitivity = tt.sensitivity
+			config.Smoothing = tt.smoothing
+			_, err := NewSurgeEngine(NewDriverService(), config)
+			if tt.wantErr != errors.Is(err, ErrInvalidSurgeConfig) {
+				t.Errorf("NewSurgeEngine() error = %v, want an error: %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSurgePrunesOnRecord(t *testing.T) {
+	tests := []struct {
+		name string
+		ages []time.Duration // Age of each earlier request when the last one is made
+		kept int             // Requests tracked in the zone after the last one
+	}{
+		{name: "first request", kept: 1},
+		{name: "recent requests", ages: []time.Duration{5 * time.Minute, time.Minute}, kept: 3},
+		{name: "stale requests", ages: []time.Duration{time.Hour, 20 * time.Minute}, kept: 1},
+		{name: "stale then recent", ages: []time.Duration{time.Hour, time.Minute}, kept: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			se, err := NewSurgeEngine(NewDriverService(), DefaultSurgeConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, age := range tt.ages {
+				se.RecordRequest(&Ride{ID: i + 1, Source: testPickup, RequestedAt: testStart.Add(-age)})
+			}
+			se.RecordRequest(&Ride{ID: len(tt.ages) + 1, Source: testPickup, RequestedAt: testStart})
+
+			zone := se.Zone(testPickup)
+			if got := len(se.requests[zone]); got != tt.kept {
+				t.Errorf("%d requests tracked, want %d", got, tt.kept)
+			}
+			if got := len(se.open); got != tt.kept {
+				t.Errorf("%d requests open, want %d", got, tt.kept)
+			}
+		})
+	}
+}
+
+func TestSurgeQuote(t *testing.T) {
+	tests := []struct {
+		name       string
+		others     int // Rides requested at the pickup before, still waiting for a driver
+		multiplier float64
+	}{
+		{name: "calm", multiplier: 1},
+		{name: "two requests for the one driver", others: 1, multiplier: 1.5},
+		{name: "four requests for the one driver", others: 3, multiplier: 2.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestFixture(t)
+			config := DefaultSurgeConfig()
+			config.Smoothing = 1
+			surge, err := NewSurgeEngine(f.drivers, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.rc.Surge = surge
+			f.driver(1)
+			passenger := f.passenger(t, 1, PaymentMethod{Type: PayByCash}, 0)
+			destination := north(testPickup, 10)
+			for i := 0; i < tt.others; i++ {
+				if _, err := f.rc.RequestRide(passenger, testPickup, destination); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ride, err := f.rc.RequestRide(passenger, testPickup, destination)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ride.SurgeMultiplier != tt.multiplier {
+				t.Errorf("SurgeMultiplier = %.1f, want %.1f", ride.SurgeMultiplier, tt.multiplier)
+			}
+			// The 10 km ride costs $23.50 without surge, $1.50 of it the booking fee.
+			if want := (23.5-1.5)*tt.multiplier + 1.5; !near(ride.EstimatedFare, want) {
+				t.Errorf("EstimatedFare = $%.2f, want $%.2f", ride.EstimatedFare, want)
+			}
+		})
+	}
+}
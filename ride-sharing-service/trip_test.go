@@ -0,0 +1,90 @@
+package ridesharingservice
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRideEstimate(t *testing.T) {
+	tests := []struct {
+		name        string
+		destination *Location
+		distance    float64 // Kilometres
+		duration    float64 // Minutes
+		fare        float64
+	}{
+		{name: "minimum fare", destination: north(testPickup, 1), distance: 1, duration: 2, fare: 7},
+		{name: "across town", destination: north(testPickup, 10), distance: 10, duration: 20, fare: 23.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestFixture(t)
+			passenger := f.passenger(t, 1, PaymentMethod{Type: PayByCash}, 0)
+			ride, err := f.rc.RequestRide(passenger, testPickup, tt.destination)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !near(ride.EstimatedDistance, tt.distance) || !near(ride.EstimatedDuration, tt.duration) || !near(ride.EstimatedFare, tt.fare) {
+				t.Errorf("estimate = %.2f km, %.1f min, $%.2f, want %.2f km, %.1f min, $%.2f",
+					ride.EstimatedDistance, ride.EstimatedDuration, ride.EstimatedFare, tt.distance, tt.duration, tt.fare)
+			}
+		})
+	}
+}
+
+func TestRecordedTripFare(t *testing.T) {
+	destination := north(testPickup, 1)
+	tests := []struct {
+		name     string
+		path     []float64 // Kilometres north of the pickup the driver reports during the trip
+		minutes  int
+		distance float64
+		fare     float64
+	}{
+		{name: "no positions reported", minutes: 4, distance: 1, fare: 7},
+		{name: "detour", path: []float64{2, 5, 1}, minutes: 12, distance: 9, fare: 2 + 9*1.5 + 12*0.25 + 1.5},
+		{name: "stuck in traffic", path: []float64{0.5, 1}, minutes: 30, distance: 1, fare: 2 + 1.5 + 30*0.25 + 1.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestFixture(t)
+			driver := f.driver(1)
+			passenger := f.passenger(t, 1, PaymentMethod{Type: PayByCash}, 0)
+			ride, err := f.rc.RequestRide(passenger, testPickup, destination)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := f.rc.AcceptRide(driver, ride); err != nil {
+				t.Fatal(err)
+			}
+			// Positions before the trip are not part of it.
+			f.rc.UpdateDriverLocation(driver, north(testPickup, 3))
+			f.rc.UpdateDriverLocation(driver, testPickup)
+			if err := f.rc.StartRide(driver, ride); err != nil {
+				t.Fatal(err)
+			}
+			for _, km := range tt.path {
+				f.clock.Advance(time.Duration(tt.minutes) * time.Minute / time.Duration(len(tt.path)+1))
+				f.rc.UpdateDriverLocation(driver, north(testPickup, km))
+			}
+			f.clock.Advance(ride.StartedAt.Add(time.Duration(tt.minutes) * time.Minute).Sub(f.clock.Now()))
+			if err := f.rc.CompleteRide(ride); err != nil {
+				t.Fatal(err)
+			}
+			f.rc.UpdateDriverLocation(driver, north(testPickup, 20))
+
+			if !near(ride.Distance, tt.distance) || !near(ride.Duration, float64(tt.minutes)) || !near(ride.Fare, tt.fare) {
+				t.Errorf("trip = %.2f km, %.1f min, $%.2f, want %.2f km, %d min, $%.2f",
+					ride.Distance, ride.Duration, ride.Fare, tt.distance, tt.minutes, tt.fare)
+			}
+		})
+	}
+}
+
+// near tells whether two amounts agree to the cent.
+func near(got, want float64) bool {
+	return math.Abs(got-want) < 0.005
+}
@@ -0,0 +1,58 @@
+package ridesharingservice
+
+import (
+	"fmt"
+	"time"
+)
+
+// WalletEntry is one credit or debit of a wallet.
+type WalletEntry struct {
+	At          time.Time
+	RideID      int
+	Description string
+	Amount      Money // Credit if positive, debit if negative
+}
+
+// Wallet is the balance of a passenger or a driver with the entries that
+// make it up, as recorded in the ledger.
+type Wallet struct {
+	Balance Money
+	Entries []WalletEntry
+}
+
+// PassengerWallet returns a passenger's wallet. Money held for rides not yet
+// paid is not part of the balance.
+func (pay *PaymentService) PassengerWallet(passenger *Passenger) Wallet {
+	return pay.wallet(PassengerWalletAccount(passenger))
+}
+
+// DriverWallet returns what the platform owes a driver: their earnings less
+// the cash fares they collected. A negative balance is owed by the driver.
+func (pay *PaymentService) DriverWallet(driver *Driver) Wallet {
+	return pay.wallet(DriverEarningsAccount(driver), DriverCashAccount(driver))
+}
+
+func (pay *PaymentService) wallet(accounts ...Account) Wallet {
+	wallet := Wallet{Entries: pay.ledger.entries(accounts...)}
+	for _, entry := range wallet.Entries {
+		wallet.Balance += entry.Amount
+	}
+	return wallet
+}
+
+// TopUp adds money to a passenger's wallet, charged to a card.
+func (pay *PaymentService) TopUp(passenger *Passenger, cardToken string, amount Money, at time.Time) error {
+	if amount <= 0 {
+		return fmt.Errorf("%w: top-up of %s", ErrInvalidAmount, amount)
+	}
+	pay.mu.Lock()
+	defer pay.mu.Unlock()
+	authorization, err := pay.gateway.Authorize(cardToken, amount)
+	if err != nil {
+		return err
+	}
+	if err := pay.gateway.Capture(authorization, amount); err != nil {
+		return err
+	}
+	return pay.ledger.Post(at, 0, "Wallet top-up", debit(CardAccount, amount), credit(PassengerWalletAccount(passenger), amount))
+}